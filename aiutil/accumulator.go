@@ -0,0 +1,154 @@
+package aiutil
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// =================================================================================
+// 流式响应累加器
+// =================================================================================
+
+// StreamOptions 是流式请求的附加选项
+type StreamOptions struct {
+	// IncludeUsage 为 true 时，服务端会在流的最后额外发送一个 choices 为空、携带 usage 的数据块
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// StreamAccumulator 将流式响应的数据块按候选回复的 Index 合并为完整的 ChatResponse，
+// 包括内容、思考过程、工具调用、结束原因以及最后一个数据块中的用量。零值可以直接使用，非并发安全。
+//
+// 使用示例
+//
+//	var acc aiutil.StreamAccumulator
+//	for event := range stream {
+//		if event.Error != nil {
+//			return event.Error
+//		}
+//		fmt.Print(event.Data.Choices[0].Delta.Content)
+//		acc.Add(event.Data)
+//	}
+//	resp := acc.Response()
+type StreamAccumulator struct {
+	id      string
+	object  string
+	created int64
+	model   string
+	usage   *Usage
+	choices []*accumulatedChoice // 按 Index 升序排列
+}
+
+type accumulatedChoice struct {
+	index        int
+	role         string
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    []ToolCall
+	finishReason string
+}
+
+// Add 合并一个数据块
+func (a *StreamAccumulator) Add(chunk ChatStreamResponse) {
+	if chunk.ID != "" {
+		a.id = chunk.ID
+	}
+	if chunk.Object != "" {
+		a.object = chunk.Object
+	}
+	if chunk.Created != 0 {
+		a.created = chunk.Created
+	}
+	if chunk.Model != "" {
+		a.model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		usage := *chunk.Usage
+		a.usage = &usage
+	}
+
+	for _, delta := range chunk.Choices {
+		choice := a.choice(delta.Index)
+		if delta.Delta.Role != "" {
+			choice.role = delta.Delta.Role
+		}
+		choice.content.WriteString(delta.Delta.Content)
+		choice.reasoning.WriteString(delta.Delta.ReasoningContent)
+		choice.toolCalls = mergeToolCallDeltas(choice.toolCalls, delta.Delta.ToolCalls)
+		if delta.FinishReason != "" {
+			choice.finishReason = delta.FinishReason
+		}
+	}
+}
+
+// choice 返回 Index 对应的候选回复，不存在时按顺序插入一个新的
+func (a *StreamAccumulator) choice(index int) *accumulatedChoice {
+	pos := sort.Search(len(a.choices), func(i int) bool { return a.choices[i].index >= index })
+	if pos < len(a.choices) && a.choices[pos].index == index {
+		return a.choices[pos]
+	}
+	choice := &accumulatedChoice{index: index}
+	a.choices = append(a.choices, nil)
+	copy(a.choices[pos+1:], a.choices[pos:])
+	a.choices[pos] = choice
+	return choice
+}
+
+// Usage 返回流中携带的用量，服务端没有发送用量时返回 nil
+func (a *StreamAccumulator) Usage() *Usage {
+	return a.usage
+}
+
+// Message 返回第一个候选回复的完整消息，流中没有任何候选回复时返回一条空的 assistant 消息
+func (a *StreamAccumulator) Message() ChatMessage {
+	if len(a.choices) == 0 {
+		return ChatMessage{Role: RoleAssistant}
+	}
+	return a.choices[0].message()
+}
+
+// Response 返回到目前为止合并得到的完整响应，可以在流结束前调用
+func (a *StreamAccumulator) Response() *ChatResponse {
+	resp := &ChatResponse{ID: a.id, Object: "chat.completion", Created: a.created, Model: a.model}
+	if a.usage != nil {
+		resp.Usage = *a.usage
+	}
+	for _, choice := range a.choices {
+		resp.Choices = append(resp.Choices, ChatChoice{
+			Index:        choice.index,
+			Message:      choice.message(),
+			FinishReason: choice.finishReason,
+		})
+	}
+	return resp
+}
+
+func (c *accumulatedChoice) message() ChatMessage {
+	role := c.role
+	if role == "" {
+		role = RoleAssistant
+	}
+	// 复制一份再清除 Index，使后续的 Add 仍能按 Index 拼接
+	calls := append([]ToolCall(nil), c.toolCalls...)
+	return ChatMessage{
+		Role:             role,
+		Content:          c.content.String(),
+		ToolCalls:        completeToolCalls(calls),
+		ReasoningContent: c.reasoning.String(),
+	}
+}
+
+// CollectStream 读取流中的全部事件并合并为完整的响应。
+// 流中的错误不会中断读取，全部读完后通过 errors.Join 一并返回，此时响应仍包含已收到的内容。
+func CollectStream(stream <-chan StreamEvent) (*ChatResponse, error) {
+	var acc StreamAccumulator
+	var errs []error
+	for event := range stream {
+		if event.Error != nil {
+			errs = append(errs, event.Error)
+			continue
+		}
+		acc.Add(event.Data)
+	}
+	return acc.Response(), errors.Join(errs...)
+}
@@ -0,0 +1,121 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStreamAccumulator(t *testing.T) {
+	index0, index1 := 0, 1
+	chunks := []ChatStreamResponse{
+		{ID: "chatcmpl-1", Model: "gpt", Created: 100, Choices: []ChatStreamChoice{
+			{Index: 1, Delta: ChatDelta{Role: RoleAssistant, Content: "B"}},
+			{Index: 0, Delta: ChatDelta{Role: RoleAssistant, ReasoningContent: "思"}},
+		}},
+		{Choices: []ChatStreamChoice{{Index: 0, Delta: ChatDelta{ReasoningContent: "考", Content: "A"}}}},
+		{Choices: []ChatStreamChoice{
+			{Index: 0, Delta: ChatDelta{Content: "甲"}},
+			{Index: 1, Delta: ChatDelta{ToolCalls: []ToolCall{
+				{Index: &index0, ID: "call_a", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":`}},
+			}}},
+		}},
+		{Choices: []ChatStreamChoice{
+			{Index: 1, Delta: ChatDelta{ToolCalls: []ToolCall{
+				{Index: &index0, Function: FunctionCall{Arguments: `"北京"}`}},
+				{Index: &index1, ID: "call_b", Type: "function", Function: FunctionCall{Name: "get_time", Arguments: `{}`}},
+			}}},
+		}},
+		{Choices: []ChatStreamChoice{
+			{Index: 0, FinishReason: "stop"},
+			{Index: 1, FinishReason: "tool_calls"},
+		}},
+		{Choices: []ChatStreamChoice{}, Usage: &Usage{PromptTokens: 10, CompletionTokens: 6, TotalTokens: 16}},
+	}
+
+	var acc StreamAccumulator
+	for _, chunk := range chunks {
+		acc.Add(chunk)
+	}
+	resp := acc.Response()
+
+	if resp.ID != "chatcmpl-1" || resp.Model != "gpt" || resp.Created != 100 || resp.Usage.TotalTokens != 16 {
+		t.Errorf("响应元信息或用量错误: %+v", resp)
+	}
+	if len(resp.Choices) != 2 || resp.Choices[0].Index != 0 || resp.Choices[1].Index != 1 {
+		t.Fatalf("候选回复应按 Index 排列: %+v", resp.Choices)
+	}
+	if resp.Choices[0].Message.Content != "A甲" || resp.Choices[0].Message.ReasoningContent != "思考" || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("第一个候选回复错误: %+v", resp.Choices[0])
+	}
+	calls := resp.Choices[1].Message.ToolCalls
+	if len(calls) != 2 || calls[0].Function.Arguments != `{"city":"北京"}` || calls[1].ID != "call_b" ||
+		calls[0].Index != nil || resp.Choices[1].FinishReason != "tool_calls" {
+		t.Errorf("工具调用合并错误: %+v", calls)
+	}
+	if msg := acc.Message(); msg.Role != RoleAssistant || msg.Content != "A甲" {
+		t.Errorf("Message 应返回第一个候选回复: %+v", msg)
+	}
+
+	// 取得响应后继续合并，工具调用仍应按 Index 拼接
+	acc.Add(ChatStreamResponse{Choices: []ChatStreamChoice{{Index: 1, Delta: ChatDelta{ToolCalls: []ToolCall{
+		{Index: &index1, Function: FunctionCall{Arguments: " "}},
+	}}}}})
+	if calls := acc.Response().Choices[1].Message.ToolCalls; len(calls) != 2 || calls[1].Function.Arguments != "{} " {
+		t.Errorf("调用 Response 后继续合并错误: %+v", calls)
+	}
+}
+
+func TestCollectStream_IncludeUsage(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := decodeRequest(t, r)
+		if opts, _ := payload["stream_options"].(map[string]any); opts["include_usage"] != true {
+			t.Errorf("请求应携带 stream_options.include_usage: %v", payload)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"id":"c1","model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","content":"你好"}}]}`,
+			`{"id":"c1","model":"gpt","choices":[{"index":0,"delta":{"content":"世界"},"finish_reason":"stop"}]}`,
+			`{"id":"c1","model":"gpt","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+
+	request := ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}, StreamOptions: &StreamOptions{IncludeUsage: true}}
+	stream, err := client.CreateChatCompletionSSEStream(context.Background(), request)
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err := CollectStream(stream)
+	if err != nil {
+		t.Fatalf("流中出现错误: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "你好世界" || resp.Choices[0].FinishReason != "stop" || resp.Usage.TotalTokens != 5 {
+		t.Errorf("合并结果错误: %+v", resp)
+	}
+
+	history := client.GetHistory()
+	if last := history[len(history)-1]; last.Role != RoleAssistant || last.Content != "你好世界" {
+		t.Errorf("历史记录应保存完整回复: %+v", last)
+	}
+}
+
+func TestCollectStream_Errors(t *testing.T) {
+	stream := make(chan StreamEvent, 3)
+	stream <- StreamEvent{Data: ChatStreamResponse{Choices: []ChatStreamChoice{{Delta: ChatDelta{Content: "部分"}}}}}
+	stream <- StreamEvent{Error: fmt.Errorf("boom")}
+	stream <- StreamEvent{Data: ChatStreamResponse{Choices: []ChatStreamChoice{{Delta: ChatDelta{Content: "内容"}}}}}
+	close(stream)
+
+	resp, err := CollectStream(stream)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("期望返回流中的错误，实际 %v", err)
+	}
+	if resp.Choices[0].Message.Content != "部分内容" {
+		t.Errorf("出错时仍应返回已收到的内容: %+v", resp)
+	}
+}
@@ -1,22 +1,21 @@
-package main
+package aiutil
 
 import (
-	"bufio"
 	"bytes"
+	"container/list"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"runtime/debug"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/net/websocket"
 )
 
 // =================================================================================
@@ -24,25 +23,61 @@ import (
 //    用户可以根据不同的 AI 提供商，定义自己的请求和响应结构体。
 // =================================================================================
 
+// 常用的消息角色
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
 // ChatMessage 代表一次对话中的单条消息
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
-	// 可根据需要添加其他字段, 如 Name, ToolCalls 等
+	// MultiContent 是多模态消息的内容 (文本、图片、音频、文件)，不为空时代替 Content 以数组形式发送。
+	// 可以使用 UserMessage、TextPart、ImagePartFromFile 等函数构造。
+	MultiContent []ContentPart `json:"-"`
+	Name         string        `json:"name,omitempty"`
+	// ToolCalls 是模型在 assistant 消息中发起的工具调用
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID 是 tool 角色消息所响应的工具调用 ID
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// ReasoningContent 是推理模型 (例如 DeepSeek R1) 在回复之前输出的思考过程，与 Content 分开保存
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
+// 推理模型的思考程度，用于 ChatRequest.ReasoningEffort
+const (
+	ReasoningEffortLow    = "low"
+	ReasoningEffortMedium = "medium"
+	ReasoningEffortHigh   = "high"
+)
+
 // ChatRequest 是我们封装的、通用的对话请求结构
 type ChatRequest struct {
-	Model            string        `json:"model"`
-	Messages         []ChatMessage `json:"messages"`
-	Stream           bool          `json:"stream,omitempty"`
-	Temperature      float32       `json:"temperature,omitempty"`
-	TopP             float32       `json:"top_p,omitempty"`
-	MaxTokens        int           `json:"max_tokens,omitempty"`
-	N                int           `json:"n,omitempty"`
-	Stop             []string      `json:"stop,omitempty"`
-	PresencePenalty  float32       `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float32       `json:"frequency_penalty,omitempty"`
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream,omitempty"`
+	// StreamOptions 是流式请求的附加选项，例如在流的最后返回用量
+	StreamOptions    *StreamOptions `json:"stream_options,omitempty"`
+	Temperature      float32        `json:"temperature,omitempty"`
+	TopP             float32        `json:"top_p,omitempty"`
+	MaxTokens        int            `json:"max_tokens,omitempty"`
+	N                int            `json:"n,omitempty"`
+	Stop             []string       `json:"stop,omitempty"`
+	PresencePenalty  float32        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32        `json:"frequency_penalty,omitempty"`
+	// Tools 是模型可调用的工具列表
+	Tools []Tool `json:"tools,omitempty"`
+	// ToolChoice 控制模型是否以及如何调用工具,
+	// 可以是 "auto"、"none"、"required" 或 ToolChoiceFunction 的返回值
+	ToolChoice any `json:"tool_choice,omitempty"`
+	// ResponseFormat 约束回复的格式，例如 JSON 对象或符合指定 JSON Schema 的 JSON
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// ReasoningEffort 控制推理模型的思考程度，可选 ReasoningEffortLow、ReasoningEffortMedium、ReasoningEffortHigh。
+	// Anthropic 和 Gemini 会被转换为对应的思考 Token 预算，Ollama 会开启思考模式。
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
 	// ... 其他官方支持的参数 ...
 
 	// CustomParams 用于存放任何非官方、模型特定的参数。
@@ -50,42 +85,62 @@ type ChatRequest struct {
 	CustomParams map[string]any `json:"-"` // 这个字段不直接参与序列化
 
 	// RequestEndpoint 允许覆盖客户端配置中的默认端点。
-	// 这使得同一个客户端可以调用不同的API，如 /chat/completions, /embeddings 等。
+	// 用于调用兼容 Chat 格式的其他路径；向量嵌入请使用 CreateEmbeddings。
 	RequestEndpoint string `json:"-"`
 }
 
+// Usage 是一次请求的 Token 用量
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// PromptTokensDetails 是输入 Token 的明细，例如命中缓存的 Token 数
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// ChatChoice 是同步响应中的单个候选回复
+type ChatChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
 // ChatResponse 是同步模式的响应结构
 type ChatResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index        int         `json:"index"`
-		Message      ChatMessage `json:"message"`
-		FinishReason string      `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   Usage        `json:"usage"`
+}
+
+// ChatDelta 是流式响应中单个数据块携带的增量内容
+type ChatDelta struct {
+	Content string `json:"content"`
+	Role    string `json:"role"`
+	// ToolCalls 是工具调用的增量片段, 需要按 Index 拼接成完整的调用
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ReasoningContent 是思考过程的增量片段
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// ChatStreamChoice 是流式响应中单个候选回复的增量
+type ChatStreamChoice struct {
+	Index        int       `json:"index"`
+	Delta        ChatDelta `json:"delta"`
+	FinishReason string    `json:"finish_reason"`
 }
 
 // ChatStreamResponse 是流式模式下，每个数据块(chunk)的响应结构
 type ChatStreamResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index int `json:"index"`
-		Delta struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
-		} `json:"delta"`
-		FinishReason string `json:"finish_reason"`
-	} `json:"choices"`
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []ChatStreamChoice `json:"choices"`
+	// Usage 只出现在设置了 StreamOptions.IncludeUsage 时的最后一个数据块中
+	Usage *Usage `json:"usage,omitempty"`
 }
 
 // StreamEvent 封装了流式响应的数据或可能发生的错误
@@ -106,6 +161,44 @@ type Config struct {
 	HTTPClient       *http.Client
 	Timeout          time.Duration
 	MaxHistoryTokens int // 用于自动历史截断的最大Token数
+	// Tokenizer 用于计算历史截断时的 Token 数, 为空时使用 EstimateTokenizer 粗略估算。
+	// 需要与服务商计费一致时，请使用 LoadBPETokenizer 加载模型对应的词表。
+	Tokenizer Tokenizer
+	// TokenOverhead 是每条消息和每次回复在内容之外的 Token 开销
+	TokenOverhead TokenOverhead
+	// HistoryPruner 决定超出 MaxHistoryTokens 时保留哪些历史消息, 为空时使用 RecentPruner
+	HistoryPruner HistoryPruner
+	// ExcludeReasoningFromHistory 为 true 时，历史消息中的 ReasoningContent 不会随后续请求发送
+	// (历史记录中仍然保留)。DeepSeek 等服务商不接受请求中携带 reasoning_content，需要开启。
+	ExcludeReasoningFromHistory bool
+	// Retry 是请求失败后的重试策略, 为空时不重试。流式请求只在收到响应头之前重试。
+	Retry *RetryPolicy
+	// Endpoints 配置多个服务端点，按权重轮询并在端点故障时自动切换，为空时使用 BaseURL。
+	// 配置后 BaseURL 只用于计算缓存键，不再用于发送请求。
+	Endpoints []Endpoint
+	// CircuitBreaker 是 Endpoints 中各端点的熔断策略
+	CircuitBreaker CircuitBreaker
+	// HistoryStore 用于保存各个会话的历史记录, 为空时使用 MemoryHistoryStore
+	HistoryStore HistoryStore
+	// MaxConversations 是 Client 在内存中保留的会话数，超出后淘汰最久未使用的会话，
+	// 为 0 时使用 DefaultMaxConversations，为负数时不限制。
+	// 淘汰只丢弃会话对象和它的 Usage 统计，历史记录仍保存在 HistoryStore 中，再次获取该会话时继续使用；
+	// 需要释放历史记录 (例如使用 MemoryHistoryStore 时) 请调用 DeleteConversation。
+	MaxConversations int
+	// WebSocketDialer 用于建立 WebSocket 连接，为空时使用 DefaultWebSocketDialer
+	WebSocketDialer WebSocketDialer
+	// WebSocket 配置 WebSocket 流式请求的消息解码、超时、保活和断线续传
+	WebSocket WebSocketOptions
+	// Cache 是可选的响应缓存，为空时不缓存
+	Cache *ResponseCache
+	// Provider 负责与服务商的请求/响应格式互相转换, 为空时使用 OpenAIProvider。
+	// 也可以直接使用 DefaultAnthropicConfig、DefaultGeminiConfig、DefaultOllamaConfig 创建配置。
+	Provider Provider
+	// Prices 是按模型配置的价格表，用于计算 Usage 统计和 OnUsage 中的费用
+	Prices PriceTable
+	// OnUsage 在每次请求完成后以该请求的用量调用，可用于导出计费数据。
+	// 它在发起请求的 goroutine 中同步调用，需要并发安全且尽快返回。
+	OnUsage func(event UsageEvent)
 }
 
 // DefaultConfig 创建一个默认配置
@@ -120,149 +213,41 @@ func DefaultConfig(authToken string) Config {
 		},
 		Timeout:          120 * time.Second,
 		MaxHistoryTokens: 4096, // 默认保留4k token的历史上下文
+		TokenOverhead:    DefaultTokenOverhead,
 	}
 }
 
 // Client 核心客户端 Client (仅依赖标准库)
 // 使用示例
 //
-//	apiKey := os.Getenv("OPENAI_API_KEY")
-//	if apiKey == "" {
-//		log.Fatal("请设置环境变量 OPENAI_API_KEY")
-//	}
-//
-//	// --- 客户端配置 ---
+//	config := aiutil.DefaultConfig(os.Getenv("OPENAI_API_KEY"))
 //	// 可以指向任何兼容 OpenAI API 的服务, 只需修改 BaseURL。
-//	// 也可以通过修改 DefaultHeaders 支持不同认证方式。
-//	config := DefaultConfig(apiKey)
-//	// config.BaseURL = "https://api.groq.com/openai/v1" // 示例：切换到 Groq
-//	// config.BaseURL = "https://open.bigmodel.cn/api/paas/v4" // 示例：切换到智谱AI
-//	// config.DefaultHeaders["Authorization"] = "Bearer " + os.Getenv("ZHIPU_API_KEY")  // 自定义认证方式
-//
-//	config.MaxHistoryTokens = 2000 // 设置较小的历史记录，方便演示截断
-//
-//	client := NewClient(config)
-//
-//	// --- 示例1：同步调用，并使用自定义参数 ---
-//	fmt.Println("--- 1. 同步调用 (Sync Call) ---")
-//	syncRequest := ChatRequest{
-//		Model: "gpt-4o-mini",
-//		Messages: []ChatMessage{
-//			{Role: "user", Content: "你好，请介绍一下自己。"},
-//		},
-//		Temperature: 0.7,
-//		// 示例：为智谱AI添加自定义参数
-//		// CustomParams: map[string]any{
-//		// 	"request_id": fmt.Sprintf("my-app-%d", time.Now().Unix()),
-//		// },
-//	}
-//
-//	resp, err := client.CreateChatCompletion(context.Background(), syncRequest)
-//	if err != nil {
-//		log.Fatalf("同步调用失败: %v", err)
-//	}
-//	fmt.Printf("同步回复: %s\n\n", resp.Choices[0].Message.Content)
-//
-//	// --- 示例2：第二次同步调用，测试历史上下文 ---
-//	fmt.Println("--- 2. 第二次同步调用 (Testing History) ---")
-//	secondSyncRequest := ChatRequest{
-//		Model: "gpt-4o-mini",
-//		Messages: []ChatMessage{
-//			{Role: "user", Content: "我刚才问了你什么问题？"},
-//		},
-//	}
-//	resp, err = client.CreateChatCompletion(context.Background(), secondSyncRequest)
-//	if err != nil {
-//		log.Fatalf("第二次同步调用失败: %v", err)
-//	}
-//	fmt.Printf("带有历史上下文的回复: %s\n\n", resp.Choices[0].Message.Content)
-//
-//	fmt.Println("当前历史记录：")
-//	for _, msg := range client.GetHistory() {
-//		fmt.Printf("  - %s: %s\n", msg.Role, msg.Content)
-//	}
-//	fmt.Println()
-//
-//	client.ClearHistory() // 清理历史，准备流式示例
+//	// config.BaseURL = "https://open.bigmodel.cn/api/paas/v4"
+//	client := aiutil.NewClient(config)
 //
-//	// --- 示例3：流式调用 ---
-//	fmt.Println("--- 3. SSE 流式调用 (Stream Call) ---")
-//	streamRequest := ChatRequest{
-//		Model: "gpt-4o-mini",
-//		Messages: []ChatMessage{
-//			{Role: "user", Content: "用Go语言写一个经典的Hello World程序，并用Markdown代码块包裹起来。"},
-//		},
-//	}
+//	resp, err := client.CreateChatCompletion(ctx, aiutil.ChatRequest{
+//		Model:    "gpt-4o-mini",
+//		Messages: []aiutil.ChatMessage{{Role: "user", Content: "你好，请介绍一下自己。"}},
+//	})
 //
-//	streamChan, err := client.CreateChatCompletionSSEStream(context.Background(), streamRequest)
-//	if err != nil {
-//		log.Fatalf("流式调用失败: %v", err)
-//	}
+// 同步、SSE 流式、WebSocket 流式的完整示例见 examples/chat 目录。
 //
-//	fmt.Print("流式回复: ")
-//	for event := range streamChan {
-//		if event.Error != nil {
-//			log.Printf("流式处理中发生错误: %v", event.Error)
-//			break
-//		}
-//		if len(event.Data.Choices) > 0 {
-//			content := event.Data.Choices[0].Delta.Content
-//			fmt.Print(content)
-//		}
-//	}
-//	fmt.Println("\n\n流式调用结束。")
-//
-//	fmt.Println("当前历史记录：")
-//	for _, msg := range client.GetHistory() {
-//		content := msg.Content
-//		if len(content) > 80 {
-//			content = content[:80] + "..."
-//		}
-//		fmt.Printf("  - %s: %s\n", msg.Role, strings.ReplaceAll(content, "\n", " "))
-//	}
-//
-//
-//	// --- 示例4：WebSocket 流式调用 ---
-//	// 注意：模型名称需要换成目标平台支持的，例如 "glm-4"
-//	fmt.Println("--- 4. WebSocket 流式调用 (WebSocket Stream Call) ---")
-//	wsRequest := ChatRequest{
-//		Model: "glm-4",
-//		Messages: []ChatMessage{
-//			{Role: "user", Content: "请用 Python 写一个简单的 web 服务器，并用 Markdown 代码块包裹。"},
-//		},
-//	}
-//
-//	// 调用新增的 WebSocket 方法
-//	wsStreamChan, err := client.CreateChatCompletionWebSocketStream(context.Background(), wsRequest)
-//	if err != nil {
-//		log.Fatalf("WebSocket 流式调用失败: %v", err)
-//	}
-//
-//	fmt.Print("WebSocket 流式回复: ")
-//	for event := range wsStreamChan {
-//		if event.Error != nil {
-//			log.Printf("\nWebSocket 流式处理中发生错误: %v", event.Error)
-//			break
-//		}
-//		if len(event.Data.Choices) > 0 {
-//			content := event.Data.Choices[0].Delta.Content
-//			fmt.Print(content)
-//		}
-//	}
-//	fmt.Println("\n\nWebSocket 流式调用结束。")
-//
-//	fmt.Println("当前历史记录：")
-//	for _, msg := range client.GetHistory() {
-//		content := msg.Content
-//		if len(content) > 80 {
-//			content = content[:80] + "..."
-//		}
-//		fmt.Printf("  - %s: %s\n", msg.Role, strings.ReplaceAll(content, "\n", " "))
-//	}
+// Client 是并发安全的，可以被多个 goroutine 共享同一个连接池。
+// 直接调用 Client 的对话方法时使用客户端的默认会话维护历史记录；
+// 服务多个用户时，请通过 Conversation 为每个用户创建独立的会话。
 type Client struct {
 	config     Config
 	httpClient *http.Client
-	history    []ChatMessage
+	tokenizer  Tokenizer
+	endpoints  *endpointPool // 未配置 Config.Endpoints 时为 nil
+
+	mu            sync.Mutex
+	conversations map[string]*list.Element // 值为 *Conversation
+	convLRU       *list.List               // 最近使用的会话在前
+	defaultConv   *Conversation
+	middlewares   []Middleware
+
+	usage usageCounter
 }
 
 // NewClient 使用给定配置创建一个新的客户端
@@ -275,94 +260,112 @@ func NewClient(config Config) *Client {
 	if config.Timeout > 0 {
 		httpClient.Timeout = config.Timeout
 	}
+	if config.HistoryStore == nil {
+		config.HistoryStore = NewMemoryHistoryStore()
+	}
+	if config.HistoryPruner == nil {
+		config.HistoryPruner = RecentPruner{}
+	}
+	if config.MaxConversations == 0 {
+		config.MaxConversations = DefaultMaxConversations
+	}
+	if config.Provider == nil {
+		config.Provider = OpenAIProvider{}
+	}
+
+	tokenizer := config.Tokenizer
+	if tokenizer == nil {
+		tokenizer = EstimateTokenizer{}
+	}
 
-	return &Client{
-		config:     config,
-		httpClient: httpClient,
-		history:    make([]ChatMessage, 0),
+	c := &Client{
+		config:        config,
+		httpClient:    httpClient,
+		tokenizer:     tokenizer,
+		endpoints:     newEndpointPool(config.Endpoints, config.CircuitBreaker),
+		conversations: make(map[string]*list.Element),
+		convLRU:       list.New(),
 	}
+	c.defaultConv = newConversation(c, DefaultConversationID)
+	return c
 }
 
 // =================================================================================
 // 4. 核心 API 方法 (同步与流式)
 // =================================================================================
 
-// CreateChatCompletion 发起一个同步的对话请求
+// CreateChatCompletion 发起一个同步的对话请求，历史记录由客户端的默认会话维护
 func (c *Client) CreateChatCompletion(ctx context.Context, request ChatRequest) (*ChatResponse, error) {
-	request.Stream = false // 确保不是流式请求
+	return c.defaultConv.CreateChatCompletion(ctx, request)
+}
 
-	// 1. 准备消息（合并历史记录）
-	finalMessages := c.pruneHistory(request.Messages)
-	request.Messages = finalMessages
+// CreateChatCompletionSSEStream 发起一个流式的对话请求，历史记录由客户端的默认会话维护
+// 返回一个只读的 channel，用于接收流式事件 (数据或错误)
+// 使用 SSE 协议，由 sseutil.Decoder 解析，支持多行 data、event 字段和注释行
+// 数据结束标记使用 "[DONE]"，服务端在流中途发送的 error 事件作为 *APIError 交给调用方
+// 调用方不再读取 channel 时应取消 ctx，后台 goroutine 会随之退出并关闭连接
+func (c *Client) CreateChatCompletionSSEStream(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+	return c.defaultConv.CreateChatCompletionSSEStream(ctx, request)
+}
 
-	// 2. 构建请求体和 HTTP 请求
-	httpReq, err := c.buildHTTPRequest(ctx, request)
-	if err != nil {
-		return nil, err
+// CreateChatCompletionWebSocketStream 通过 WebSocket 发起一个流式的对话请求，历史记录由客户端的默认会话维护
+// 与 SSE 流相同，取消 ctx 会关闭连接并结束 channel
+func (c *Client) CreateChatCompletionWebSocketStream(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+	return c.defaultConv.CreateChatCompletionWebSocketStream(ctx, request)
+}
+
+// GetHistory 返回默认会话的对话历史，读取失败时返回 nil
+func (c *Client) GetHistory() []ChatMessage {
+	return c.defaultConv.GetHistory()
+}
+
+// ClearHistory 清空默认会话的对话历史
+func (c *Client) ClearHistory() {
+	c.defaultConv.ClearHistory()
+}
+
+// doChatCompletion 发起一个同步的对话请求，request.Messages 即为完整的上下文，不涉及历史记录
+func (c *Client) doChatCompletion(ctx context.Context, request ChatRequest) (*ChatResponse, error) {
+	request.Stream = false // 确保不是流式请求
+	if cached, ok := c.loadCachedResponse(ctx, request); ok {
+		return cached, nil
 	}
 
-	// 3. 发送请求
-	resp, err := c.httpClient.Do(httpReq)
+	// 1. 构建并发送请求 (非 2xx 响应和可恢复的失败由 send 负责重试，端点故障时切换端点)
+	resp, err := c.sendChatRequest(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("http request failed: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// 4. 检查响应状态码
-	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api error: status=%s, body=%s", resp.Status, string(bodyBytes))
-	}
-
-	// 5. 解析响应
-	var result ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	// 2. 解析响应
+	result, err := c.config.Provider.DecodeResponse(resp.Body)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode response body: %w", err)
 	}
 
-	// 6. 成功后，更新历史记录
-	c.history = append(c.history, request.Messages[len(request.Messages)-1])
-	if len(result.Choices) > 0 {
-		c.history = append(c.history, result.Choices[0].Message)
-	}
-
-	return &result, nil
+	// 3. 记录用量并写入缓存
+	c.recordUsage(ctx, "chat", request.Model, result.Usage)
+	c.storeCachedResponse(ctx, request, result)
+	return result, nil
 }
 
-// CreateChatCompletionSSEStream 发起一个流式的对话请求
-// 返回一个只读的 channel，用于接收流式事件 (数据或错误)
-// 使用 SSE 协议
-// SSE协议规定服务器发送的数据以 "data: " 前缀开始
-// 数据结束标记使用 "[DONE]"
-func (c *Client) CreateChatCompletionSSEStream(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+// doSSEStream 通过 SSE 发起一个流式的对话请求，流结束后以完整的回复调用 onComplete
+func (c *Client) doSSEStream(ctx context.Context, request ChatRequest, onComplete func(reply ChatMessage) error) (<-chan StreamEvent, error) {
 	request.Stream = true // 确保是流式请求
-
-	// 1. 准备消息（合并历史记录）
-	finalMessages := c.pruneHistory(request.Messages)
-	request.Messages = finalMessages
-
-	// 2. 构建请求体和 HTTP 请求
-	httpReq, err := c.buildHTTPRequest(ctx, request)
-	if err != nil {
-		return nil, err
+	if cached, ok := c.loadCachedResponse(ctx, request); ok {
+		return c.replayStream(ctx, cached, onComplete), nil
 	}
 
-	// 3. 发送请求
-	resp, err := c.httpClient.Do(httpReq)
+	// 1. 构建并发送请求，只在收到响应头之前重试或切换端点
+	resp, err := c.sendChatRequest(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("http request failed: %w", err)
-	}
-
-	// 4. 检查初始响应状态码
-	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		_ = resp.Body.Close() // 别忘了关闭
-		return nil, fmt.Errorf("api error on stream init: status=%s, body=%s", resp.Status, string(bodyBytes))
+		return nil, err
 	}
 
-	// 5. 创建 channel 并启动 goroutine 处理流
+	// 2. 创建 channel 并启动 goroutine 处理流
 	streamChan := make(chan StreamEvent)
-	go c.processStream(resp, streamChan, request.Messages)
+	go c.processStream(ctx, resp, request, streamChan, onComplete)
 
 	return streamChan, nil
 }
@@ -371,14 +374,79 @@ func (c *Client) CreateChatCompletionSSEStream(ctx context.Context, request Chat
 // WebSocket 核心 API 方法
 // =================================================================================
 
-// CreateChatCompletionWebSocketStream 通过 WebSocket 发起一个流式的对话请求
-func (c *Client) CreateChatCompletionWebSocketStream(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+// doWebSocketStream 通过 WebSocket 发起一个流式的对话请求，流结束后以完整的回复调用 onComplete
+func (c *Client) doWebSocketStream(ctx context.Context, request ChatRequest, onComplete func(reply ChatMessage) error) (<-chan StreamEvent, error) {
 	request.Stream = true
-	finalMessages := c.pruneHistory(request.Messages)
-	request.Messages = finalMessages
+	if _, ok := c.config.Provider.(OpenAIProvider); !ok {
+		return nil, fmt.Errorf("websocket streaming is only supported by OpenAIProvider, got %T", c.config.Provider)
+	}
+	if cached, ok := c.loadCachedResponse(ctx, request); ok {
+		return c.replayStream(ctx, cached, onComplete), nil
+	}
+
+	endpoint := c.config.DefaultEndpoint
+	if request.RequestEndpoint != "" {
+		endpoint = request.RequestEndpoint
+	}
+
+	// 1. 建立 WebSocket 连接，配置了多个端点时在连接失败后切换到下一个端点
+	var conn WebSocketConn
+	model := request.Model
+	baseURL, headers := c.config.BaseURL, map[string]string(nil)
+	if c.endpoints == nil {
+		var err error
+		if conn, err = c.dialWebSocket(ctx, baseURL, headers, endpoint); err != nil {
+			return nil, err
+		}
+	} else {
+		var errs []error
+		for _, ep := range c.endpoints.order() {
+			var err error
+			if conn, err = c.dialWebSocket(ctx, ep.BaseURL, ep.Headers, endpoint); err == nil {
+				c.endpoints.success(ep)
+				model = ep.model(request.Model)
+				baseURL, headers = ep.BaseURL, ep.Headers
+				break
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			c.endpoints.failure(ep)
+			errs = append(errs, fmt.Errorf("endpoint %s: %w", ep.name(), err))
+		}
+		if conn == nil {
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	// 2. 构建请求体 (使用端点上的模型名)
+	wsRequest := request
+	wsRequest.Model = model
+	payload, err := c.buildPayload(wsRequest)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to build websocket payload: %w", err)
+	}
 
+	// 3. 创建 channel 并启动 goroutine 处理 WebSocket 通信，中断后重连同一个端点
+	stream := &webSocketStream{
+		conn:    conn,
+		request: wsRequest,
+		payload: payload,
+		redial: func() (WebSocketConn, error) {
+			return c.dialWebSocket(ctx, baseURL, headers, endpoint)
+		},
+	}
+	streamChan := make(chan StreamEvent)
+	go c.processWebSocketStream(ctx, stream, request, streamChan, onComplete)
+
+	return streamChan, nil
+}
+
+// dialWebSocket 将 baseURL+endpoint 转换为 WebSocket 地址，附带默认请求头和 headers 建立连接
+func (c *Client) dialWebSocket(ctx context.Context, baseURL string, headers map[string]string, endpoint string) (WebSocketConn, error) {
 	// 1. 构建 WebSocket URL
-	parsedURL, err := url.Parse(c.config.BaseURL)
+	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid BaseURL in config: %w", err)
 	}
@@ -394,35 +462,26 @@ func (c *Client) CreateChatCompletionWebSocketStream(ctx context.Context, reques
 		return nil, fmt.Errorf("unsupported URL scheme for websocket: %q", parsedURL.Scheme)
 	}
 
-	endpoint := c.config.DefaultEndpoint
-	if request.RequestEndpoint != "" {
-		endpoint = request.RequestEndpoint
-	}
-
 	parsedURL.Path = path.Join(parsedURL.Path, endpoint)
 	wsURL := parsedURL.String()
 
-	// 2. 创建 WebSocket 配置，并附带请求头
-	originURL := c.config.BaseURL
-	wsConfig, err := websocket.NewConfig(wsURL, originURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create websocket config: %w", err)
-	}
+	// 2. 附带默认请求头，建立 WebSocket 连接
+	header := make(http.Header)
 	for k, v := range c.config.DefaultHeaders {
-		wsConfig.Header.Set(k, v)
+		header.Set(k, v)
 	}
-
-	// 3. 建立 WebSocket 连接
-	conn, err := websocket.DialConfig(wsConfig)
+	for k, v := range headers {
+		header.Set(k, v)
+	}
+	dial := c.config.WebSocketDialer
+	if dial == nil {
+		dial = DefaultWebSocketDialer
+	}
+	conn, err := dial(ctx, wsURL, baseURL, header)
 	if err != nil {
 		return nil, fmt.Errorf("websocket dial failed to %s: %w", wsURL, err)
 	}
-
-	// 4. 创建 channel 并启动 goroutine 处理 WebSocket 通信
-	streamChan := make(chan StreamEvent)
-	go c.processWebSocketStream(ctx, conn, request, streamChan)
-
-	return streamChan, nil
+	return conn, nil
 }
 
 // =================================================================================
@@ -431,340 +490,326 @@ func (c *Client) CreateChatCompletionWebSocketStream(ctx context.Context, reques
 
 // buildPayload 将标准参数和自定义参数合并成最终的请求体
 func (c *Client) buildPayload(request ChatRequest) ([]byte, error) {
+	return marshalWithParams(request, request.CustomParams)
+}
+
+// marshalWithParams 将请求结构体序列化，并合并 CustomParams 中的自定义参数
+func marshalWithParams(request any, customParams map[string]any) ([]byte, error) {
 	// 1. 将标准结构体转为 map
 	var payload map[string]any
 	b, err := json.Marshal(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal base request: %w", err)
 	}
+	if len(customParams) == 0 {
+		return b, nil
+	}
 	if err := json.Unmarshal(b, &payload); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal base request to map: %w", err)
 	}
 
-	// 2. 如果存在自定义参数，则合并
-	if request.CustomParams != nil {
-		for k, v := range request.CustomParams {
-			payload[k] = v
-		}
+	// 2. 合并自定义参数
+	for k, v := range customParams {
+		payload[k] = v
 	}
 
 	// 3. 重新序列化为最终的 JSON
 	return json.Marshal(payload)
 }
 
-// buildHTTPRequest 构建一个标准的 http.Request
-func (c *Client) buildHTTPRequest(ctx context.Context, request ChatRequest) (*http.Request, error) {
-	// 1. 构建请求体
-	payloadBytes, err := c.buildPayload(request)
+// sendChatRequest 通过 Provider 将请求转换为服务商的格式并发送
+func (c *Client) sendChatRequest(ctx context.Context, request ChatRequest) (*http.Response, error) {
+	return c.sendRequest(ctx, request.Model, "", func(model string) (string, []byte, error) {
+		request.Model = model
+		return c.encodeChatRequest(request)
+	})
+}
+
+// encodeChatRequest 返回请求的 API 端点和最终的请求体
+func (c *Client) encodeChatRequest(request ChatRequest) (string, []byte, error) {
+	// 1. 转换为服务商的格式，并合并自定义参数
+	path, body, err := c.config.Provider.EncodeRequest(request)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	payloadBytes, err := marshalWithParams(body, request.CustomParams)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build payload: %w", err)
+		return "", nil, fmt.Errorf("failed to build payload: %w", err)
 	}
 
-	// 2. 确定 API 端点
-	endpoint := c.config.DefaultEndpoint
-	if request.RequestEndpoint != "" {
-		endpoint = request.RequestEndpoint
+	// 2. 确定 API 端点: 请求指定的端点 > Provider 的端点 > 默认端点
+	endpoint := request.RequestEndpoint
+	if endpoint == "" {
+		endpoint = path
 	}
-	_url := c.config.BaseURL + endpoint
+	if endpoint == "" {
+		endpoint = c.config.DefaultEndpoint
+	}
+	return endpoint, payloadBytes, nil
+}
 
-	// 3. 创建请求
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, _url, bytes.NewReader(payloadBytes))
+// newJSONRequest 创建一个发往 baseURL+endpoint 的 POST 请求，并设置默认请求头和 headers
+func (c *Client) newJSONRequest(ctx context.Context, baseURL string, headers map[string]string, endpoint string, payload []byte) (*http.Request, error) {
+	_url := baseURL + endpoint
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, _url, bytes.NewReader(payload))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create http request: %w", err)
 	}
-
-	// 4. 设置请求头
 	for k, v := range c.config.DefaultHeaders {
 		req.Header.Set(k, v)
 	}
-
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	return req, nil
 }
 
-// processStream 在一个单独的 goroutine 中处理流式响应
-func (c *Client) processStream(resp *http.Response, streamChan chan<- StreamEvent, userMessages []ChatMessage) {
+// sendEvent 将事件发送到 channel，在 ctx 取消时放弃发送并返回 false，
+// 避免调用方停止读取后 goroutine 永远阻塞在发送上
+func sendEvent(ctx context.Context, streamChan chan<- StreamEvent, event StreamEvent) bool {
+	select {
+	case streamChan <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendCanceled 在 ctx 取消后尝试把取消原因交给仍在读取的调用方，没有人读取时直接放弃
+func sendCanceled(ctx context.Context, streamChan chan<- StreamEvent) {
+	select {
+	case streamChan <- StreamEvent{Error: context.Cause(ctx)}:
+	default:
+	}
+}
+
+// processStream 在一个单独的 goroutine 中处理流式响应，数据块由 Provider 的 StreamDecoder 解码。
+// ctx 取消时立即关闭响应体使读取返回，此时不再调用 onComplete，不完整的回复不会写入历史记录。
+// 流中途出现错误 (例如 event: error 或无法解码的数据块) 时同样不调用 onComplete，也不写入缓存。
+func (c *Client) processStream(ctx context.Context, resp *http.Response, request ChatRequest, streamChan chan<- StreamEvent, onComplete func(reply ChatMessage) error) {
 	// 确保无论如何都能关闭资源和 channel
 	defer close(streamChan)
 	defer resp.Body.Close()
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+	defer stop()
 
-	var fullResponseContent strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
+	var acc StreamAccumulator
+	failed := false
+	decoder := c.config.Provider.NewStreamDecoder(resp.Body)
 
-	// SSE协议规定服务器发送的数据以 "data: " 前缀开始
-	// 数据结束标记使用 "[DONE]"，这也是SSE流结束的常见标识
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
+	for {
+		chunk, err := decoder.Next()
+		if ctx.Err() != nil {
+			sendCanceled(ctx, streamChan)
+			return
 		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
+		if err == io.EOF {
 			break
 		}
-
-		var chunk ChatStreamResponse
-		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			streamChan <- StreamEvent{Error: fmt.Errorf("error unmarshalling stream chunk: %w", err)}
-			continue // 继续尝试处理下一行
+		if err != nil {
+			failed = true
+			if !sendEvent(ctx, streamChan, StreamEvent{Error: err}) {
+				return
+			}
+			continue // 继续尝试处理下一个数据块
 		}
 
-		if len(chunk.Choices) > 0 {
-			fullResponseContent.WriteString(chunk.Choices[0].Delta.Content)
+		acc.Add(*chunk)
+		if !sendEvent(ctx, streamChan, StreamEvent{Data: *chunk}) {
+			return
 		}
-		streamChan <- StreamEvent{Data: chunk}
 	}
 
-	if err := scanner.Err(); err != nil {
-		streamChan <- StreamEvent{Error: fmt.Errorf("error reading stream: %w", err)}
+	// 流结束后记录用量 (需要服务端在流中返回 usage)，并将完整的AI回复交给调用方 (通常用于更新历史记录)
+	// 出现过错误的回复不完整，只记录用量
+	c.recordStreamUsage(ctx, request.Model, &acc)
+	if failed {
+		return
 	}
+	c.storeCachedResponse(ctx, request, acc.Response())
+	if err := onComplete(acc.Message()); err != nil {
+		sendEvent(ctx, streamChan, StreamEvent{Error: err})
+	}
+}
 
-	// 流结束后，将用户消息和完整的AI回复添加到历史记录
-	c.history = append(c.history, userMessages...)
-	c.history = append(c.history, ChatMessage{
-		Role:    "assistant",
-		Content: fullResponseContent.String(),
-	})
+// webSocketStream 是一次 WebSocket 流式请求的状态，重连后 conn 和 payload 会被替换
+type webSocketStream struct {
+	mu      sync.Mutex // 保护 conn，ctx 取消时会在其他 goroutine 中关闭连接
+	conn    WebSocketConn
+	request ChatRequest // 发送给端点的请求 (使用端点上的模型名)
+	payload []byte      // 连接后发送的第一条消息
+	redial  func() (WebSocketConn, error)
+}
+
+func (s *webSocketStream) current() WebSocketConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
 }
 
-// processWebSocketStream 在一个 goroutine 中处理 WebSocket 通信
-func (c *Client) processWebSocketStream(ctx context.Context, conn *websocket.Conn, request ChatRequest, streamChan chan<- StreamEvent) {
+func (s *webSocketStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Close()
+}
+
+// replace 关闭旧连接并换成新连接
+func (s *webSocketStream) replace(conn WebSocketConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Close()
+	s.conn = conn
+}
+
+// processWebSocketStream 在一个 goroutine 中处理 WebSocket 通信。
+// 连接中断且配置了 WebSocketOptions.Resume 时重新连接，从已收到的回复继续接收。
+func (c *Client) processWebSocketStream(ctx context.Context, stream *webSocketStream, request ChatRequest, streamChan chan<- StreamEvent, onComplete func(reply ChatMessage) error) {
 	// 1. 确保资源最终被清理
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("严重错误: processWebSocketStream 发生 panic: %v\n%s", r, debug.Stack())
-			streamChan <- StreamEvent{Error: fmt.Errorf("panic recovered in websocket processing: %v", r)}
+			sendEvent(ctx, streamChan, StreamEvent{Error: fmt.Errorf("panic recovered in websocket processing: %v", r)})
 		}
-		conn.Close()
+		stream.close()
 		close(streamChan)
 	}()
 
-	// 2. 启动一个 goroutine 来监听 context 的取消信号
-	// 当 context 被取消时，它会关闭连接，从而使下方的 Receive 调用立即返回错误
-	go func() {
-		<-ctx.Done()
-		conn.Close()
-	}()
-
-	// 3. 发送初始请求数据
-	payloadBytes, err := c.buildPayload(request)
-	if err != nil {
-		streamChan <- StreamEvent{Error: fmt.Errorf("failed to build websocket payload: %w", err)}
-		return
-	}
-	if err := websocket.Message.Send(conn, payloadBytes); err != nil {
-		streamChan <- StreamEvent{Error: fmt.Errorf("failed to send initial websocket message: %w", err)}
-		return
-	}
-
-	// 4. 循环接收服务器的响应
-	var fullResponseContent strings.Builder
-	for {
-		var chunk ChatStreamResponse
-		// Receive 会阻塞，直到收到消息、连接关闭或发生错误
-		if err := websocket.JSON.Receive(conn, &chunk); err != nil {
-			// 如果错误是 io.EOF 或者与连接关闭相关，说明流正常结束
-			if err == io.EOF || strings.Contains(err.Error(), "use of closed network connection") {
-				break // 正常退出循环
-			}
-			// 其他错误则报告给调用者
-			streamChan <- StreamEvent{Error: fmt.Errorf("error receiving websocket message: %w", err)}
+	// 2. 监听 context 的取消信号
+	// 当 context 被取消时关闭连接，从而使阻塞的 Receive 调用立即返回错误；处理结束后取消监听
+	stop := context.AfterFunc(ctx, stream.close)
+	defer stop()
+
+	// 3. 接收服务器的响应，连接中断时按配置重连
+	opts := c.config.WebSocket
+	maxResumes := max(opts.MaxResumes, 1)
+	var acc StreamAccumulator
+	for resumes := 0; ; resumes++ {
+		resumable, err := c.receiveWebSocket(ctx, stream.current(), stream.payload, &acc, streamChan)
+		// context 被取消导致连接关闭时，不再更新历史记录
+		if ctx.Err() != nil {
+			sendCanceled(ctx, streamChan)
 			return
 		}
-
-		if len(chunk.Choices) > 0 {
-			fullResponseContent.WriteString(chunk.Choices[0].Delta.Content)
+		if err == nil {
+			break
+		}
+		if !resumable || opts.Resume == nil || resumes >= maxResumes {
+			sendEvent(ctx, streamChan, StreamEvent{Error: err})
+			return
 		}
-		streamChan <- StreamEvent{Data: chunk}
-	}
-
-	// 5. 流结束后，更新历史记录
-	c.history = append(c.history, request.Messages...)
-	c.history = append(c.history, ChatMessage{
-		Role:    "assistant",
-		Content: fullResponseContent.String(),
-	})
-}
-
-// estimateTokens 是一个简单的 Token 估算函数。
-// 注意：这只是一个粗略的估算，对于精确控制，建议使用 tiktoken 等官方库。
-func estimateTokens(msg ChatMessage) int {
-	return len(msg.Content)
-}
-
-// pruneHistory 根据 MaxHistoryTokens 截断历史消息
-func (c *Client) pruneHistory(newMessages []ChatMessage) []ChatMessage {
-	newTokens := 0
-	for _, msg := range newMessages {
-		newTokens += estimateTokens(msg)
-	}
 
-	currentTokenCount := newTokens
-	startIndex := len(c.history)
-	for i := len(c.history) - 1; i >= 0; i-- {
-		msgTokens := estimateTokens(c.history[i])
-		if currentTokenCount+msgTokens > c.config.MaxHistoryTokens {
-			startIndex = i + 1
-			break
+		// 以已经收到的回复生成续传消息，重新连接同一个端点
+		payload, resumeErr := opts.Resume(stream.request, acc.Message())
+		if resumeErr != nil {
+			sendEvent(ctx, streamChan, StreamEvent{Error: fmt.Errorf("failed to build websocket resume message: %w", errors.Join(err, resumeErr))})
+			return
+		}
+		conn, dialErr := stream.redial()
+		if dialErr != nil {
+			sendEvent(ctx, streamChan, StreamEvent{Error: fmt.Errorf("failed to reconnect websocket: %w", errors.Join(err, dialErr))})
+			return
+		}
+		stream.replace(conn)
+		stream.payload = payload
+		// ctx 在替换连接之前被取消时，新连接不会被 AfterFunc 关闭
+		if ctx.Err() != nil {
+			sendCanceled(ctx, streamChan)
+			return
 		}
-		currentTokenCount += msgTokens
-		startIndex = i
 	}
 
-	finalMessages := make([]ChatMessage, 0)
-	if startIndex < len(c.history) {
-		finalMessages = append(finalMessages, c.history[startIndex:]...)
+	// 4. 流结束后记录用量、写入缓存，并将完整的AI回复交给调用方 (通常用于更新历史记录)
+	c.recordStreamUsage(ctx, request.Model, &acc)
+	c.storeCachedResponse(ctx, request, acc.Response())
+	if err := onComplete(acc.Message()); err != nil {
+		sendEvent(ctx, streamChan, StreamEvent{Error: err})
 	}
-	finalMessages = append(finalMessages, newMessages...)
-
-	return finalMessages
 }
 
-// GetHistory 返回当前对话历史
-func (c *Client) GetHistory() []ChatMessage {
-	return c.history
-}
-
-// ClearHistory 清空对话历史
-func (c *Client) ClearHistory() {
-	c.history = make([]ChatMessage, 0)
-}
-
-// =================================================================================
-// 6. Main 函数 - 使用示例
-// =================================================================================
-
-func main() {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("请设置环境变量 OPENAI_API_KEY")
-	}
-
-	// --- 客户端配置 ---
-	// 可以指向任何兼容 OpenAI API 的服务, 只需修改 BaseURL。
-	// 也可以通过修改 DefaultHeaders 支持不同认证方式。
-	config := DefaultConfig(apiKey)
-	// config.BaseURL = "https://api.groq.com/openai/v1" // 示例：切换到 Groq
-	// config.BaseURL = "https://open.bigmodel.cn/api/paas/v4" // 示例：切换到智谱AI
-	// config.DefaultHeaders["Authorization"] = "Bearer " + os.Getenv("ZHIPU_API_KEY")  // 自定义认证方式
-
-	config.MaxHistoryTokens = 2000 // 设置较小的历史记录，方便演示截断
-
-	client := NewClient(config)
-
-	// --- 示例1：同步调用，并使用自定义参数 ---
-	fmt.Println("--- 1. 同步调用 (Sync Call) ---")
-	syncRequest := ChatRequest{
-		Model: "gpt-4o-mini",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "你好，请介绍一下自己。"},
-		},
-		Temperature: 0.7,
-		// 示例：为智谱AI添加自定义参数
-		// CustomParams: map[string]any{
-		// 	"request_id": fmt.Sprintf("my-app-%d", time.Now().Unix()),
-		// },
-	}
-
-	resp, err := client.CreateChatCompletion(context.Background(), syncRequest)
-	if err != nil {
-		log.Fatalf("同步调用失败: %v", err)
-	}
-	fmt.Printf("同步回复: %s\n\n", resp.Choices[0].Message.Content)
-
-	// --- 示例2：第二次同步调用，测试历史上下文 ---
-	fmt.Println("--- 2. 第二次同步调用 (Testing History) ---")
-	secondSyncRequest := ChatRequest{
-		Model: "gpt-4o-mini",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "我刚才问了你什么问题？"},
-		},
-	}
-	resp, err = client.CreateChatCompletion(context.Background(), secondSyncRequest)
-	if err != nil {
-		log.Fatalf("第二次同步调用失败: %v", err)
-	}
-	fmt.Printf("带有历史上下文的回复: %s\n\n", resp.Choices[0].Message.Content)
-
-	fmt.Println("当前历史记录：")
-	for _, msg := range client.GetHistory() {
-		fmt.Printf("  - %s: %s\n", msg.Role, msg.Content)
-	}
-	fmt.Println()
-
-	client.ClearHistory() // 清理历史，准备流式示例
-
-	// --- 示例3：流式调用 ---
-	fmt.Println("--- 3. SSE 流式调用 (Stream Call) ---")
-	streamRequest := ChatRequest{
-		Model: "gpt-4o-mini",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "用Go语言写一个经典的Hello World程序，并用Markdown代码块包裹起来。"},
-		},
-	}
-
-	streamChan, err := client.CreateChatCompletionSSEStream(context.Background(), streamRequest)
-	if err != nil {
-		log.Fatalf("流式调用失败: %v", err)
-	}
-
-	fmt.Print("流式回复: ")
-	for event := range streamChan {
-		if event.Error != nil {
-			log.Printf("流式处理中发生错误: %v", event.Error)
-			break
-		}
-		if len(event.Data.Choices) > 0 {
-			content := event.Data.Choices[0].Delta.Content
-			fmt.Print(content)
-		}
+// receiveWebSocket 在一个连接上发送 payload，然后接收数据块直到流结束。
+// 流正常结束时返回 nil；resumable 表示错误是连接中断 (读取失败、超时、提前关闭)，可以重连继续。
+func (c *Client) receiveWebSocket(ctx context.Context, conn WebSocketConn, payload []byte, acc *StreamAccumulator, streamChan chan<- StreamEvent) (resumable bool, err error) {
+	opts := c.config.WebSocket
+	decode := opts.Decoder
+	if decode == nil {
+		decode = DefaultWebSocketFrameDecoder
 	}
-	fmt.Println("\n\n流式调用结束。")
 
-	fmt.Println("当前历史记录：")
-	for _, msg := range client.GetHistory() {
-		content := msg.Content
-		if len(content) > 80 {
-			content = content[:80] + "..."
+	// 1. 超时后关闭连接，使阻塞的 Receive 返回
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(time.Hour, func() {
+		timedOut.Store(true)
+		conn.Close()
+	})
+	timer.Stop()
+	defer timer.Stop()
+	arm := func(d time.Duration) {
+		if d > 0 {
+			timer.Reset(d)
 		}
-		fmt.Printf("  - %s: %s\n", msg.Role, strings.ReplaceAll(content, "\n", " "))
 	}
 
-	// --- 示例4：WebSocket 流式调用 ---
-	// 注意：模型名称需要换成目标平台支持的，例如 "glm-4"
-	fmt.Println("--- 4. WebSocket 流式调用 (WebSocket Stream Call) ---")
-	wsRequest := ChatRequest{
-		Model: "glm-4",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "请用 Python 写一个简单的 web 服务器，并用 Markdown 代码块包裹。"},
-		},
+	// 2. 发送请求数据
+	if err := conn.Send(payload); err != nil {
+		return true, fmt.Errorf("failed to send websocket message: %w", err)
+	}
+
+	// 3. 请求发送成功后定期发送 ping 保活，函数返回时停止；发送失败说明连接已断开，由 Receive 报告错误
+	if pinger, ok := conn.(WebSocketPinger); ok && opts.PingInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(opts.PingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if pinger.Ping() != nil {
+						return
+					}
+				}
+			}
+		}()
 	}
 
-	// 调用新增的 WebSocket 方法
-	wsStreamChan, err := client.CreateChatCompletionWebSocketStream(context.Background(), wsRequest)
-	if err != nil {
-		log.Fatalf("WebSocket 流式调用失败: %v", err)
-	}
+	// 4. 循环接收服务器的响应。超时只计算等待 Receive 的时间，调用方读取变慢不会导致超时
+	timeout := opts.ReadTimeout
+	for {
+		// Receive 会阻塞，直到收到消息、连接关闭或发生错误
+		arm(timeout)
+		message, err := conn.Receive()
+		timer.Stop()
+		timeout = opts.IdleTimeout
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				return false, ctx.Err()
+			case timedOut.Load():
+				return true, fmt.Errorf("%w: no websocket message received in time", ErrStreamTimeout)
+			case err == io.EOF && !opts.RequireDone:
+				return false, nil // 服务端关闭连接，流正常结束
+			case err == io.EOF:
+				return true, fmt.Errorf("websocket closed before done message: %w", io.ErrUnexpectedEOF)
+			default:
+				return true, fmt.Errorf("error receiving websocket message: %w", err)
+			}
+		}
 
-	fmt.Print("WebSocket 流式回复: ")
-	for event := range wsStreamChan {
-		if event.Error != nil {
-			log.Printf("\nWebSocket 流式处理中发生错误: %v", event.Error)
-			break
+		chunk, done, err := decode(message)
+		if err != nil {
+			return false, fmt.Errorf("error decoding websocket message: %w", err)
 		}
-		if len(event.Data.Choices) > 0 {
-			content := event.Data.Choices[0].Delta.Content
-			fmt.Print(content)
+		if chunk != nil {
+			acc.Add(*chunk)
+			if !sendEvent(ctx, streamChan, StreamEvent{Data: *chunk}) {
+				return false, ctx.Err()
+			}
 		}
-	}
-	fmt.Println("\n\nWebSocket 流式调用结束。")
-
-	fmt.Println("当前历史记录：")
-	for _, msg := range client.GetHistory() {
-		content := msg.Content
-		if len(content) > 80 {
-			content = content[:80] + "..."
+		if done {
+			return false, nil
 		}
-		fmt.Printf("  - %s: %s\n", msg.Role, strings.ReplaceAll(content, "\n", " "))
 	}
 }
@@ -1,147 +1,224 @@
-package main
+package aiutil
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"golang.org/x/net/websocket"
 )
 
-func TestClient(t *testing.T) {
+// --- 测试辅助函数 ---
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("请设置环境变量 OPENAI_API_KEY")
-	}
+// newTestClient 启动一个进程内的 httptest 服务器，并返回指向它的客户端
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
 
-	// --- 客户端配置 ---
-	// 可以指向任何兼容 OpenAI API 的服务, 只需修改 BaseURL。
-	// 也可以通过修改 DefaultHeaders 支持不同认证方式。
-	config := DefaultConfig(apiKey)
-	// config.BaseURL = "https://api.groq.com/openai/v1" // 示例：切换到 Groq
-	// config.BaseURL = "https://open.bigmodel.cn/api/paas/v4" // 示例：切换到智谱AI
-	config.BaseURL = "https://api.ppinfra.com/v3/openai"
-	//config.DefaultHeaders["Authorization"] = "Bearer " + os.Getenv("ZHIPU_API_KEY")
+	config := DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	return NewClient(config)
+}
 
-	config.MaxHistoryTokens = 2000 // 设置较小的历史记录，方便演示截断
+// decodeRequest 解析客户端发来的请求体
+func decodeRequest(t *testing.T, r *http.Request) map[string]any {
+	t.Helper()
+	var payload map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		t.Errorf("解析请求体失败: %v", err)
+	}
+	return payload
+}
 
-	client := NewClient(config)
+// writeChatResponse 写出一个只包含单条回复的同步响应
+func writeChatResponse(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"test-model",`+
+		`"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],`+
+		`"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`, content)
+}
 
-	// --- 示例1：同步调用，并使用自定义参数 ---
-	fmt.Println("--- 1. 同步调用 (Sync Call) ---")
-	syncRequest := ChatRequest{
-		Model: "deepseek/deepseek-v3.2-exp",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "你好，请介绍一下自己。"},
-		},
-		Temperature: 0.7,
-		// 示例：为智谱AI添加自定义参数
-		// CustomParams: map[string]any{
-		// 	"request_id": fmt.Sprintf("my-app-%d", time.Now().Unix()),
-		// },
+// writeSSEChunks 以 SSE 协议逐块写出回复内容
+func writeSSEChunks(w http.ResponseWriter, parts ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for _, part := range parts {
+		fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", part)
+		if flusher != nil {
+			flusher.Flush()
+		}
 	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
 
-	resp, err := client.CreateChatCompletion(context.Background(), syncRequest)
+// --- 单元测试 (Unit Tests) ---
+
+func TestCreateChatCompletion(t *testing.T) {
+	var gotAuth string
+	var gotPayload map[string]any
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("请求路径错误: %s", r.URL.Path)
+		}
+		gotAuth = r.Header.Get("Authorization")
+		gotPayload = decodeRequest(t, r)
+		writeChatResponse(w, "你好")
+	}))
+
+	resp, err := client.CreateChatCompletion(context.Background(), ChatRequest{
+		Model:        "test-model",
+		Messages:     []ChatMessage{{Role: "user", Content: "hi"}},
+		CustomParams: map[string]any{"request_id": "req-1"},
+	})
 	if err != nil {
-		log.Fatalf("同步调用失败: %v", err)
+		t.Fatalf("CreateChatCompletion 失败: %v", err)
 	}
-	fmt.Printf("同步回复: %s\n\n", resp.Choices[0].Message.Content)
-
-	// --- 示例2：第二次同步调用，测试历史上下文 ---
-	fmt.Println("--- 2. 第二次同步调用 (Testing History) ---")
-	secondSyncRequest := ChatRequest{
-		Model: "deepseek/deepseek-v3.2-exp",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "我刚才问了你什么问题？"},
-		},
+	if got := resp.Choices[0].Message.Content; got != "你好" {
+		t.Errorf("回复内容错误: %q", got)
 	}
-	resp, err = client.CreateChatCompletion(context.Background(), secondSyncRequest)
-	if err != nil {
-		log.Fatalf("第二次同步调用失败: %v", err)
+	if resp.Usage.TotalTokens != 5 {
+		t.Errorf("Usage 解析错误: %+v", resp.Usage)
+	}
+	if gotAuth != "Bearer test-key" {
+		t.Errorf("Authorization 头错误: %q", gotAuth)
+	}
+	if gotPayload["request_id"] != "req-1" {
+		t.Errorf("自定义参数未合并到请求体: %v", gotPayload)
+	}
+	if _, ok := gotPayload["stream"]; ok {
+		t.Errorf("同步请求不应携带 stream 字段: %v", gotPayload)
 	}
-	fmt.Printf("带有历史上下文的回复: %s\n\n", resp.Choices[0].Message.Content)
+}
 
-	fmt.Println("当前历史记录：")
-	for _, msg := range client.GetHistory() {
-		fmt.Printf("  - %s: %s\n", msg.Role, msg.Content)
+func TestCreateChatCompletion_History(t *testing.T) {
+	var lastMessages []any
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastMessages, _ = decodeRequest(t, r)["messages"].([]any)
+		writeChatResponse(w, "ok")
+	}))
+
+	ctx := context.Background()
+	for _, text := range []string{"第一问", "第二问"} {
+		_, err := client.CreateChatCompletion(ctx, ChatRequest{
+			Model:    "test-model",
+			Messages: []ChatMessage{{Role: "user", Content: text}},
+		})
+		if err != nil {
+			t.Fatalf("CreateChatCompletion 失败: %v", err)
+		}
 	}
-	fmt.Println()
 
-	client.ClearHistory() // 清理历史，准备流式示例
+	// 第二次请求应携带第一轮的问答
+	if len(lastMessages) != 3 {
+		t.Fatalf("期望第二次请求携带 3 条消息，实际 %d", len(lastMessages))
+	}
+	if got := len(client.GetHistory()); got != 4 {
+		t.Errorf("期望历史记录 4 条，实际 %d", got)
+	}
 
-	// --- 示例3：流式调用 ---
-	fmt.Println("--- 3. SSE 流式调用 (Stream Call) ---")
-	streamRequest := ChatRequest{
-		Model: "deepseek/deepseek-v3.2-exp",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "用Go语言写一个经典的Hello World程序，并用Markdown代码块包裹起来。"},
-		},
+	client.ClearHistory()
+	if got := len(client.GetHistory()); got != 0 {
+		t.Errorf("ClearHistory 后历史记录应为空，实际 %d", got)
 	}
+}
 
-	streamChan, err := client.CreateChatCompletionSSEStream(context.Background(), streamRequest)
+func TestCreateChatCompletion_APIError(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"bad key"}}`, http.StatusUnauthorized)
+	}))
+
+	_, err := client.CreateChatCompletion(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Fatalf("期望返回包含响应体的错误，实际: %v", err)
+	}
+	if got := len(client.GetHistory()); got != 0 {
+		t.Errorf("失败的请求不应写入历史记录，实际 %d 条", got)
+	}
+}
+
+func TestCreateChatCompletionSSEStream(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stream, _ := decodeRequest(t, r)["stream"].(bool); !stream {
+			t.Error("流式请求应携带 stream=true")
+		}
+		writeSSEChunks(w, "Hello", ", ", "World")
+	}))
+
+	streamChan, err := client.CreateChatCompletionSSEStream(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: "user", Content: "hi"}},
+	})
 	if err != nil {
-		log.Fatalf("流式调用失败: %v", err)
+		t.Fatalf("CreateChatCompletionSSEStream 失败: %v", err)
 	}
 
-	fmt.Print("流式回复: ")
+	var sb strings.Builder
 	for event := range streamChan {
 		if event.Error != nil {
-			log.Printf("流式处理中发生错误: %v", event.Error)
-			break
-		}
-		if len(event.Data.Choices) > 0 {
-			content := event.Data.Choices[0].Delta.Content
-			fmt.Print(content)
+			t.Fatalf("流式处理出错: %v", event.Error)
 		}
+		sb.WriteString(event.Data.Choices[0].Delta.Content)
 	}
-	fmt.Println("\n\n流式调用结束。")
-
-	fmt.Println("当前历史记录：")
-	for _, msg := range client.GetHistory() {
-		content := msg.Content
-		if len(content) > 80 {
-			content = content[:80] + "..."
-		}
-		fmt.Printf("  - %s: %s\n", msg.Role, strings.ReplaceAll(content, "\n", " "))
+	if sb.String() != "Hello, World" {
+		t.Errorf("流式内容错误: %q", sb.String())
 	}
 
-	// --- 示例4：WebSocket 流式调用 ---
-	// 注意：模型名称需要换成目标平台支持的，例如 "glm-4"
-	fmt.Println("--- 4. WebSocket 流式调用 (WebSocket Stream Call) ---")
-	wsRequest := ChatRequest{
-		Model: "deepseek/deepseek-v3.2-exp",
-		Messages: []ChatMessage{
-			{Role: "user", Content: "请用 Python 写一个简单的 web 服务器，并用 Markdown 代码块包裹。"},
-		},
+	history := client.GetHistory()
+	if len(history) == 0 || history[len(history)-1].Content != "Hello, World" {
+		t.Errorf("流结束后应将完整回复写入历史记录: %+v", history)
 	}
+}
 
-	// 调用新增的 WebSocket 方法
-	wsStreamChan, err := client.CreateChatCompletionWebSocketStream(context.Background(), wsRequest)
+func TestCreateChatCompletionWebSocketStream(t *testing.T) {
+	var gotAuth string
+	wsHandler := websocket.Handler(func(conn *websocket.Conn) {
+		gotAuth = conn.Request().Header.Get("Authorization")
+		var payload []byte
+		if err := websocket.Message.Receive(conn, &payload); err != nil {
+			t.Errorf("接收初始请求失败: %v", err)
+			return
+		}
+		var req ChatRequest
+		if err := json.Unmarshal(payload, &req); err != nil || req.Model != "test-model" {
+			t.Errorf("初始请求内容错误: %s", payload)
+		}
+		for _, part := range []string{"foo", "bar"} {
+			chunk := fmt.Sprintf(`{"choices":[{"index":0,"delta":{"content":%q}}]}`, part)
+			if err := websocket.Message.Send(conn, chunk); err != nil {
+				t.Errorf("发送数据失败: %v", err)
+				return
+			}
+		}
+	})
+	client := newTestClient(t, wsHandler)
+
+	streamChan, err := client.CreateChatCompletionWebSocketStream(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: "user", Content: "hi"}},
+	})
 	if err != nil {
-		log.Fatalf("WebSocket 流式调用失败: %v", err)
+		t.Fatalf("CreateChatCompletionWebSocketStream 失败: %v", err)
 	}
 
-	fmt.Print("WebSocket 流式回复: ")
-	for event := range wsStreamChan {
+	var sb strings.Builder
+	for event := range streamChan {
 		if event.Error != nil {
-			log.Printf("\nWebSocket 流式处理中发生错误: %v", event.Error)
-			break
-		}
-		if len(event.Data.Choices) > 0 {
-			content := event.Data.Choices[0].Delta.Content
-			fmt.Print(content)
+			t.Fatalf("WebSocket 流式处理出错: %v", event.Error)
 		}
+		sb.WriteString(event.Data.Choices[0].Delta.Content)
 	}
-	fmt.Println("\n\nWebSocket 流式调用结束。")
-
-	fmt.Println("当前历史记录：")
-	for _, msg := range client.GetHistory() {
-		content := msg.Content
-		if len(content) > 80 {
-			content = content[:80] + "..."
-		}
-		fmt.Printf("  - %s: %s\n", msg.Role, strings.ReplaceAll(content, "\n", " "))
+	if sb.String() != "foobar" {
+		t.Errorf("WebSocket 流式内容错误: %q", sb.String())
+	}
+	if gotAuth != "Bearer test-key" {
+		t.Errorf("WebSocket 握手应携带默认请求头，实际 %q", gotAuth)
 	}
 }
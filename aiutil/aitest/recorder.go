@@ -0,0 +1,443 @@
+package aitest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Bronya0/go-utils/aiutil"
+	"github.com/Bronya0/go-utils/fileutil"
+)
+
+// =================================================================================
+// 录制与回放 (Recorder)
+// =================================================================================
+
+// Mode 是 Recorder 的工作模式
+type Mode int
+
+const (
+	// ModeReplay 只从 fixture 文件回放，找不到匹配的记录时返回错误
+	ModeReplay Mode = iota
+	// ModeRecord 发送真实请求并录制，Save 时覆盖 fixture 文件
+	ModeRecord
+	// ModeAuto 在 fixture 文件存在时回放，否则录制
+	ModeAuto
+)
+
+// ErrNoFixture 表示回放时没有找到与请求匹配的记录
+var ErrNoFixture = errors.New("aitest: no recorded interaction matches the request")
+
+// redactedHeaders 是录制时不会写入 fixture 文件的请求头和响应头
+var redactedHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key", "Api-Key", "Cookie", "Set-Cookie"}
+
+// Recorder 录制 HTTP 请求与响应 (包括 SSE 流) 和 WebSocket 消息到 fixture 文件，并在之后离线回放，
+// 使测试不依赖真实的服务和 API Key。认证相关的请求头不会被录制。
+//
+// 回放时按请求方法、路径、查询参数和请求体匹配记录 (JSON 请求体会先规范化，multipart 请求体不参与匹配)，
+// 每条记录只使用一次，相同的请求按录制的顺序依次回放。
+//
+// 使用示例
+//
+//	rec, err := aitest.NewRecorder("testdata/chat.json", aitest.ModeAuto)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Save()
+//	config := aiutil.DefaultConfig(os.Getenv("OPENAI_API_KEY"))
+//	rec.Apply(&config)
+//	client := aiutil.NewClient(config)
+type Recorder struct {
+	// Transport 是录制时发送真实请求使用的 RoundTripper，为空时使用 http.DefaultTransport
+	Transport http.RoundTripper
+
+	path string
+	mode Mode
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// Interaction 是 fixture 文件中的一次交互
+type Interaction struct {
+	Request   RecordedRequest    `json:"request"`
+	Response  *RecordedResponse  `json:"response,omitempty"`
+	WebSocket *RecordedWebSocket `json:"websocket,omitempty"`
+}
+
+// RecordedRequest 是录制的请求
+type RecordedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"` // 为 "base64" 时 Body 是 base64 编码的二进制数据
+}
+
+// RecordedResponse 是录制的 HTTP 响应，SSE 流以完整的响应体保存
+type RecordedResponse struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// RecordedWebSocket 是录制的 WebSocket 消息
+type RecordedWebSocket struct {
+	Sent     []string `json:"sent,omitempty"`
+	Received []string `json:"received"`
+}
+
+type fixture struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// NewRecorder 创建一个 Recorder。回放模式下会立即读取 fixture 文件。
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode}
+	if mode == ModeAuto {
+		if _, err := os.Stat(path); err == nil {
+			r.mode = ModeReplay
+		} else {
+			r.mode = ModeRecord
+		}
+	}
+	if r.mode != ModeReplay {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	r.interactions = f.Interactions
+	r.used = make([]bool, len(f.Interactions))
+	return r, nil
+}
+
+// Recording 返回 Recorder 是否处于录制模式
+func (r *Recorder) Recording() bool {
+	return r.mode == ModeRecord
+}
+
+// HTTPClient 返回使用该 Recorder 作为 Transport 的 HTTP 客户端
+func (r *Recorder) HTTPClient() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Apply 设置配置的 HTTPClient 和 WebSocketDialer，使客户端的请求经过该 Recorder。
+// 配置中原有的 HTTPClient.Transport 和 WebSocketDialer 会在录制时用于发送真实请求。
+func (r *Recorder) Apply(config *aiutil.Config) {
+	if r.Transport == nil && config.HTTPClient != nil {
+		r.Transport = config.HTTPClient.Transport
+	}
+	config.HTTPClient = r.HTTPClient()
+	config.WebSocketDialer = r.WebSocketDialer(config.WebSocketDialer)
+}
+
+// Save 将录制的交互写入 fixture 文件，回放模式下不做任何事
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(fixture{Interactions: r.interactions}, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %w", err)
+	}
+	if err := fileutil.EnsureDir(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := newRecordedRequest(req.Method, req.URL.String(), req.Header, body)
+
+	if r.mode == ModeReplay {
+		interaction, err := r.match(recorded, func(i *Interaction) bool { return i.Response != nil })
+		if err != nil {
+			return nil, err
+		}
+		return interaction.Response.toHTTP(req)
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// 完整读取响应体 (SSE 流会一直读到服务端关闭连接) 后再交给调用方
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("aitest: failed to record response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	recordedResp := &RecordedResponse{Status: resp.StatusCode, Header: redact(resp.Header)}
+	recordedResp.Body, recordedResp.BodyEncoding = encodeBody(respBody)
+	r.add(&Interaction{Request: recorded, Response: recordedResp})
+	return resp, nil
+}
+
+// WebSocketDialer 返回录制或回放 WebSocket 消息的 aiutil.WebSocketDialer。
+// 录制时使用 next 建立真实连接，next 为空时使用 aiutil.DefaultWebSocketDialer。
+func (r *Recorder) WebSocketDialer(next aiutil.WebSocketDialer) aiutil.WebSocketDialer {
+	if next == nil {
+		next = aiutil.DefaultWebSocketDialer
+	}
+	return func(ctx context.Context, url, origin string, header http.Header) (aiutil.WebSocketConn, error) {
+		if r.mode == ModeReplay {
+			return &replayWebSocketConn{r: r, url: url, header: header}, nil
+		}
+		conn, err := next(ctx, url, origin, header)
+		if err != nil {
+			return nil, err
+		}
+		interaction := &Interaction{
+			Request:   newRecordedRequest(http.MethodGet, url, header, nil),
+			WebSocket: &RecordedWebSocket{Received: []string{}},
+		}
+		r.add(interaction)
+		return &recordingWebSocketConn{WebSocketConn: conn, r: r, interaction: interaction}, nil
+	}
+}
+
+func (r *Recorder) add(interaction *Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, interaction)
+	r.used = append(r.used, true)
+}
+
+// match 返回第一条未使用的、与请求匹配的记录，并将其标记为已使用
+func (r *Recorder) match(req RecordedRequest, accept func(*Interaction) bool) (*Interaction, error) {
+	key := req.matchKey()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		if r.used[i] || !accept(interaction) || interaction.Request.matchKey() != key {
+			continue
+		}
+		r.used[i] = true
+		return interaction, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoFixture, req.Method, req.URL)
+}
+
+// ---------------------------------------------------------------------------------
+// WebSocket 录制与回放
+// ---------------------------------------------------------------------------------
+
+// recordingWebSocketConn 录制收发的消息，第一条发送的消息同时作为请求体用于回放时匹配
+type recordingWebSocketConn struct {
+	aiutil.WebSocketConn
+	r           *Recorder
+	interaction *Interaction
+}
+
+func (c *recordingWebSocketConn) Send(message []byte) error {
+	c.r.mu.Lock()
+	ws := c.interaction.WebSocket
+	if len(ws.Sent) == 0 {
+		req := &c.interaction.Request
+		req.Body, req.BodyEncoding = encodeBody(message)
+	}
+	ws.Sent = append(ws.Sent, string(message))
+	c.r.mu.Unlock()
+	return c.WebSocketConn.Send(message)
+}
+
+func (c *recordingWebSocketConn) Receive() ([]byte, error) {
+	message, err := c.WebSocketConn.Receive()
+	if err == nil {
+		c.r.mu.Lock()
+		c.interaction.WebSocket.Received = append(c.interaction.WebSocket.Received, string(message))
+		c.r.mu.Unlock()
+	}
+	return message, err
+}
+
+// Ping 转发给真实连接，使录制时 WebSocketOptions.PingInterval 仍然生效
+func (c *recordingWebSocketConn) Ping() error {
+	if pinger, ok := c.WebSocketConn.(aiutil.WebSocketPinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// replayWebSocketConn 在收到第一条消息后按 URL 和该消息匹配记录，之后依次返回录制的消息，最后返回 io.EOF
+type replayWebSocketConn struct {
+	r      *Recorder
+	url    string
+	header http.Header
+
+	mu       sync.Mutex
+	received []string
+	matched  bool
+	err      error
+}
+
+func (c *replayWebSocketConn) Send(message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.matched {
+		return nil
+	}
+	req := newRecordedRequest(http.MethodGet, c.url, c.header, message)
+	interaction, err := c.r.match(req, func(i *Interaction) bool { return i.WebSocket != nil })
+	if err != nil {
+		c.err = err
+		return err
+	}
+	c.matched = true
+	c.received = append([]string(nil), interaction.WebSocket.Received...)
+	return nil
+}
+
+func (c *replayWebSocketConn) Receive() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !c.matched {
+		return nil, fmt.Errorf("aitest: websocket receive before send")
+	}
+	if len(c.received) == 0 {
+		return nil, io.EOF
+	}
+	message := c.received[0]
+	c.received = c.received[1:]
+	return []byte(message), nil
+}
+
+func (c *replayWebSocketConn) Close() error {
+	return nil
+}
+
+// ---------------------------------------------------------------------------------
+// 辅助函数
+// ---------------------------------------------------------------------------------
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("aitest: failed to read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func newRecordedRequest(method, rawURL string, header http.Header, body []byte) RecordedRequest {
+	req := RecordedRequest{Method: method, URL: rawURL, Header: redact(header)}
+	req.Body, req.BodyEncoding = encodeBody(body)
+	return req
+}
+
+// matchKey 返回回放时用于匹配的键: 方法、路径、查询参数和规范化后的请求体
+func (req RecordedRequest) matchKey() string {
+	target := req.URL
+	if u, err := url.Parse(req.URL); err == nil {
+		target = u.Path + "?" + u.Query().Encode()
+	}
+	key := req.Method + " " + target
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); strings.HasPrefix(mediaType, "multipart/") {
+		return key // multipart 的分隔符每次随机生成，请求体不参与匹配
+	}
+	body, err := decodeBody(req.Body, req.BodyEncoding)
+	if err != nil {
+		return key + "\n" + req.Body
+	}
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		body, _ = json.Marshal(v) // 规范化 JSON: 对象的键按字典序排列，去掉空白
+	}
+	return key + "\n" + string(body)
+}
+
+func (resp *RecordedResponse) toHTTP(req *http.Request) (*http.Response, error) {
+	body, err := decodeBody(resp.Body, resp.BodyEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("aitest: invalid recorded response body: %w", err)
+	}
+	header := resp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func redact(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	header = header.Clone()
+	for _, k := range redactedHeaders {
+		header.Del(k)
+	}
+	return header
+}
+
+// encodeBody 将合法 UTF-8 的内容原样保存，其余内容使用 base64 编码，返回编码后的内容和编码方式
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeBody(body, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(body), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(body)
+	default:
+		return nil, fmt.Errorf("unknown body encoding %q", encoding)
+	}
+}
@@ -0,0 +1,135 @@
+package aitest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Bronya0/go-utils/aiutil"
+)
+
+// newRecordedClient 创建一个经过 Recorder 指向 baseURL 的客户端
+func newRecordedClient(t *testing.T, path string, mode Mode, baseURL string) (*Recorder, *aiutil.Client) {
+	t.Helper()
+	rec, err := NewRecorder(path, mode)
+	if err != nil {
+		t.Fatalf("创建 Recorder 失败: %v", err)
+	}
+	config := aiutil.DefaultConfig("sk-secret")
+	config.BaseURL = baseURL
+	rec.Apply(&config)
+	return rec, aiutil.NewClient(config)
+}
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures", "chat.json")
+	server := NewServer()
+	server.Enqueue(Text("同步回复"), Text("流式回复"), Text("WebSocket 回复"))
+	ctx := context.Background()
+
+	// 1. 录制: 同步、SSE 流式和 WebSocket 各一次
+	rec, client := newRecordedClient(t, path, ModeAuto, server.URL)
+	if !rec.Recording() {
+		t.Fatal("fixture 文件不存在时应处于录制模式")
+	}
+	run := func(client *aiutil.Client) []string {
+		var replies []string
+		resp, err := client.CreateChatCompletion(ctx, userRequest("sync"))
+		if err != nil {
+			t.Fatalf("同步请求失败: %v", err)
+		}
+		replies = append(replies, resp.Choices[0].Message.Content)
+		for _, stream := range []func(context.Context, aiutil.ChatRequest) (<-chan aiutil.StreamEvent, error){
+			client.CreateChatCompletionSSEStream, client.CreateChatCompletionWebSocketStream,
+		} {
+			events, err := stream(ctx, userRequest("stream"))
+			if err != nil {
+				t.Fatalf("流式请求失败: %v", err)
+			}
+			resp, err := aiutil.CollectStream(events)
+			if err != nil {
+				t.Fatalf("流式请求失败: %v", err)
+			}
+			replies = append(replies, resp.Choices[0].Message.Content)
+		}
+		return replies
+	}
+	recorded := run(client)
+	if err := rec.Save(); err != nil {
+		t.Fatalf("保存 fixture 失败: %v", err)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 fixture 失败: %v", err)
+	}
+	if strings.Contains(string(data), "sk-secret") {
+		t.Error("fixture 中不应包含认证信息")
+	}
+
+	// 2. 回放: 服务器已关闭，仍能得到相同的回复，且历史记录相同
+	rec, client = newRecordedClient(t, path, ModeAuto, server.URL)
+	if rec.Recording() {
+		t.Fatal("fixture 文件存在时应处于回放模式")
+	}
+	replayed := run(client)
+	if strings.Join(replayed, "|") != strings.Join(recorded, "|") || recorded[2] != "WebSocket 回复" {
+		t.Errorf("回放结果与录制不一致: %q != %q", replayed, recorded)
+	}
+
+	// 3. 每条记录只使用一次，没有匹配的记录时返回 ErrNoFixture
+	if _, err := client.CreateChatCompletion(ctx, userRequest("sync")); !errors.Is(err, ErrNoFixture) {
+		t.Errorf("记录用完后应返回 ErrNoFixture，实际 %v", err)
+	}
+}
+
+func TestRecorder_BinaryBody(t *testing.T) {
+	binary := []byte{0xff, 0xfe, 0x00, 0x01}
+	path := filepath.Join(t.TempDir(), "audio.json")
+
+	rec, err := NewRecorder(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return (&RecordedResponse{Status: http.StatusOK, Body: string(binary)}).toHTTP(req)
+	})
+	resp, err := rec.HTTPClient().Post("http://example.com/audio/speech", "application/json", strings.NewReader(`{"input": "hi", "model": "tts"}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatalf("保存 fixture 失败: %v", err)
+	}
+
+	rec, err = NewRecorder(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := rec.interactions[0].Response.BodyEncoding; enc != "base64" {
+		t.Errorf("非 UTF-8 的响应体应使用 base64 编码，实际 %q", enc)
+	}
+	// JSON 请求体规范化后匹配，与键的顺序和空白无关
+	resp, err = rec.HTTPClient().Post("http://example.com/audio/speech", "application/json", strings.NewReader(`{"model":"tts","input":"hi"}`))
+	if err != nil {
+		t.Fatalf("回放失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, binary) {
+		t.Errorf("回放的二进制响应体错误: %v", body)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,441 @@
+// Package aitest 提供测试使用 aiutil 的代码所需的工具:
+//   - Server: 可编排回复的、兼容 OpenAI API 的假服务器，支持同步、SSE、WebSocket、向量嵌入和音频
+//   - Recorder: 录制真实请求与响应 (包括 SSE 和 WebSocket 消息) 到 fixture 文件，并在之后离线回放
+package aitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Bronya0/go-utils/aiutil"
+	"github.com/Bronya0/go-utils/sseutil"
+	"golang.org/x/net/websocket"
+)
+
+// =================================================================================
+// 假服务器 (Server)
+// =================================================================================
+
+// DefaultChunkSize 是流式回复中每个数据块默认包含的字符数
+const DefaultChunkSize = 4
+
+// Reply 是假服务器对一次对话请求的回复
+type Reply struct {
+	Content   string
+	ToolCalls []aiutil.ToolCall
+	// Reasoning 是思考过程，对应 ReasoningContent，流式回复时在 Content 之前发送
+	Reasoning string
+	// FinishReason 为空时，有工具调用则为 "tool_calls"，否则为 "stop"
+	FinishReason string
+	// Usage 为空时按字符数估算
+	Usage *aiutil.Usage
+	// ChunkSize 是流式回复中每个数据块包含的字符数，<=0 时使用 DefaultChunkSize
+	ChunkSize int
+	// Delay 是发送回复 (流式时为每个数据块) 之前的等待时间
+	Delay time.Duration
+	// Status 非 0 时返回该状态码和 OpenAI 格式的错误体，用于模拟限流、服务端错误等
+	Status       int
+	ErrorCode    string
+	ErrorMessage string
+	// Header 是额外的响应头，例如 Retry-After
+	Header http.Header
+}
+
+// Text 返回一个纯文本回复
+func Text(content string) Reply {
+	return Reply{Content: content}
+}
+
+// ToolCalls 返回一个发起工具调用的回复，未设置 ID 的调用会自动生成 "call_{序号}"
+func ToolCalls(calls ...aiutil.ToolCall) Reply {
+	for i := range calls {
+		if calls[i].ID == "" {
+			calls[i].ID = fmt.Sprintf("call_%d", i)
+		}
+		if calls[i].Type == "" {
+			calls[i].Type = "function"
+		}
+	}
+	return Reply{ToolCalls: calls}
+}
+
+// Call 构造一个工具调用，args 会被序列化为 JSON
+func Call(name string, args any) aiutil.ToolCall {
+	b, _ := json.Marshal(args)
+	return aiutil.ToolCall{Type: "function", Function: aiutil.FunctionCall{Name: name, Arguments: string(b)}}
+}
+
+// Error 返回一个错误回复，例如 Error(429, "rate_limit_exceeded", "slow down")
+func Error(status int, code, message string) Reply {
+	return Reply{Status: status, ErrorCode: code, ErrorMessage: message}
+}
+
+// Server 是一个进程内的、兼容 OpenAI API 的假服务器。
+// 对话请求 (/chat/completions，支持 SSE 和 WebSocket) 按顺序使用 Enqueue 编排的回复，
+// 队列为空时调用 HandleFunc 设置的函数，两者都没有时返回 500 错误。
+// 向量嵌入请求 (/embeddings) 返回由输入文本决定的固定向量。
+// 音频请求的结果同样是确定的: 语音转写 (/audio/transcriptions) 将上传的文件内容作为转写文本，
+// verbose_json 格式中每一行是一个时长 1 秒的分段；语音合成 (/audio/speech) 将输入文本原样作为音频数据返回。
+//
+// 使用示例
+//
+//	server := aitest.NewServer()
+//	defer server.Close()
+//	server.Enqueue(aitest.Text("你好"), aitest.Error(429, "rate_limit_exceeded", "slow down"))
+//	client := aiutil.NewClient(server.Config())
+type Server struct {
+	// URL 是服务器的地址，可直接作为 Config.BaseURL
+	URL string
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	queue    []Reply
+	handler  func(request aiutil.ChatRequest) Reply
+	requests []aiutil.ChatRequest
+}
+
+// NewServer 启动一个假服务器，使用完毕后需要调用 Close
+func NewServer() *Server {
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat/completions", s.serveChat)
+	mux.HandleFunc(aiutil.DefaultEmbeddingsEndpoint, s.serveEmbeddings)
+	mux.HandleFunc(aiutil.DefaultTranscriptionsEndpoint, s.serveTranscription)
+	mux.HandleFunc(aiutil.DefaultSpeechEndpoint, s.serveSpeech)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close 关闭服务器
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Config 返回指向该服务器的客户端配置
+func (s *Server) Config() aiutil.Config {
+	config := aiutil.DefaultConfig("aitest-key")
+	config.BaseURL = s.URL
+	return config
+}
+
+// Enqueue 按顺序追加回复，每个对话请求消耗一个
+func (s *Server) Enqueue(replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, replies...)
+}
+
+// HandleFunc 设置回复队列为空时根据请求生成回复的函数
+func (s *Server) HandleFunc(handler func(request aiutil.ChatRequest) Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// Requests 返回服务器收到的全部对话请求
+func (s *Server) Requests() []aiutil.ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]aiutil.ChatRequest(nil), s.requests...)
+}
+
+// LastRequest 返回最后一个对话请求
+func (s *Server) LastRequest() (aiutil.ChatRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return aiutil.ChatRequest{}, false
+	}
+	return s.requests[len(s.requests)-1], true
+}
+
+// next 记录请求并取出对应的回复
+func (s *Server) next(request aiutil.ChatRequest) Reply {
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	if len(s.queue) > 0 {
+		reply := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		return reply
+	}
+	handler := s.handler
+	s.mu.Unlock()
+
+	if handler != nil {
+		return handler(request)
+	}
+	return Error(http.StatusInternalServerError, "aitest_no_reply", "aitest: no reply scripted for this request")
+}
+
+func (s *Server) serveChat(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Handler(s.serveWebSocket).ServeHTTP(w, r)
+		return
+	}
+
+	var request aiutil.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request", err.Error()))
+		return
+	}
+	reply := s.next(request)
+	for k, values := range reply.Header {
+		w.Header()[k] = values
+	}
+	if reply.Status != 0 && (reply.Status < 200 || reply.Status > 299) {
+		writeError(w, reply)
+		return
+	}
+
+	if !request.Stream {
+		sleep(r, reply.Delay)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(reply.response(request))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	encoder := sseutil.NewEncoder(w)
+	includeUsage := request.StreamOptions != nil && request.StreamOptions.IncludeUsage
+	for _, chunk := range reply.chunks(request, includeUsage) {
+		if !sleep(r, reply.Delay) {
+			return
+		}
+		b, _ := json.Marshal(chunk)
+		if err := encoder.Encode(sseutil.Event{Data: string(b)}); err != nil {
+			return
+		}
+	}
+	_ = encoder.Encode(sseutil.Event{Data: "[DONE]"})
+}
+
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	var message []byte
+	if err := websocket.Message.Receive(ws, &message); err != nil {
+		return
+	}
+	var request aiutil.ChatRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return
+	}
+	reply := s.next(request)
+	if reply.Status != 0 && (reply.Status < 200 || reply.Status > 299) {
+		_ = websocket.JSON.Send(ws, errorBody(reply))
+		return
+	}
+	for _, chunk := range reply.chunks(request, true) {
+		if !sleep(ws.Request(), reply.Delay) {
+			return
+		}
+		if err := websocket.JSON.Send(ws, chunk); err != nil {
+			return
+		}
+	}
+	_ = websocket.Message.Send(ws, "[DONE]")
+}
+
+func (s *Server) serveEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var request aiutil.EmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request", err.Error()))
+		return
+	}
+	dimensions := request.Dimensions
+	if dimensions <= 0 {
+		dimensions = 8
+	}
+	resp := aiutil.EmbeddingResponse{Object: "list", Model: request.Model}
+	for i, input := range request.Input {
+		resp.Data = append(resp.Data, aiutil.Embedding{Object: "embedding", Index: i, Embedding: fakeVector(input, dimensions)})
+		resp.Usage.PromptTokens += utf8.RuneCountInString(input)
+	}
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) serveTranscription(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request", err.Error()))
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request", err.Error()))
+		return
+	}
+	text := string(data)
+
+	switch format := r.FormValue("response_format"); format {
+	case "", aiutil.TranscriptionFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(aiutil.TranscriptionResponse{Text: text})
+	case aiutil.TranscriptionFormatVerboseJSON:
+		resp := aiutil.TranscriptionResponse{Text: text, Language: r.FormValue("language")}
+		for i, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			resp.Segments = append(resp.Segments, aiutil.TranscriptionSegment{ID: i, Start: float64(i), End: float64(i + 1), Text: line})
+		}
+		resp.Duration = float64(len(resp.Segments))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, text)
+	}
+}
+
+func (s *Server) serveSpeech(w http.ResponseWriter, r *http.Request) {
+	var request aiutil.SpeechRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request", err.Error()))
+		return
+	}
+	format := request.ResponseFormat
+	if format == "" {
+		format = "mp3"
+	}
+	w.Header().Set("Content-Type", "audio/"+format)
+	_, _ = io.WriteString(w, request.Input)
+}
+
+// fakeVector 根据文本生成一个固定的单位向量，相同的文本总是得到相同的向量
+func fakeVector(text string, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	var norm float64
+	for i := range vector {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%d:%s", i, text)
+		v := float64(h.Sum32())/math.MaxUint32*2 - 1
+		vector[i] = float32(v)
+		norm += v * v
+	}
+	for i := range vector {
+		vector[i] /= float32(math.Sqrt(norm))
+	}
+	return vector
+}
+
+// response 返回同步响应
+func (r Reply) response(request aiutil.ChatRequest) aiutil.ChatResponse {
+	msg := aiutil.ChatMessage{Role: aiutil.RoleAssistant, Content: r.Content, ToolCalls: r.ToolCalls, ReasoningContent: r.Reasoning}
+	return aiutil.ChatResponse{
+		ID:      "chatcmpl-aitest",
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   request.Model,
+		Choices: []aiutil.ChatChoice{{Message: msg, FinishReason: r.finishReason()}},
+		Usage:   r.usage(request),
+	}
+}
+
+// chunks 将回复拆分为流式数据块
+func (r Reply) chunks(request aiutil.ChatRequest, includeUsage bool) []aiutil.ChatStreamResponse {
+	chunk := func(delta aiutil.ChatDelta, finishReason string) aiutil.ChatStreamResponse {
+		return aiutil.ChatStreamResponse{
+			ID:      "chatcmpl-aitest",
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   request.Model,
+			Choices: []aiutil.ChatStreamChoice{{Delta: delta, FinishReason: finishReason}},
+		}
+	}
+
+	size := r.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	chunks := []aiutil.ChatStreamResponse{chunk(aiutil.ChatDelta{Role: aiutil.RoleAssistant}, "")}
+	reasoning := []rune(r.Reasoning)
+	for start := 0; start < len(reasoning); start += size {
+		end := min(start+size, len(reasoning))
+		chunks = append(chunks, chunk(aiutil.ChatDelta{ReasoningContent: string(reasoning[start:end])}, ""))
+	}
+	content := []rune(r.Content)
+	for start := 0; start < len(content); start += size {
+		end := min(start+size, len(content))
+		chunks = append(chunks, chunk(aiutil.ChatDelta{Content: string(content[start:end])}, ""))
+	}
+	for i, call := range r.ToolCalls {
+		index := i
+		call.Index = &index
+		chunks = append(chunks, chunk(aiutil.ChatDelta{ToolCalls: []aiutil.ToolCall{call}}, ""))
+	}
+	chunks = append(chunks, chunk(aiutil.ChatDelta{}, r.finishReason()))
+
+	if includeUsage {
+		usage := r.usage(request)
+		last := chunk(aiutil.ChatDelta{}, "")
+		last.Choices = []aiutil.ChatStreamChoice{}
+		last.Usage = &usage
+		chunks = append(chunks, last)
+	}
+	return chunks
+}
+
+func (r Reply) finishReason() string {
+	switch {
+	case r.FinishReason != "":
+		return r.FinishReason
+	case len(r.ToolCalls) > 0:
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+func (r Reply) usage(request aiutil.ChatRequest) aiutil.Usage {
+	if r.Usage != nil {
+		return *r.Usage
+	}
+	var prompt int
+	for _, msg := range request.Messages {
+		prompt += utf8.RuneCountInString(msg.Text())
+	}
+	completion := utf8.RuneCountInString(r.Content) + utf8.RuneCountInString(r.Reasoning)
+	for _, call := range r.ToolCalls {
+		completion += utf8.RuneCountInString(call.Function.Arguments)
+	}
+	return aiutil.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+func errorBody(reply Reply) map[string]any {
+	return map[string]any{"error": map[string]any{
+		"message": reply.ErrorMessage,
+		"type":    "aitest_error",
+		"code":    reply.ErrorCode,
+	}}
+}
+
+func writeError(w http.ResponseWriter, reply Reply) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.Status)
+	_ = json.NewEncoder(w).Encode(errorBody(reply))
+}
+
+// sleep 等待 d，请求被取消时返回 false
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
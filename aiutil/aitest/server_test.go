@@ -0,0 +1,164 @@
+package aitest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Bronya0/go-utils/aiutil"
+)
+
+func userRequest(content string) aiutil.ChatRequest {
+	return aiutil.ChatRequest{Model: "gpt", Messages: []aiutil.ChatMessage{{Role: aiutil.RoleUser, Content: content}}}
+}
+
+func TestServer_Chat(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Enqueue(Text("你好，世界"), Error(http.StatusBadRequest, "context_length_exceeded", "too long"))
+	client := aiutil.NewClient(server.Config())
+	ctx := context.Background()
+
+	resp, err := client.CreateChatCompletion(ctx, userRequest("hi"))
+	if err != nil || resp.Choices[0].Message.Content != "你好，世界" || resp.Choices[0].FinishReason != "stop" {
+		t.Fatalf("同步回复错误: %+v, %v", resp, err)
+	}
+	if resp.Usage.CompletionTokens != 5 {
+		t.Errorf("未设置 Usage 时应按字符数估算: %+v", resp.Usage)
+	}
+
+	_, err = client.CreateChatCompletion(ctx, userRequest("again"))
+	if !errors.Is(err, aiutil.ErrContextLengthExceeded) {
+		t.Errorf("期望编排的错误，实际 %v", err)
+	}
+
+	// 队列为空且没有 HandleFunc 时返回错误
+	if _, err := client.CreateChatCompletion(ctx, userRequest("more")); err == nil {
+		t.Error("没有编排回复时应返回错误")
+	}
+	if len(server.Requests()) != 3 {
+		t.Errorf("应记录全部请求，实际 %d 个", len(server.Requests()))
+	}
+	if last, ok := server.LastRequest(); !ok || last.Messages[len(last.Messages)-1].Content != "more" {
+		t.Errorf("最后一个请求错误: %+v", last)
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.HandleFunc(func(req aiutil.ChatRequest) Reply {
+		return Reply{Content: "echo: " + req.Messages[len(req.Messages)-1].Content, Reasoning: "想", ChunkSize: 2}
+	})
+	client := aiutil.NewClient(server.Config())
+	ctx := context.Background()
+
+	request := userRequest("abc")
+	request.StreamOptions = &aiutil.StreamOptions{IncludeUsage: true}
+	stream, err := client.CreateChatCompletionSSEStream(ctx, request)
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	var chunks int
+	var acc aiutil.StreamAccumulator
+	for event := range stream {
+		if event.Error != nil {
+			t.Fatalf("流式事件错误: %v", event.Error)
+		}
+		chunks++
+		acc.Add(event.Data)
+	}
+	resp := acc.Response()
+	if resp.Choices[0].Message.Content != "echo: abc" || resp.Choices[0].Message.ReasoningContent != "想" || resp.Usage.CompletionTokens != 10 {
+		t.Errorf("流式回复错误: %+v", resp)
+	}
+	if chunks < 5 {
+		t.Errorf("回复应按 ChunkSize 拆分，实际 %d 个数据块", chunks)
+	}
+
+	stream, err = client.CreateChatCompletionWebSocketStream(ctx, userRequest("ws"))
+	if err != nil {
+		t.Fatalf("WebSocket 请求失败: %v", err)
+	}
+	if resp, err := aiutil.CollectStream(stream); err != nil || resp.Choices[0].Message.Content != "echo: ws" {
+		t.Errorf("WebSocket 回复错误: %+v, %v", resp, err)
+	}
+
+	server.Enqueue(Error(http.StatusServiceUnavailable, "overloaded", "busy"))
+	stream, err = client.CreateChatCompletionWebSocketStream(ctx, userRequest("ws"))
+	if err != nil {
+		t.Fatalf("WebSocket 请求失败: %v", err)
+	}
+	if _, err := aiutil.CollectStream(stream); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("WebSocket 中的错误消息应返回给调用方，实际 %v", err)
+	}
+}
+
+func TestServer_ToolCalls(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Enqueue(ToolCalls(Call("get_weather", map[string]string{"city": "北京"})))
+	client := aiutil.NewClient(server.Config())
+
+	stream, err := client.CreateChatCompletionSSEStream(context.Background(), userRequest("天气"))
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err := aiutil.CollectStream(stream)
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	calls := resp.Choices[0].Message.ToolCalls
+	if len(calls) != 1 || calls[0].ID != "call_0" || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"北京"}` {
+		t.Errorf("工具调用错误: %+v", calls)
+	}
+	if resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("结束原因应为 tool_calls，实际 %q", resp.Choices[0].FinishReason)
+	}
+}
+
+func TestServer_Embeddings(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := aiutil.NewClient(server.Config())
+
+	resp, err := client.CreateEmbeddings(context.Background(), aiutil.EmbeddingRequest{Model: "emb", Input: []string{"a", "b", "a"}})
+	if err != nil || len(resp.Data) != 3 {
+		t.Fatalf("向量嵌入请求失败: %+v, %v", resp, err)
+	}
+	if !slices.Equal(resp.Data[0].Embedding, resp.Data[2].Embedding) {
+		t.Error("相同的文本应得到相同的向量")
+	}
+	if slices.Equal(resp.Data[0].Embedding, resp.Data[1].Embedding) || len(resp.Data[0].Embedding) != 8 {
+		t.Errorf("不同的文本应得到不同的向量: %v", resp.Data[:2])
+	}
+}
+
+func TestServer_Audio(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := aiutil.NewClient(server.Config())
+	ctx := context.Background()
+
+	var audio bytes.Buffer
+	if _, err := client.CreateSpeech(ctx, aiutil.SpeechRequest{Model: "tts-1", Voice: "alloy", Input: "第一句\n第二句"}, &audio); err != nil {
+		t.Fatalf("语音合成失败: %v", err)
+	}
+
+	resp, err := client.CreateTranscription(ctx, aiutil.TranscriptionRequest{
+		Model:          "whisper-1",
+		File:           &audio,
+		FileName:       "speech.mp3",
+		ResponseFormat: aiutil.TranscriptionFormatVerboseJSON,
+	})
+	if err != nil {
+		t.Fatalf("语音转写失败: %v", err)
+	}
+	if resp.Text != "第一句\n第二句" || len(resp.Segments) != 2 || resp.Segments[1].Text != "第二句" || resp.Segments[1].Start != 1 || resp.Duration != 2 {
+		t.Errorf("合成的音频转写后应得到原文: %+v", resp)
+	}
+}
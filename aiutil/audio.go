@@ -0,0 +1,310 @@
+package aiutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// =================================================================================
+// 语音转写与语音合成 (Audio)
+// =================================================================================
+
+// 音频 API 的默认端点
+const (
+	DefaultTranscriptionsEndpoint = "/audio/transcriptions"
+	DefaultSpeechEndpoint         = "/audio/speech"
+)
+
+// 转写结果的格式，用于 TranscriptionRequest.ResponseFormat
+const (
+	TranscriptionFormatJSON        = "json"
+	TranscriptionFormatText        = "text"
+	TranscriptionFormatSRT         = "srt"
+	TranscriptionFormatVTT         = "vtt"
+	TranscriptionFormatVerboseJSON = "verbose_json" // 包含语言、时长以及分段和单词的时间戳
+)
+
+// TranscriptionRequest 是语音转写请求，以 multipart 表单上传音频
+type TranscriptionRequest struct {
+	Model string
+	// FilePath 是音频文件的路径，与 File 二选一
+	FilePath string
+	// File 是音频内容，FileName 是其文件名 (服务端根据扩展名判断音频格式)
+	File     io.Reader
+	FileName string
+	// Language 是音频的语言 (ISO-639-1，例如 "zh")，可以提高准确率和速度
+	Language string
+	// Prompt 是引导转写风格或提供专有名词的文本
+	Prompt string
+	// ResponseFormat 是结果的格式，为空时使用服务端默认的 json
+	ResponseFormat string
+	Temperature    float32
+	// TimestampGranularities 是时间戳的粒度，可选 "segment"、"word"，需要 ResponseFormat 为 verbose_json
+	TimestampGranularities []string
+
+	// CustomParams 用于存放任何非官方、模型特定的表单字段，非字符串的值序列化为 JSON
+	CustomParams map[string]any
+	// RequestEndpoint 允许覆盖默认的 DefaultTranscriptionsEndpoint
+	RequestEndpoint string
+}
+
+// TranscriptionResponse 是语音转写的结果。text、srt、vtt 格式的结果保存在 Text 中。
+type TranscriptionResponse struct {
+	Text     string                 `json:"text"`
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration,omitempty"` // 秒
+	Segments []TranscriptionSegment `json:"segments,omitempty"`
+	Words    []TranscriptionWord    `json:"words,omitempty"`
+	// Usage 是按 Token 计费的模型 (例如 gpt-4o-transcribe) 返回的用量
+	Usage *TranscriptionUsage `json:"usage,omitempty"`
+}
+
+// TranscriptionSegment 是 verbose_json 结果中的一个分段
+type TranscriptionSegment struct {
+	ID               int     `json:"id"`
+	Start            float64 `json:"start"` // 秒
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens,omitempty"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+// TranscriptionWord 是 verbose_json 结果中的一个单词
+type TranscriptionWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// TranscriptionUsage 是语音转写的 Token 用量
+type TranscriptionUsage struct {
+	Type         string  `json:"type"` // "tokens" 或 "duration"
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+	Seconds      float64 `json:"seconds,omitempty"`
+}
+
+// CreateTranscription 将音频转写为文本。音频会被完整读入内存，以便失败重试和切换端点时重新发送。
+//
+// 使用示例
+//
+//	resp, err := client.CreateTranscription(ctx, aiutil.TranscriptionRequest{
+//		Model:                  "whisper-1",
+//		FilePath:               "meeting.mp3",
+//		ResponseFormat:         aiutil.TranscriptionFormatVerboseJSON,
+//		TimestampGranularities: []string{"segment"},
+//	})
+func (c *Client) CreateTranscription(ctx context.Context, request TranscriptionRequest) (*TranscriptionResponse, error) {
+	if _, ok := c.config.Provider.(OpenAIProvider); !ok {
+		return nil, fmt.Errorf("audio transcription is only supported by OpenAIProvider, got %T", c.config.Provider)
+	}
+
+	// 1. 读取音频内容
+	audio, fileName, err := request.readAudio()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := DefaultTranscriptionsEndpoint
+	if request.RequestEndpoint != "" {
+		endpoint = request.RequestEndpoint
+	}
+
+	// 2. 构建 multipart 表单并发送请求，每次重新编码时使用同一个 boundary
+	form := multipart.NewWriter(nil)
+	resp, err := c.sendRequest(ctx, request.Model, form.FormDataContentType(), func(model string) (string, []byte, error) {
+		body, err := request.encodeForm(model, fileName, audio, form.Boundary())
+		return endpoint, body, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 3. 解析响应: json 和 verbose_json 为 JSON，其余格式为纯文本
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	var result TranscriptionResponse
+	switch request.ResponseFormat {
+	case "", TranscriptionFormatJSON, TranscriptionFormatVerboseJSON:
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode response body: %w", err)
+		}
+	default:
+		result.Text = string(body)
+	}
+
+	// 4. 记录用量: 按时长计费的模型和 text 等格式没有 Token 用量，只计入请求次数
+	var usage Usage
+	if u := result.Usage; u != nil {
+		usage = Usage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+	}
+	c.recordUsage(ctx, "transcription", request.Model, usage)
+	return &result, nil
+}
+
+// readAudio 返回音频内容和文件名
+func (r TranscriptionRequest) readAudio() ([]byte, string, error) {
+	if r.FilePath != "" {
+		data, err := os.ReadFile(r.FilePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read audio file: %w", err)
+		}
+		return data, filepath.Base(r.FilePath), nil
+	}
+	if r.File == nil {
+		return nil, "", fmt.Errorf("transcription request requires FilePath or File")
+	}
+	data, err := io.ReadAll(r.File)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read audio: %w", err)
+	}
+	name := r.FileName
+	if name == "" {
+		name = "audio"
+	}
+	return data, name, nil
+}
+
+// encodeForm 将请求编码为 multipart 表单
+func (r TranscriptionRequest) encodeForm(model, fileName string, audio []byte, boundary string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+
+	fields := [][2]string{{"model", model}}
+	for _, field := range [][2]string{
+		{"language", r.Language},
+		{"prompt", r.Prompt},
+		{"response_format", r.ResponseFormat},
+	} {
+		if field[1] != "" {
+			fields = append(fields, field)
+		}
+	}
+	if r.Temperature != 0 {
+		fields = append(fields, [2]string{"temperature", strconv.FormatFloat(float64(r.Temperature), 'f', -1, 32)})
+	}
+	for _, granularity := range r.TimestampGranularities {
+		fields = append(fields, [2]string{"timestamp_granularities[]", granularity})
+	}
+	// 自定义参数按键排序，使相同的请求得到相同的请求体
+	keys := make([]string, 0, len(r.CustomParams))
+	for k := range r.CustomParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, ok := r.CustomParams[k].(string)
+		if !ok {
+			b, err := json.Marshal(r.CustomParams[k])
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode form field %s: %w", k, err)
+			}
+			value = string(b)
+		}
+		fields = append(fields, [2]string{k, value})
+	}
+	for _, field := range fields {
+		if err := w.WriteField(field[0], field[1]); err != nil {
+			return nil, fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, fileName))
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file part: %w", err)
+	}
+	if _, err := part.Write(audio); err != nil {
+		return nil, fmt.Errorf("failed to write file part: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ---------------------------------------------------------------------------------
+// 语音合成
+// ---------------------------------------------------------------------------------
+
+// SpeechRequest 是语音合成请求
+type SpeechRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+	Voice string `json:"voice"`
+	// Instructions 控制语气、语速等风格，只有部分模型支持 (例如 gpt-4o-mini-tts)
+	Instructions string `json:"instructions,omitempty"`
+	// ResponseFormat 是音频格式: mp3 (默认)、opus、aac、flac、wav、pcm
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float32 `json:"speed,omitempty"`
+
+	// CustomParams 用于存放任何非官方、模型特定的参数
+	CustomParams map[string]any `json:"-"`
+	// RequestEndpoint 允许覆盖默认的 DefaultSpeechEndpoint
+	RequestEndpoint string `json:"-"`
+}
+
+// CreateSpeech 将文本合成为语音，并在收到音频数据的同时写入 w，返回写入的字节数。
+// 失败重试和切换端点只发生在收到响应头之前，开始写入 w 之后的错误直接返回。
+//
+// 使用示例
+//
+//	f, _ := os.Create("hello.mp3")
+//	defer f.Close()
+//	_, err := client.CreateSpeech(ctx, aiutil.SpeechRequest{Model: "tts-1", Voice: "alloy", Input: "你好"}, f)
+func (c *Client) CreateSpeech(ctx context.Context, request SpeechRequest, w io.Writer) (int64, error) {
+	if _, ok := c.config.Provider.(OpenAIProvider); !ok {
+		return 0, fmt.Errorf("speech synthesis is only supported by OpenAIProvider, got %T", c.config.Provider)
+	}
+
+	// 1. 构建并发送请求
+	endpoint := DefaultSpeechEndpoint
+	if request.RequestEndpoint != "" {
+		endpoint = request.RequestEndpoint
+	}
+	resp, err := c.sendRequest(ctx, request.Model, "", func(model string) (string, []byte, error) {
+		encoded := request
+		encoded.Model = model
+		payload, err := marshalWithParams(encoded, request.CustomParams)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to build payload: %w", err)
+		}
+		return endpoint, payload, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// 2. 将音频数据写入 w
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("failed to read audio stream: %w", err)
+	}
+	return n, nil
+}
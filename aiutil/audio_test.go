@@ -0,0 +1,154 @@
+package aiutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCreateTranscription_VerboseJSON(t *testing.T) {
+	var gotForm map[string][]string
+	var gotFile, gotFileName, gotFileType string
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DefaultTranscriptionsEndpoint || r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("请求地址或认证头错误: %s %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("解析 multipart 表单失败: %v", err)
+		}
+		gotForm = r.MultipartForm.Value
+		file, header, _ := r.FormFile("file")
+		data, _ := io.ReadAll(file)
+		gotFile, gotFileName, gotFileType = string(data), header.Filename, header.Header.Get("Content-Type")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"text":"你好 世界","language":"chinese","duration":2.5,`+
+			`"segments":[{"id":0,"start":0,"end":1.2,"text":"你好"},{"id":1,"start":1.2,"end":2.5,"text":"世界"}],`+
+			`"usage":{"type":"tokens","input_tokens":10,"output_tokens":4,"total_tokens":14}}`)
+	}))
+	var events []UsageEvent
+	client.config.OnUsage = func(event UsageEvent) { events = append(events, event) }
+
+	path := filepath.Join(t.TempDir(), "meeting.wav")
+	_ = os.WriteFile(path, []byte("RIFF-audio"), 0o644)
+	resp, err := client.CreateTranscription(context.Background(), TranscriptionRequest{
+		Model:                  "whisper-1",
+		FilePath:               path,
+		Language:               "zh",
+		ResponseFormat:         TranscriptionFormatVerboseJSON,
+		TimestampGranularities: []string{"segment", "word"},
+		CustomParams:           map[string]any{"chunking_strategy": "auto", "include": []string{"logprobs"}},
+	})
+	if err != nil {
+		t.Fatalf("转写失败: %v", err)
+	}
+
+	// 1. 表单字段和文件
+	if gotFile != "RIFF-audio" || gotFileName != "meeting.wav" || !strings.Contains(gotFileType, "wav") {
+		t.Errorf("上传的文件错误: %q %q %q", gotFile, gotFileName, gotFileType)
+	}
+	want := map[string]string{"model": "whisper-1", "language": "zh", "response_format": "verbose_json", "chunking_strategy": "auto", "include": `["logprobs"]`}
+	for k, v := range want {
+		if len(gotForm[k]) != 1 || gotForm[k][0] != v {
+			t.Errorf("表单字段 %s 错误: %v", k, gotForm[k])
+		}
+	}
+	if g := gotForm["timestamp_granularities[]"]; len(g) != 2 || g[0] != "segment" || g[1] != "word" {
+		t.Errorf("timestamp_granularities[] 错误: %v", g)
+	}
+
+	// 2. 分段和用量
+	if resp.Text != "你好 世界" || resp.Duration != 2.5 || len(resp.Segments) != 2 || resp.Segments[1].Start != 1.2 || resp.Segments[1].Text != "世界" {
+		t.Errorf("转写结果错误: %+v", resp)
+	}
+	if len(events) != 1 || events[0].Operation != "transcription" || events[0].Usage.TotalTokens != 14 {
+		t.Errorf("用量记录错误: %+v", events)
+	}
+}
+
+func TestCreateTranscription_TextFormatAndRetry(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("重试时应重新发送文件: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if calls.Add(1) == 1 {
+			http.Error(w, `{"error":{"message":"try later"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "1\n00:00:00,000 --> 00:00:01,000\n%s\n", data)
+	}))
+	client.config.Retry = fastRetryPolicy(2)
+
+	resp, err := client.CreateTranscription(context.Background(), TranscriptionRequest{
+		Model:          "whisper-1",
+		File:           strings.NewReader("hello"),
+		FileName:       "hello.mp3",
+		ResponseFormat: TranscriptionFormatSRT,
+	})
+	if err != nil {
+		t.Fatalf("转写失败: %v", err)
+	}
+	if calls.Load() != 2 || resp.Text != "1\n00:00:00,000 --> 00:00:01,000\nhello\n" {
+		t.Errorf("srt 结果应原样保存在 Text 中: calls=%d %q", calls.Load(), resp.Text)
+	}
+	// 没有 Token 用量的结果也计入请求次数
+	if stats := client.Usage(); stats.Requests != 1 || stats.TotalTokens != 0 {
+		t.Errorf("用量统计错误: %+v", stats)
+	}
+
+	if _, err := client.CreateTranscription(context.Background(), TranscriptionRequest{Model: "whisper-1"}); err == nil {
+		t.Error("没有音频时应返回错误")
+	}
+}
+
+func TestCreateSpeech(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := decodeRequest(t, r)
+		if r.URL.Path != DefaultSpeechEndpoint || payload["voice"] != "alloy" || payload["response_format"] != "wav" || payload["seed"] != float64(7) {
+			t.Errorf("请求错误: %s %v", r.URL.Path, payload)
+		}
+		if payload["input"] == "bad" {
+			http.Error(w, `{"error":{"message":"invalid input"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		flusher := w.(http.Flusher)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "chunk%d;", i)
+			flusher.Flush()
+		}
+	}))
+
+	var buf bytes.Buffer
+	request := SpeechRequest{Model: "tts-1", Input: "你好", Voice: "alloy", ResponseFormat: "wav", CustomParams: map[string]any{"seed": 7}}
+	n, err := client.CreateSpeech(context.Background(), request, &buf)
+	if err != nil {
+		t.Fatalf("合成失败: %v", err)
+	}
+	if buf.String() != "chunk0;chunk1;chunk2;" || n != int64(buf.Len()) {
+		t.Errorf("音频数据错误: %q (%d 字节)", buf.String(), n)
+	}
+
+	buf.Reset()
+	request.Input = "bad"
+	var apiErr *APIError
+	_, err = client.CreateSpeech(context.Background(), request, &buf)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "invalid input" {
+		t.Errorf("应返回服务端的错误: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("错误响应不应写入音频: %q", buf.String())
+	}
+}
@@ -0,0 +1,306 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// =================================================================================
+// 批量请求 (Batch)
+// =================================================================================
+
+// DefaultBatchConcurrency 是批量请求默认的并发数
+const DefaultBatchConcurrency = 8
+
+// BatchOptions 是批量请求的选项
+type BatchOptions struct {
+	// Concurrency 是同时进行的请求数，<=0 时使用 DefaultBatchConcurrency
+	Concurrency int
+	// RequestsPerMinute 限制每分钟发起的请求数，0 表示不限制
+	RequestsPerMinute int
+	// TokensPerMinute 限制每分钟消耗的 Token 数，0 表示不限制。
+	// 发送前按客户端的 Tokenizer 估算上下文的 Token 数加上 MaxTokens 预留额度，
+	// 请求完成后按服务端返回的实际用量修正，失败的请求归还预留的额度。
+	TokensPerMinute int
+	// Retry 是每个请求的重试策略，为空时使用 Config.Retry。
+	// 限流只在请求第一次发送前生效，重试的等待时间遵循服务端的 Retry-After。
+	Retry *RetryPolicy
+	// StopOnError 为 true 时，任一请求 (重试后仍然) 失败就取消其余请求
+	StopOnError bool
+	// OnProgress 在每个请求完成后调用，调用是串行的
+	OnProgress func(progress BatchProgress)
+}
+
+// BatchResult 是批量请求中一个请求的结果
+type BatchResult struct {
+	Index    int // 请求在输入中的下标
+	Request  ChatRequest
+	Response *ChatResponse
+	Err      error
+	Duration time.Duration // 包括限流等待和重试在内的耗时
+}
+
+// BatchProgress 是批量请求的进度
+type BatchProgress struct {
+	Total     int // 请求总数，输入为 channel 时为 0
+	Completed int // 已完成的请求数 (包括失败的)
+	Failed    int
+	Usage     Usage // 已完成请求的用量之和
+	Elapsed   time.Duration
+}
+
+// BatchError 表示批量请求中有请求失败，各请求的错误见对应的 BatchResult.Err
+type BatchError struct {
+	Total  int
+	Failed int
+	First  error // 下标最小的失败请求的错误
+}
+
+// Error 实现 error 接口
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch: %d of %d requests failed, first error: %v", e.Failed, e.Total, e.First)
+}
+
+// Unwrap 返回第一个失败请求的错误，以便使用 errors.Is 判断错误类别
+func (e *BatchError) Unwrap() error {
+	return e.First
+}
+
+// CreateChatCompletionBatch 并发地发起一组无状态的对话请求 (不读写历史记录)，按输入的顺序返回全部结果。
+// 有请求失败时同时返回 *BatchError，成功请求的结果仍然可用。请求经过客户端的中间件，并计入用量统计。
+//
+// 使用示例
+//
+//	results, err := client.CreateChatCompletionBatch(ctx, requests, aiutil.BatchOptions{
+//		Concurrency:       16,
+//		RequestsPerMinute: 500,
+//		TokensPerMinute:   200000,
+//		Retry:             aiutil.DefaultRetryPolicy(),
+//		OnProgress: func(p aiutil.BatchProgress) {
+//			log.Printf("%d/%d 完成，%d 失败", p.Completed, p.Total, p.Failed)
+//		},
+//	})
+func (c *Client) CreateChatCompletionBatch(ctx context.Context, requests []ChatRequest, opts BatchOptions) ([]BatchResult, error) {
+	in := make(chan ChatRequest)
+	go func() {
+		defer close(in)
+		for _, request := range requests {
+			in <- request
+		}
+	}()
+
+	results := make([]BatchResult, len(requests))
+	for result := range c.runBatch(ctx, in, len(requests), opts) {
+		results[result.Index] = result
+	}
+
+	batchErr := &BatchError{Total: len(requests)}
+	for _, result := range results {
+		if result.Err != nil {
+			if batchErr.Failed == 0 {
+				batchErr.First = result.Err
+			}
+			batchErr.Failed++
+		}
+	}
+	if batchErr.Failed > 0 {
+		return results, batchErr
+	}
+	return results, nil
+}
+
+// CreateChatCompletionBatchChan 与 CreateChatCompletionBatch 相同，但从 channel 读取请求，
+// 并按完成的顺序 (而不是输入的顺序) 发送结果，适合请求数量很大、需要边生成边处理的场景。
+// 每个输入的请求都对应一个结果 (ctx 取消后剩余的请求以错误结果返回)；
+// 调用方需要关闭 requests 并读完返回的 channel。
+func (c *Client) CreateChatCompletionBatchChan(ctx context.Context, requests <-chan ChatRequest, opts BatchOptions) <-chan BatchResult {
+	return c.runBatch(ctx, requests, 0, opts)
+}
+
+// runBatch 以有限的并发处理 in 中的请求，全部完成后关闭返回的 channel
+func (c *Client) runBatch(ctx context.Context, in <-chan ChatRequest, total int, opts BatchOptions) <-chan BatchResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	limiter := newRateLimiter(opts.RequestsPerMinute, opts.TokensPerMinute)
+	if opts.Retry != nil {
+		ctx = withRetryPolicy(ctx, opts.Retry)
+	}
+	ctx, stop := context.WithCancelCause(ctx)
+
+	out := make(chan BatchResult)
+	start := time.Now()
+	var mu sync.Mutex
+	progress := BatchProgress{Total: total}
+	// emit 更新进度并发送结果，串行调用 OnProgress
+	emit := func(result BatchResult) {
+		mu.Lock()
+		progress.Completed++
+		if result.Err != nil {
+			progress.Failed++
+		} else {
+			progress.Usage.PromptTokens += result.Response.Usage.PromptTokens
+			progress.Usage.CompletionTokens += result.Response.Usage.CompletionTokens
+			progress.Usage.TotalTokens += result.Response.Usage.TotalTokens
+		}
+		progress.Elapsed = time.Since(start)
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+		mu.Unlock()
+		out <- result
+	}
+
+	go func() {
+		defer close(out)
+		defer stop(nil)
+
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		index := 0
+		for request := range in {
+			i := index
+			index++
+
+			// 1. 批量请求已取消时，剩余的请求直接以错误结果返回
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				emit(BatchResult{Index: i, Request: request, Err: context.Cause(ctx)})
+				continue
+			}
+
+			// 2. 在独立的 goroutine 中等待限流并发送请求
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				result := c.runBatchRequest(ctx, limiter, i, request)
+				if result.Err != nil && opts.StopOnError {
+					stop(fmt.Errorf("batch stopped after request %d failed: %w", i, result.Err))
+				}
+				emit(result)
+			}()
+		}
+		wg.Wait()
+	}()
+	return out
+}
+
+// runBatchRequest 等待限流后发送单个请求
+func (c *Client) runBatchRequest(ctx context.Context, limiter *rateLimiter, index int, request ChatRequest) BatchResult {
+	start := time.Now()
+	result := BatchResult{Index: index, Request: request}
+
+	estimate := c.CountTokens(request.Messages) + request.MaxTokens
+	reserved, err := limiter.wait(ctx, estimate)
+	if err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	if err := ctx.Err(); err != nil {
+		limiter.adjust(-reserved)
+		result.Err = context.Cause(ctx)
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	// 成功时按实际用量修正额度，失败时归还预留的额度
+	result.Response, result.Err = c.chat(ctx, request)
+	if result.Err == nil {
+		limiter.adjust(result.Response.Usage.TotalTokens - reserved)
+	} else {
+		limiter.adjust(-reserved)
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+// ---------------------------------------------------------------------------------
+// 限流
+// ---------------------------------------------------------------------------------
+
+// rateLimiter 是按分钟计算的请求数和 Token 数的令牌桶，额度随时间连续恢复。
+// 为 nil 时不限流。
+type rateLimiter struct {
+	rpm, tpm int
+
+	mu       sync.Mutex
+	requests float64 // 当前可用的请求额度
+	tokens   float64 // 当前可用的 Token 额度，实际用量超出预估时可以为负数
+	last     time.Time
+}
+
+func newRateLimiter(rpm, tpm int) *rateLimiter {
+	if rpm <= 0 && tpm <= 0 {
+		return nil
+	}
+	return &rateLimiter{rpm: rpm, tpm: tpm, requests: float64(rpm), tokens: float64(tpm), last: time.Now()}
+}
+
+// refill 按经过的时间恢复额度，调用方需要持有锁
+func (l *rateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Minutes()
+	l.last = now
+	if l.rpm > 0 {
+		l.requests = min(l.requests+elapsed*float64(l.rpm), float64(l.rpm))
+	}
+	if l.tpm > 0 {
+		l.tokens = min(l.tokens+elapsed*float64(l.tpm), float64(l.tpm))
+	}
+}
+
+// wait 阻塞直到有一个请求和 tokens 个 Token 的额度，扣除额度并返回实际扣除的 Token 数。
+// 超过每分钟上限的 Token 数按上限计算，避免永远等待，因此修正额度时应以返回值为准。
+func (l *rateLimiter) wait(ctx context.Context, tokens int) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+	need := max(min(tokens, l.tpm), 0)
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.refill(now)
+		var delay time.Duration
+		if l.rpm > 0 && l.requests < 1 {
+			delay = max(delay, time.Duration((1-l.requests)/float64(l.rpm)*float64(time.Minute)))
+		}
+		if l.tpm > 0 && l.tokens < float64(need) {
+			delay = max(delay, time.Duration((float64(need)-l.tokens)/float64(l.tpm)*float64(time.Minute)))
+		}
+		if delay == 0 {
+			if l.rpm > 0 {
+				l.requests--
+			}
+			if l.tpm > 0 {
+				l.tokens -= float64(need)
+			}
+			l.mu.Unlock()
+			return need, nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, context.Cause(ctx)
+		}
+	}
+}
+
+// adjust 按实际用量与预估的差值修正 Token 额度
+func (l *rateLimiter) adjust(delta int) {
+	if l == nil || l.tpm <= 0 || delta == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.tokens = min(l.tokens-float64(delta), float64(l.tpm))
+}
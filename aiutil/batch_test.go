@@ -0,0 +1,208 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func batchRequests(n int) []ChatRequest {
+	requests := make([]ChatRequest, n)
+	for i := range requests {
+		requests[i] = ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: fmt.Sprintf("q%d", i)}}}
+	}
+	return requests
+}
+
+func TestCreateChatCompletionBatch(t *testing.T) {
+	var active, peak atomic.Int32
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.IntN(5)) * time.Millisecond)
+
+		payload := decodeRequest(t, r)
+		content := payload["messages"].([]any)[0].(map[string]any)["content"].(string)
+		mu.Lock()
+		attempts[content]++
+		first := attempts[content] == 1
+		mu.Unlock()
+		switch {
+		case content == "q3" && first: // 第一次限流，重试后成功
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"error":{"message":"slow down","code":"rate_limit_exceeded"}}`, http.StatusTooManyRequests)
+		case content == "q7": // 总是失败
+			http.Error(w, `{"error":{"message":"bad","code":"invalid_request"}}`, http.StatusBadRequest)
+		default:
+			writeChatResponse(w, "a"+strings.TrimPrefix(content, "q"))
+		}
+	}))
+
+	var progress []BatchProgress
+	results, err := client.CreateChatCompletionBatch(context.Background(), batchRequests(20), BatchOptions{
+		Concurrency: 3,
+		Retry:       &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+		OnProgress:  func(p BatchProgress) { progress = append(progress, p) },
+	})
+
+	var batchErr *BatchError
+	var apiErr *APIError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 1 || batchErr.Total != 20 || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("期望 1 个请求失败，实际 %v", err)
+	}
+	for i, result := range results {
+		if i == 7 {
+			if result.Err == nil {
+				t.Error("请求 7 应失败")
+			}
+			continue
+		}
+		if result.Err != nil || result.Index != i || result.Response.Choices[0].Message.Content != fmt.Sprintf("a%d", i) {
+			t.Errorf("结果 %d 顺序或内容错误: %+v", i, result)
+		}
+	}
+	if peak.Load() > 3 {
+		t.Errorf("并发数超出限制: %d", peak.Load())
+	}
+	if len(progress) != 20 {
+		t.Fatalf("每个请求完成后都应报告进度，实际 %d 次", len(progress))
+	}
+	if last := progress[19]; last.Completed != 20 || last.Failed != 1 || last.Total != 20 || last.Usage.TotalTokens != 19*5 {
+		t.Errorf("最终进度错误: %+v", last)
+	}
+}
+
+func TestCreateChatCompletionBatch_StopOnError(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, `{"error":{"message":"bad"}}`, http.StatusBadRequest)
+	}))
+
+	results, err := client.CreateChatCompletionBatch(context.Background(), batchRequests(50), BatchOptions{Concurrency: 1, StopOnError: true})
+	if err == nil || len(results) != 50 {
+		t.Fatalf("期望返回错误和全部结果: %d, %v", len(results), err)
+	}
+	if calls.Load() > 2 {
+		t.Errorf("失败后应停止发送请求，实际发送 %d 个", calls.Load())
+	}
+	if results[49].Err == nil || !strings.Contains(results[49].Err.Error(), "batch stopped") {
+		t.Errorf("未发送的请求应返回停止原因: %v", results[49].Err)
+	}
+}
+
+func TestCreateChatCompletionBatch_FailedRequestReleasesTokens(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, `{"error":{"message":"bad"}}`, http.StatusBadRequest)
+			return
+		}
+		writeChatResponse(w, "ok")
+	}))
+
+	// 每个请求预留整分钟的额度，失败的请求归还额度后，第二个请求不需要等待
+	requests := batchRequests(2)
+	for i := range requests {
+		requests[i].MaxTokens = 1000
+	}
+	start := time.Now()
+	results, _ := client.CreateChatCompletionBatch(context.Background(), requests, BatchOptions{Concurrency: 1, TokensPerMinute: 1000})
+	if results[0].Err == nil || results[1].Err != nil {
+		t.Fatalf("第一个请求应失败、第二个应成功: %v, %v", results[0].Err, results[1].Err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("失败的请求不应消耗额度，实际等待 %v", elapsed)
+	}
+}
+
+func TestCreateChatCompletionBatchChan(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeChatResponse(w, "ok")
+	}))
+
+	in := make(chan ChatRequest)
+	go func() {
+		defer close(in)
+		for _, request := range batchRequests(10) {
+			in <- request
+		}
+	}()
+	seen := make(map[int]bool)
+	for result := range client.CreateChatCompletionBatchChan(context.Background(), in, BatchOptions{}) {
+		if result.Err != nil {
+			t.Errorf("请求 %d 失败: %v", result.Index, result.Err)
+		}
+		seen[result.Index] = true
+	}
+	if len(seen) != 10 {
+		t.Errorf("每个请求都应有结果，实际 %d 个", len(seen))
+	}
+	if client.Usage().Requests != 10 {
+		t.Errorf("批量请求应计入用量: %+v", client.Usage())
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	// 每分钟 6000 Token，即每 10ms 恢复 1 Token；用完后等待 5 Token 约需 50ms
+	limiter := newRateLimiter(0, 6000)
+	if _, err := limiter.wait(ctx, 6000); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := limiter.wait(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("额度用完后应等待，实际 %v", elapsed)
+	}
+
+	// 实际用量超出预估时，额度变为负数，需要等待更久
+	limiter.adjust(6000)
+	if limiter.tokens > -5000 {
+		t.Errorf("超出预估的用量应从额度中扣除: %v", limiter.tokens)
+	}
+
+	// 预估超过每分钟上限时按上限扣除，修正额度应以实际扣除的数量为准
+	limiter = newRateLimiter(0, 1000)
+	reserved, _ := limiter.wait(ctx, 5000)
+	if reserved != 1000 || limiter.tokens != 0 {
+		t.Errorf("应按上限扣除: reserved=%d tokens=%v", reserved, limiter.tokens)
+	}
+	limiter.adjust(800 - reserved)
+	if limiter.tokens < 199 || limiter.tokens > 201 {
+		t.Errorf("修正后的额度应为未使用的 200，实际 %v", limiter.tokens)
+	}
+
+	// 请求数额度用完时等待，ctx 取消后返回
+	limiter = newRateLimiter(6000, 0)
+	for i := 0; i < 6000; i++ {
+		_, _ = limiter.wait(ctx, 0)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := limiter.wait(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("请求额度用完且 ctx 超时时应返回错误，实际 %v", err)
+	}
+
+	if _, err := (*rateLimiter)(nil).wait(ctx, 1); err != nil {
+		t.Errorf("未配置限流时不应等待: %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package aiutil
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Bronya0/go-utils/fileutil"
+)
+
+// =================================================================================
+// 响应缓存 (ResponseCache)
+// =================================================================================
+
+// CacheStore 保存序列化后的响应。实现必须是并发安全的。
+type CacheStore interface {
+	// Get 返回 key 对应的值，不存在或已过期时 ok 为 false
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set 保存 key 对应的值，ttl 为 0 表示永不过期
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// ResponseCache 为对话请求提供响应缓存，适合反复运行相同提示词的评测任务。
+//
+// 缓存键是请求地址与 Provider 编码后的完整请求体 (包括 CustomParams) 的 SHA-256，
+// 请求头 (包括认证信息) 不参与计算。流式请求与同步请求共用缓存：计算缓存键时忽略
+// Stream 和 StreamOptions，命中时把缓存的响应按数据块重放为流，未命中时流正常结束后写入缓存。
+// 命中缓存的请求不发送、不计入用量；出错或中途取消的请求不会写入缓存。
+//
+// 使用示例
+//
+//	config.Cache = &aiutil.ResponseCache{Store: aiutil.NewMemoryCacheStore(1000), TTL: time.Hour}
+type ResponseCache struct {
+	// Store 是缓存的存储，例如 MemoryCacheStore 或 FileCacheStore
+	Store CacheStore
+	// TTL 是缓存的有效期，0 表示永不过期
+	TTL time.Duration
+	// ShouldCache 决定请求是否使用缓存，为空时所有请求都使用缓存。
+	// 例如只缓存 Temperature 为 0 的确定性请求。
+	ShouldCache func(request ChatRequest) bool
+}
+
+// cacheKey 计算请求的缓存键，请求不使用缓存时返回空字符串
+func (c *Client) cacheKey(request ChatRequest) (string, error) {
+	cache := c.config.Cache
+	if cache == nil || cache.Store == nil || (cache.ShouldCache != nil && !cache.ShouldCache(request)) {
+		return "", nil
+	}
+	request.Stream = false
+	request.StreamOptions = nil
+	endpoint, payload, err := c.encodeChatRequest(request)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(c.config.BaseURL + endpoint + "\n"))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadCachedResponse 返回请求对应的缓存响应，读取缓存失败时视为未命中
+func (c *Client) loadCachedResponse(ctx context.Context, request ChatRequest) (*ChatResponse, bool) {
+	key, err := c.cacheKey(request)
+	if err != nil || key == "" {
+		return nil, false
+	}
+	value, ok, err := c.config.Cache.Store.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false
+	}
+	var resp ChatResponse
+	if err := json.Unmarshal(value, &resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// storeCachedResponse 将响应写入缓存，写入失败只记录日志，不影响本次请求
+func (c *Client) storeCachedResponse(ctx context.Context, request ChatRequest, resp *ChatResponse) {
+	key, err := c.cacheKey(request)
+	if err != nil || key == "" {
+		return
+	}
+	value, err := json.Marshal(resp)
+	if err == nil {
+		err = c.config.Cache.Store.Set(ctx, key, value, c.config.Cache.TTL)
+	}
+	if err != nil {
+		log.Printf("aiutil: 写入响应缓存失败: %v", err)
+	}
+}
+
+// replayStream 将缓存的响应作为流发送: 每个候选回复一个数据块，用量单独一个数据块
+func (c *Client) replayStream(ctx context.Context, resp *ChatResponse, onComplete func(reply ChatMessage) error) <-chan StreamEvent {
+	streamChan := make(chan StreamEvent)
+	go func() {
+		defer close(streamChan)
+		var acc StreamAccumulator
+		for _, chunk := range responseChunks(resp) {
+			acc.Add(chunk)
+			if !sendEvent(ctx, streamChan, StreamEvent{Data: chunk}) {
+				return
+			}
+		}
+		if err := onComplete(acc.Message()); err != nil {
+			sendEvent(ctx, streamChan, StreamEvent{Error: err})
+		}
+	}()
+	return streamChan
+}
+
+// responseChunks 将完整的响应拆分为流式数据块
+func responseChunks(resp *ChatResponse) []ChatStreamResponse {
+	chunk := func(choices []ChatStreamChoice) ChatStreamResponse {
+		return ChatStreamResponse{ID: resp.ID, Object: "chat.completion.chunk", Created: resp.Created, Model: resp.Model, Choices: choices}
+	}
+	var chunks []ChatStreamResponse
+	for _, choice := range resp.Choices {
+		delta := ChatDelta{Role: choice.Message.Role, Content: choice.Message.Content, ReasoningContent: choice.Message.ReasoningContent}
+		for i, call := range choice.Message.ToolCalls {
+			index := i
+			call.Index = &index
+			delta.ToolCalls = append(delta.ToolCalls, call)
+		}
+		chunks = append(chunks, chunk([]ChatStreamChoice{{Index: choice.Index, Delta: delta, FinishReason: choice.FinishReason}}))
+	}
+	if resp.Usage != (Usage{}) {
+		usage := resp.Usage
+		last := chunk([]ChatStreamChoice{})
+		last.Usage = &usage
+		chunks = append(chunks, last)
+	}
+	return chunks
+}
+
+// ---------------------------------------------------------------------------------
+// 内存缓存
+// ---------------------------------------------------------------------------------
+
+// MemoryCacheStore 是基于 LRU 淘汰的内存缓存，进程重启后丢失
+type MemoryCacheStore struct {
+	capacity int
+
+	mu    sync.Mutex
+	ll    *list.List // 最近使用的在前
+	items map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // 零值表示永不过期
+}
+
+// NewMemoryCacheStore 创建一个最多保存 capacity 个条目的内存缓存，capacity <= 0 表示不限制
+func NewMemoryCacheStore(capacity int) *MemoryCacheStore {
+	return &MemoryCacheStore{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get 返回 key 对应的值，并将其标记为最近使用
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		s.ll.Remove(elem)
+		delete(s.items, key)
+		return nil, false, nil
+	}
+	s.ll.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+// Set 保存 key 对应的值，超出容量时淘汰最久未使用的条目
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	entry := &memoryCacheEntry{key: key, value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.items[key]; ok {
+		elem.Value = entry
+		s.ll.MoveToFront(elem)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(entry)
+	for s.capacity > 0 && s.ll.Len() > s.capacity {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+// Len 返回缓存中的条目数 (可能包含尚未清理的过期条目)
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// ---------------------------------------------------------------------------------
+// 文件缓存
+// ---------------------------------------------------------------------------------
+
+// FileCacheStore 将每个条目保存为目录下的一个 JSON 文件 (文件名为缓存键)，
+// 进程重启后仍然有效，也可以随评测数据一起保存以便复现。值必须是合法的 JSON。
+type FileCacheStore struct {
+	dir string
+}
+
+type fileCacheEntry struct {
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// NewFileCacheStore 创建一个基于文件的缓存，目录不存在时自动创建
+func NewFileCacheStore(dir string) (*FileCacheStore, error) {
+	if err := fileutil.EnsureDir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &FileCacheStore{dir: dir}, nil
+}
+
+// Path 返回缓存条目的文件路径
+func (s *FileCacheStore) Path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+// Get 读取缓存文件，过期的文件会被删除
+func (s *FileCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(s.Path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache file: %w", err)
+	}
+	var entry fileCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("invalid cache file %s: %w", s.Path(key), err)
+	}
+	if entry.ExpiresAt != nil && time.Now().After(*entry.ExpiresAt) {
+		_ = os.Remove(s.Path(key))
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// Set 通过临时文件原子地写入缓存文件
+func (s *FileCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !json.Valid(value) {
+		return fmt.Errorf("file cache value must be valid json")
+	}
+	entry := fileCacheEntry{Value: value}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		entry.ExpiresAt = &expiresAt
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(s.dir, "cache-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp cache file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // 重命名成功后删除会失败，可以忽略
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write temp cache file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp cache file: %w", err)
+	}
+	if err := fileutil.SafeRename(tempFile.Name(), s.Path(key)); err != nil {
+		return fmt.Errorf("failed to replace cache file: %w", err)
+	}
+	return nil
+}
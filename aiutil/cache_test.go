@@ -0,0 +1,149 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStore(2)
+	_ = store.Set(ctx, "a", []byte("1"), 0)
+	_ = store.Set(ctx, "b", []byte("2"), 0)
+	_, _, _ = store.Get(ctx, "a") // a 成为最近使用
+	_ = store.Set(ctx, "c", []byte("3"), 0)
+
+	if _, ok, _ := store.Get(ctx, "b"); ok {
+		t.Error("超出容量时应淘汰最久未使用的条目")
+	}
+	if v, ok, _ := store.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Errorf("最近使用的条目不应被淘汰: %q", v)
+	}
+
+	_ = store.Set(ctx, "d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := store.Get(ctx, "d"); ok {
+		t.Error("过期的条目不应命中")
+	}
+	if store.Len() != 1 { // 写入 d 时淘汰了 c，读取 d 时清理了 d
+		t.Errorf("过期条目应在读取时清理，实际条目数 %d", store.Len())
+	}
+}
+
+func TestFileCacheStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileCacheStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建文件缓存失败: %v", err)
+	}
+
+	if err := store.Set(ctx, "k", []byte(`{"a":1}`), 0); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if v, ok, err := store.Get(ctx, "k"); err != nil || !ok || string(v) != `{"a":1}` {
+		t.Errorf("读取结果错误: %q, %v, %v", v, ok, err)
+	}
+	if err := store.Set(ctx, "bad", []byte("not json"), 0); err == nil {
+		t.Error("非 JSON 的值应返回错误")
+	}
+
+	_ = store.Set(ctx, "short", []byte(`1`), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := store.Get(ctx, "short"); ok {
+		t.Error("过期的条目不应命中")
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if decodeRequest(t, r)["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"id\":\"s1\",\"choices\":[{\"delta\":{\"content\":\"流式\"},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		writeChatResponse(w, "同步")
+	}))
+	client.config.Cache = &ResponseCache{
+		Store:       NewMemoryCacheStore(0),
+		ShouldCache: func(req ChatRequest) bool { return req.Temperature == 0 },
+	}
+	ctx := context.Background()
+	request := ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}
+
+	// 同步请求: 第二次命中缓存，且不计入用量
+	for i := 0; i < 2; i++ {
+		resp, err := client.doChatCompletion(ctx, request)
+		if err != nil || resp.Choices[0].Message.Content != "同步" {
+			t.Fatalf("第 %d 次请求结果错误: %+v, %v", i+1, resp, err)
+		}
+	}
+	if calls.Load() != 1 || client.Usage().Requests != 1 {
+		t.Errorf("相同的请求应命中缓存: calls=%d, usage=%+v", calls.Load(), client.Usage())
+	}
+
+	// 流式请求与同步请求共用缓存，命中时重放为流
+	stream, err := client.doSSEStream(ctx, request, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err := CollectStream(stream)
+	if err != nil || resp.Choices[0].Message.Content != "同步" || resp.Choices[0].FinishReason != "stop" || resp.Usage.TotalTokens != 5 {
+		t.Errorf("重放的流错误: %+v, %v", resp, err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("命中缓存的流式请求不应发送: calls=%d", calls.Load())
+	}
+
+	// 自定义参数不同则缓存键不同；流式请求未命中时，流结束后写入缓存
+	request.CustomParams = map[string]any{"seed": 1}
+	stream, _ = client.doSSEStream(ctx, request, func(ChatMessage) error { return nil })
+	if _, err := CollectStream(stream); err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err = client.doChatCompletion(ctx, request)
+	if err != nil || resp.ID != "s1" || resp.Choices[0].Message.Content != "流式" || calls.Load() != 2 {
+		t.Errorf("流式结果应写入缓存: %+v, calls=%d", resp, calls.Load())
+	}
+
+	// ShouldCache 返回 false 的请求不使用缓存
+	request.Temperature = 0.7
+	for i := 0; i < 2; i++ {
+		if _, err := client.doChatCompletion(ctx, request); err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+	}
+	if calls.Load() != 4 {
+		t.Errorf("不使用缓存的请求每次都应发送: calls=%d", calls.Load())
+	}
+}
+
+func TestResponseCache_ConversationHistory(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeChatResponse(w, "回复")
+	}))
+	client.config.Cache = &ResponseCache{Store: NewMemoryCacheStore(0)}
+	ctx := context.Background()
+	request := ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}
+
+	// 两个会话的上下文相同，第二个命中缓存，但历史记录各自更新
+	for _, id := range []string{"a", "b"} {
+		if _, err := client.Conversation(id).CreateChatCompletion(ctx, request); err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		if history := client.Conversation(id).GetHistory(); len(history) != 2 {
+			t.Errorf("会话 %s 的历史记录应被更新: %+v", id, history)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("上下文相同的请求应命中缓存: calls=%d", calls.Load())
+	}
+}
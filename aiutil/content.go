@@ -0,0 +1,282 @@
+package aiutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"  // 注册 GIF 解码器，用于计算图片的 Token 数
+	_ "image/jpeg" // 注册 JPEG 解码器
+	_ "image/png"  // 注册 PNG 解码器
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// =================================================================================
+// 多模态消息内容 (图片、音频、文件)
+// =================================================================================
+
+// ContentPart 的类型
+const (
+	ContentPartText       = "text"
+	ContentPartImageURL   = "image_url"
+	ContentPartInputAudio = "input_audio"
+	ContentPartFile       = "file"
+)
+
+// 图片的解析精度
+const (
+	ImageDetailAuto = "auto"
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+)
+
+// ContentPart 是多模态消息中的一段内容，根据 Type 使用对应的字段
+type ContentPart struct {
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+	File       *FileData   `json:"file,omitempty"`
+}
+
+// ImageURL 是图片的地址，可以是 http(s) 链接或 "data:image/png;base64,..." 形式的 Data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"` // ImageDetailAuto、ImageDetailLow 或 ImageDetailHigh
+}
+
+// InputAudio 是 base64 编码的音频
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"` // "wav" 或 "mp3"
+}
+
+// FileData 是随消息发送的文件 (例如 PDF)，FileID 和 FileData 二选一
+type FileData struct {
+	FileID   string `json:"file_id,omitempty"`   // 已上传文件的 ID
+	Filename string `json:"filename,omitempty"`  // 内联文件的文件名
+	FileData string `json:"file_data,omitempty"` // 内联文件的 Data URL
+}
+
+// MarshalJSON 在 MultiContent 不为空时将 content 序列化为数组形式，否则保持字符串形式
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.MultiContent) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), m.MultiContent})
+}
+
+// UnmarshalJSON 同时支持字符串和数组形式的 content
+func (m *ChatMessage) UnmarshalJSON(data []byte) error {
+	type plain ChatMessage
+	var aux struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*m = ChatMessage(aux.plain)
+
+	content := bytes.TrimSpace(aux.Content)
+	switch {
+	case len(content) == 0 || string(content) == "null":
+	case content[0] == '[':
+		return json.Unmarshal(content, &m.MultiContent)
+	default:
+		return json.Unmarshal(content, &m.Content)
+	}
+	return nil
+}
+
+// Text 返回消息的文本内容。多模态消息返回所有文本片段拼接的结果。
+func (m ChatMessage) Text() string {
+	if len(m.MultiContent) == 0 {
+		return m.Content
+	}
+	var sb strings.Builder
+	for _, part := range m.MultiContent {
+		if part.Type == ContentPartText {
+			if sb.Len() > 0 {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+// UserMessage 创建一条由多段内容组成的 user 消息
+func UserMessage(parts ...ContentPart) ChatMessage {
+	return ChatMessage{Role: RoleUser, MultiContent: parts}
+}
+
+// TextPart 创建一段文本内容
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: ContentPartText, Text: text}
+}
+
+// ImageURLPart 创建一段引用图片地址的内容，detail 可以为空
+func ImageURLPart(url, detail string) ContentPart {
+	return ContentPart{Type: ContentPartImageURL, ImageURL: &ImageURL{URL: url, Detail: detail}}
+}
+
+// ImagePartFromFile 读取本地图片并以 Data URL 的形式内联到消息中
+func ImagePartFromFile(path, detail string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	return imagePart(data, mimeFromExtension(path), detail)
+}
+
+// ImagePartFromReader 读取 r 中的图片并以 Data URL 的形式内联到消息中，图片类型通过文件头识别
+func ImagePartFromReader(r io.Reader, detail string) (ContentPart, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	return imagePart(data, "", detail)
+}
+
+func imagePart(data []byte, fallback, detail string) (ContentPart, error) {
+	mimeType := sniffMIME(data, fallback)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ContentPart{}, fmt.Errorf("unsupported image type %q", mimeType)
+	}
+	return ImageURLPart(dataURL(mimeType, data), detail), nil
+}
+
+// InputAudioPart 创建一段音频内容，format 为 "wav" 或 "mp3"
+func InputAudioPart(data []byte, format string) ContentPart {
+	return ContentPart{Type: ContentPartInputAudio, InputAudio: &InputAudio{
+		Data:   base64.StdEncoding.EncodeToString(data),
+		Format: format,
+	}}
+}
+
+// AudioPartFromFile 读取本地的 wav 或 mp3 文件作为音频内容
+func AudioPartFromFile(path string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read audio: %w", err)
+	}
+	return audioPart(data, mimeFromExtension(path))
+}
+
+// AudioPartFromReader 读取 r 中的 wav 或 mp3 音频作为音频内容，格式通过文件头识别
+func AudioPartFromReader(r io.Reader) (ContentPart, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read audio: %w", err)
+	}
+	return audioPart(data, "")
+}
+
+func audioPart(data []byte, fallback string) (ContentPart, error) {
+	switch mimeType := sniffMIME(data, fallback); mimeType {
+	case "audio/wave", "audio/wav", "audio/x-wav":
+		return InputAudioPart(data, "wav"), nil
+	case "audio/mpeg", "audio/mp3":
+		return InputAudioPart(data, "mp3"), nil
+	default:
+		return ContentPart{}, fmt.Errorf("unsupported audio type %q", mimeType)
+	}
+}
+
+// FileIDPart 创建一段引用已上传文件的内容
+func FileIDPart(fileID string) ContentPart {
+	return ContentPart{Type: ContentPartFile, File: &FileData{FileID: fileID}}
+}
+
+// FilePartFromFile 读取本地文件 (例如 PDF) 并内联到消息中
+func FilePartFromFile(path string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return filePart(data, filepath.Base(path), mimeFromExtension(path)), nil
+}
+
+// FilePartFromReader 读取 r 中的文件并以 filename 为文件名内联到消息中
+func FilePartFromReader(r io.Reader, filename string) (ContentPart, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return filePart(data, filename, mimeFromExtension(filename)), nil
+}
+
+func filePart(data []byte, filename, fallback string) ContentPart {
+	return ContentPart{Type: ContentPartFile, File: &FileData{
+		Filename: filename,
+		FileData: dataURL(sniffMIME(data, fallback), data),
+	}}
+}
+
+// sniffMIME 与 fileutil.SaveFile 一样读取前 512 个字节识别 MIME 类型，
+// 无法识别时使用 fallback (通常来自扩展名)
+func sniffMIME(data []byte, fallback string) string {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if (mimeType == "application/octet-stream" || mimeType == "text/plain") && fallback != "" {
+		return fallback
+	}
+	return mimeType
+}
+
+// mimeFromExtension 根据文件扩展名推断 MIME 类型
+func mimeFromExtension(path string) string {
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(path)), ";")
+	return mimeType
+}
+
+func dataURL(mimeType string, data []byte) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// imageTokens 按照 OpenAI 的规则估算一张图片占用的 Token 数:
+// low 精度固定 85；其余情况先缩放到 2048x2048 以内、短边不超过 768，
+// 再按 512x512 的图块计算 85 + 170*图块数。无法读取尺寸 (例如远程图片) 时按 1024x1024 估算。
+func imageTokens(img *ImageURL) int {
+	const base, perTile = 85, 170
+	if img.Detail == ImageDetailLow {
+		return base
+	}
+
+	width, height := 1024, 1024
+	if _, encoded, ok := strings.Cut(img.URL, ";base64,"); ok && strings.HasPrefix(img.URL, "data:") {
+		if data, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				width, height = cfg.Width, cfg.Height
+			}
+		}
+	}
+	if width <= 0 || height <= 0 {
+		return base
+	}
+
+	w, h := float64(width), float64(height)
+	if scale := 2048 / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	if scale := 768 / math.Min(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	tiles := int(math.Ceil(w/512) * math.Ceil(h/512))
+	return base + perTile*tiles
+}
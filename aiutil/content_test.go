@@ -0,0 +1,137 @@
+package aiutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestChatMessage_JSON(t *testing.T) {
+	// 纯文本消息保持字符串形式
+	b, _ := json.Marshal(ChatMessage{Role: RoleUser, Content: "hi"})
+	if string(b) != `{"role":"user","content":"hi"}` {
+		t.Errorf("纯文本消息序列化错误: %s", b)
+	}
+
+	msg := UserMessage(TextPart("这是什么？"), ImageURLPart("https://example.com/a.png", ImageDetailLow))
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"user","content":[{"type":"text","text":"这是什么？"},{"type":"image_url","image_url":{"url":"https://example.com/a.png","detail":"low"}}]}`
+	if string(b) != want {
+		t.Errorf("多模态消息序列化错误:\n got: %s\nwant: %s", b, want)
+	}
+
+	var decoded ChatMessage
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("反序列化结果不一致: %+v", decoded)
+	}
+	if decoded.Text() != "这是什么？" {
+		t.Errorf("Text 返回错误: %q", decoded.Text())
+	}
+
+	if err := json.Unmarshal([]byte(`{"role":"assistant","content":null,"tool_calls":[{"id":"c1","type":"function","function":{"name":"f","arguments":"{}"}}]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Content != "" || decoded.MultiContent != nil || len(decoded.ToolCalls) != 1 {
+		t.Errorf("content 为 null 的消息解析错误: %+v", decoded)
+	}
+}
+
+func TestImagePart(t *testing.T) {
+	data := testPNG(t, 2, 2)
+	part, err := ImagePartFromReader(bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatalf("ImagePartFromReader 失败: %v", err)
+	}
+	if !strings.HasPrefix(part.ImageURL.URL, "data:image/png;base64,") {
+		t.Errorf("Data URL 错误: %s", part.ImageURL.URL[:40])
+	}
+
+	path := filepath.Join(t.TempDir(), "a.png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if fromFile, err := ImagePartFromFile(path, ""); err != nil || fromFile.ImageURL.URL != part.ImageURL.URL {
+		t.Errorf("ImagePartFromFile 结果不一致: %v", err)
+	}
+
+	if _, err := ImagePartFromReader(strings.NewReader("not an image"), ""); err == nil {
+		t.Error("非图片内容应返回错误")
+	}
+}
+
+func TestFileAndAudioParts(t *testing.T) {
+	pdf, err := FilePartFromReader(strings.NewReader("%PDF-1.4 test"), "doc.pdf")
+	if err != nil || pdf.File.Filename != "doc.pdf" || !strings.HasPrefix(pdf.File.FileData, "data:application/pdf;base64,") {
+		t.Errorf("FilePartFromReader 结果错误: %+v, %v", pdf.File, err)
+	}
+
+	wav := append([]byte("RIFF\x00\x00\x00\x00WAVEfmt "), make([]byte, 32)...)
+	audio, err := AudioPartFromReader(bytes.NewReader(wav))
+	if err != nil || audio.InputAudio.Format != "wav" {
+		t.Errorf("AudioPartFromReader 结果错误: %+v, %v", audio, err)
+	}
+}
+
+func TestImageTokens(t *testing.T) {
+	tests := []struct {
+		width, height int
+		detail        string
+		want          int
+	}{
+		{512, 512, "", 85 + 170*1},
+		{1024, 1024, "", 85 + 170*4},
+		{2048, 4096, "", 85 + 170*6}, // 缩放到 1024x2048 再到 768x1536
+		{64, 64, ImageDetailLow, 85},
+	}
+	for _, tt := range tests {
+		part, err := ImagePartFromReader(bytes.NewReader(testPNG(t, tt.width, tt.height)), tt.detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := imageTokens(part.ImageURL); got != tt.want {
+			t.Errorf("%dx%d(%s): 期望 %d，实际 %d", tt.width, tt.height, tt.detail, tt.want, got)
+		}
+	}
+}
+
+func TestCreateChatCompletion_MultiContent(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		messages, _ := decodeRequest(t, r)["messages"].([]any)
+		last, _ := messages[len(messages)-1].(map[string]any)
+		parts, ok := last["content"].([]any)
+		if !ok || len(parts) != 2 {
+			t.Errorf("期望以数组形式发送 content，实际 %v", last["content"])
+		}
+		writeChatResponse(w, "一只猫")
+	}))
+
+	msg := UserMessage(TextPart("图里是什么？"), ImageURLPart("https://example.com/cat.png", ""))
+	if _, err := client.CreateChatCompletion(t.Context(), ChatRequest{Model: "test-model", Messages: []ChatMessage{msg}}); err != nil {
+		t.Fatal(err)
+	}
+	if history := client.GetHistory(); len(history) != 2 || len(history[0].MultiContent) != 2 {
+		t.Errorf("多模态消息应完整写入历史记录: %+v", history)
+	}
+}
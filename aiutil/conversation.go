@@ -0,0 +1,225 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+)
+
+// =================================================================================
+// 会话 (Conversation)
+// =================================================================================
+
+// DefaultConversationID 是 Client 默认会话的 ID，直接调用 Client 的对话方法时使用该会话
+const DefaultConversationID = "default"
+
+// DefaultMaxConversations 是 Client 默认在内存中保留的会话数，见 Config.MaxConversations
+const DefaultMaxConversations = 10000
+
+// Conversation 是一段独立的对话，拥有自己的历史记录。
+// 多个会话共享同一个 Client 的配置和连接池，互不干扰，适合一个服务同时服务多个用户。
+// 历史记录保存在 Config.HistoryStore 中，以会话 ID 为键。
+//
+// 会话的历史记录是并发安全的。但同一个会话上并发发起的多个请求之间没有先后顺序的保证，
+// 它们看到的历史快照取决于各自开始的时间。
+//
+// 使用示例
+//
+//	client := aiutil.NewClient(config)
+//	// 在 HTTP handler 中按用户获取会话
+//	conv := client.Conversation(userID)
+//	resp, err := conv.CreateChatCompletion(r.Context(), request)
+type Conversation struct {
+	id     string
+	client *Client
+	usage  usageCounter
+}
+
+func newConversation(client *Client, id string) *Conversation {
+	return &Conversation{id: id, client: client}
+}
+
+// Conversation 返回指定 ID 的会话，不存在时自动创建。
+// 新创建的会话会继续使用 HistoryStore 中已有的历史记录，包括被淘汰 (见 Config.MaxConversations) 的会话。
+func (c *Client) Conversation(id string) *Conversation {
+	if id == DefaultConversationID {
+		return c.defaultConv
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.conversations[id]; ok {
+		c.convLRU.MoveToFront(elem)
+		return elem.Value.(*Conversation)
+	}
+
+	// 超出上限时淘汰最久未使用的会话，它的历史记录仍保留在 HistoryStore 中
+	conv := newConversation(c, id)
+	c.conversations[id] = c.convLRU.PushFront(conv)
+	for c.config.MaxConversations > 0 && c.convLRU.Len() > c.config.MaxConversations {
+		oldest := c.convLRU.Back()
+		c.convLRU.Remove(oldest)
+		delete(c.conversations, oldest.Value.(*Conversation).id)
+	}
+	return conv
+}
+
+// DeleteConversation 删除指定 ID 的会话及其历史记录
+func (c *Client) DeleteConversation(ctx context.Context, id string) error {
+	c.mu.Lock()
+	if elem, ok := c.conversations[id]; ok {
+		c.convLRU.Remove(elem)
+		delete(c.conversations, id)
+	}
+	c.mu.Unlock()
+	return c.config.HistoryStore.Delete(ctx, id)
+}
+
+// ConversationIDs 按最近使用的顺序返回内存中保留的会话的 ID，不包括客户端的默认会话
+func (c *Client) ConversationIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, c.convLRU.Len())
+	for elem := c.convLRU.Front(); elem != nil; elem = elem.Next() {
+		ids = append(ids, elem.Value.(*Conversation).id)
+	}
+	return ids
+}
+
+// ID 返回会话的 ID
+func (cv *Conversation) ID() string {
+	return cv.id
+}
+
+// CreateChatCompletion 在会话中发起一个同步的对话请求
+func (cv *Conversation) CreateChatCompletion(ctx context.Context, request ChatRequest) (*ChatResponse, error) {
+	// 1. 准备消息（合并历史记录）
+	ctx = withConversation(ctx, cv)
+	newMessages := request.Messages
+	messages, err := cv.buildMessages(ctx, request.Model, newMessages)
+	if err != nil {
+		return nil, err
+	}
+	request.Messages = messages
+
+	// 2. 经过中间件发送请求
+	result, err := cv.client.chat(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. 成功后，更新历史记录
+	var reply []ChatMessage
+	if len(result.Choices) > 0 {
+		reply = append(reply, result.Choices[0].Message)
+	}
+	if err := cv.appendHistory(ctx, newMessages, reply...); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// CreateChatCompletionSSEStream 在会话中通过 SSE 发起一个流式的对话请求，流结束后更新历史记录
+// 取消 ctx 时流立即结束，不完整的回复不会写入历史记录
+func (cv *Conversation) CreateChatCompletionSSEStream(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+	ctx = withConversation(ctx, cv)
+	newMessages := request.Messages
+	messages, err := cv.buildMessages(ctx, request.Model, newMessages)
+	if err != nil {
+		return nil, err
+	}
+	request.Messages = messages
+	return cv.client.stream(ctx, request, cv.client.doSSEStream, func(reply ChatMessage) error {
+		return cv.appendHistory(ctx, newMessages, reply)
+	})
+}
+
+// CreateChatCompletionWebSocketStream 在会话中通过 WebSocket 发起一个流式的对话请求，流结束后更新历史记录
+func (cv *Conversation) CreateChatCompletionWebSocketStream(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+	ctx = withConversation(ctx, cv)
+	newMessages := request.Messages
+	messages, err := cv.buildMessages(ctx, request.Model, newMessages)
+	if err != nil {
+		return nil, err
+	}
+	request.Messages = messages
+	return cv.client.stream(ctx, request, cv.client.doWebSocketStream, func(reply ChatMessage) error {
+		return cv.appendHistory(ctx, newMessages, reply)
+	})
+}
+
+// LoadHistory 从存储中读取会话的全部历史记录
+func (cv *Conversation) LoadHistory(ctx context.Context) ([]ChatMessage, error) {
+	return cv.client.config.HistoryStore.Load(ctx, cv.id)
+}
+
+// TruncateHistory 只保留会话最近的 keepLast 条历史记录
+func (cv *Conversation) TruncateHistory(ctx context.Context, keepLast int) error {
+	return cv.client.config.HistoryStore.Truncate(ctx, cv.id, keepLast)
+}
+
+// GetHistory 返回当前对话历史，读取失败时返回 nil。需要处理错误时请使用 LoadHistory。
+func (cv *Conversation) GetHistory() []ChatMessage {
+	history, err := cv.LoadHistory(context.Background())
+	if err != nil {
+		return nil
+	}
+	return history
+}
+
+// ClearHistory 清空对话历史
+func (cv *Conversation) ClearHistory() {
+	_ = cv.client.config.HistoryStore.Delete(context.Background(), cv.id)
+}
+
+// buildMessages 将历史记录截断后与新消息合并，得到本次请求的完整上下文
+func (cv *Conversation) buildMessages(ctx context.Context, model string, newMessages []ChatMessage) ([]ChatMessage, error) {
+	history, err := cv.LoadHistory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history: %w", err)
+	}
+
+	c := cv.client
+	if c.config.ExcludeReasoningFromHistory {
+		history = withoutReasoning(history)
+	}
+	budget := c.config.MaxHistoryTokens - c.config.TokenOverhead.PerReply
+	for _, msg := range newMessages {
+		budget -= c.countMessageTokens(msg)
+	}
+	kept, err := c.config.HistoryPruner.Prune(ctx, PruneRequest{
+		History: history,
+		Budget:  budget,
+		Count:   c.countMessageTokens,
+		Client:  c,
+		Model:   model,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune history: %w", err)
+	}
+
+	finalMessages := make([]ChatMessage, 0, len(kept)+len(newMessages))
+	finalMessages = append(finalMessages, kept...)
+	finalMessages = append(finalMessages, newMessages...)
+	return finalMessages, nil
+}
+
+// appendHistory 将本轮的新消息和回复追加到历史记录
+func (cv *Conversation) appendHistory(ctx context.Context, newMessages []ChatMessage, reply ...ChatMessage) error {
+	messages := make([]ChatMessage, 0, len(newMessages)+len(reply))
+	messages = append(messages, newMessages...)
+	messages = append(messages, reply...)
+	if err := cv.client.config.HistoryStore.Append(ctx, cv.id, messages...); err != nil {
+		return fmt.Errorf("failed to save history: %w", err)
+	}
+	return nil
+}
+
+// withoutReasoning 返回去掉 ReasoningContent 的消息副本
+func withoutReasoning(messages []ChatMessage) []ChatMessage {
+	result := make([]ChatMessage, len(messages))
+	for i, msg := range messages {
+		msg.ReasoningContent = ""
+		result[i] = msg
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// echoHandler 将最后一条消息的内容原样作为回复，并回显请求中的消息条数
+func echoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("解析请求体失败: %v", err)
+			return
+		}
+		last := req.Messages[len(req.Messages)-1]
+		writeChatResponse(w, fmt.Sprintf("%s#%d", last.Content, len(req.Messages)))
+	})
+}
+
+func TestConversation_Isolation(t *testing.T) {
+	client := newTestClient(t, echoHandler(t))
+	ctx := context.Background()
+
+	alice := client.Conversation("alice")
+	bob := client.Conversation("bob")
+	if client.Conversation("alice") != alice {
+		t.Fatal("相同 ID 应返回同一个会话")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := alice.CreateChatCompletion(ctx, ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "a"}}}); err != nil {
+			t.Fatalf("alice 请求失败: %v", err)
+		}
+	}
+	resp, err := bob.CreateChatCompletion(ctx, ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "b"}}})
+	if err != nil {
+		t.Fatalf("bob 请求失败: %v", err)
+	}
+
+	// bob 的请求不应携带 alice 的历史
+	if got := resp.Choices[0].Message.Content; got != "b#1" {
+		t.Errorf("bob 的请求混入了其他会话的历史: %q", got)
+	}
+	if got := len(alice.GetHistory()); got != 4 {
+		t.Errorf("alice 期望 4 条历史记录，实际 %d", got)
+	}
+	if got := len(client.GetHistory()); got != 0 {
+		t.Errorf("默认会话不应受影响，实际 %d 条", got)
+	}
+
+	if err := client.DeleteConversation(ctx, "alice"); err != nil {
+		t.Fatalf("DeleteConversation 失败: %v", err)
+	}
+	if len(client.ConversationIDs()) != 1 || len(client.Conversation("alice").GetHistory()) != 0 {
+		t.Error("DeleteConversation 后应重新创建空会话")
+	}
+}
+
+func TestConversation_MaxConversations(t *testing.T) {
+	client := newTestClient(t, echoHandler(t))
+	client.config.MaxConversations = 2
+	ctx := context.Background()
+
+	alice := client.Conversation("alice")
+	bob := client.Conversation("bob")
+	if _, err := bob.CreateChatCompletion(ctx, ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "b"}}}); err != nil {
+		t.Fatalf("bob 请求失败: %v", err)
+	}
+	client.Conversation("alice") // alice 成为最近使用的会话
+	client.Conversation("carol")
+
+	// 超出上限时淘汰最久未使用的 bob
+	if ids := client.ConversationIDs(); len(ids) != 2 || ids[0] != "carol" || ids[1] != "alice" {
+		t.Errorf("应淘汰最久未使用的会话，实际 %q", ids)
+	}
+	if client.Conversation("alice") != alice {
+		t.Error("未被淘汰的会话应返回同一个对象")
+	}
+	// 淘汰只丢弃会话对象，重新获取时继续使用存储中的历史记录
+	again := client.Conversation("bob")
+	if again == bob || len(again.GetHistory()) != 2 {
+		t.Errorf("被淘汰的会话应重新创建并保留历史记录: %+v", again.GetHistory())
+	}
+}
+
+func TestConversation_Concurrent(t *testing.T) {
+	client := newTestClient(t, echoHandler(t))
+	ctx := context.Background()
+
+	const users, rounds = 8, 5
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			conv := client.Conversation(fmt.Sprintf("user-%d", u))
+			for i := 0; i < rounds; i++ {
+				req := ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: fmt.Sprint(u)}}}
+				if i%2 == 0 {
+					if _, err := conv.CreateChatCompletion(ctx, req); err != nil {
+						t.Errorf("同步请求失败: %v", err)
+						return
+					}
+					continue
+				}
+				// 流式请求由 goroutine 更新历史记录
+				ch, err := conv.CreateChatCompletionSSEStream(ctx, req)
+				if err != nil {
+					t.Errorf("流式请求失败: %v", err)
+					return
+				}
+				for range ch {
+				}
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	for u := 0; u < users; u++ {
+		history := client.Conversation(fmt.Sprintf("user-%d", u)).GetHistory()
+		if len(history) != rounds*2 {
+			t.Errorf("user-%d 期望 %d 条历史记录，实际 %d", u, rounds*2, len(history))
+		}
+		for _, msg := range history {
+			if msg.Role == RoleUser && msg.Content != fmt.Sprint(u) {
+				t.Errorf("user-%d 的历史中混入了其他用户的消息: %q", u, msg.Content)
+			}
+		}
+	}
+}
+
+func TestConversation_Reasoning(t *testing.T) {
+	var sent []map[string]any
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := decodeRequest(t, r)
+		sent = append(sent, payload)
+		if payload["reasoning_effort"] != ReasoningEffortHigh {
+			t.Errorf("应发送 reasoning_effort: %v", payload["reasoning_effort"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"reasoning_content\":\"先想\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"reasoning_content\":\"一想\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"答案\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	ctx := context.Background()
+	request := ChatRequest{Model: "r1", ReasoningEffort: ReasoningEffortHigh, Messages: []ChatMessage{{Role: RoleUser, Content: "问题"}}}
+
+	// 1. 思考过程与回复分开保存到历史记录
+	stream, err := client.CreateChatCompletionSSEStream(ctx, request)
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err := CollectStream(stream)
+	if err != nil || resp.Choices[0].Message.Content != "答案" || resp.Choices[0].Message.ReasoningContent != "先想一想" {
+		t.Fatalf("流式回复错误: %+v, %v", resp, err)
+	}
+	history := client.GetHistory()
+	if len(history) != 2 || history[1].Content != "答案" || history[1].ReasoningContent != "先想一想" {
+		t.Errorf("历史记录应分开保存思考过程: %+v", history)
+	}
+
+	// 2. 默认随历史记录发回思考过程，开启 ExcludeReasoningFromHistory 后不再发送
+	for _, exclude := range []bool{false, true} {
+		client.config.ExcludeReasoningFromHistory = exclude
+		stream, err := client.CreateChatCompletionSSEStream(ctx, request)
+		if err != nil {
+			t.Fatalf("流式请求失败: %v", err)
+		}
+		for range stream {
+		}
+		messages := sent[len(sent)-1]["messages"].([]any)
+		_, included := messages[1].(map[string]any)["reasoning_content"]
+		if included == exclude {
+			t.Errorf("ExcludeReasoningFromHistory=%v 时历史消息中的 reasoning_content 发送错误: %v", exclude, messages[1])
+		}
+	}
+	if history := client.GetHistory(); history[1].ReasoningContent == "" {
+		t.Error("排除思考过程不应修改历史记录")
+	}
+}
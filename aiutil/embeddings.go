@@ -0,0 +1,214 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// =================================================================================
+// 向量嵌入 (Embeddings)
+// =================================================================================
+
+// DefaultEmbeddingsEndpoint 是向量嵌入接口的默认端点
+const DefaultEmbeddingsEndpoint = "/embeddings"
+
+// DefaultEmbeddingBatchSize 是每次请求最多携带的输入条数 (OpenAI 的上限为 2048)
+const DefaultEmbeddingBatchSize = 2048
+
+// 向量的传输编码
+const (
+	EmbeddingEncodingFloat  = "float"  // JSON 数组
+	EmbeddingEncodingBase64 = "base64" // 小端 float32 的 base64 编码，体积约为 JSON 数组的 1/4
+)
+
+// EmbeddingRequest 是向量嵌入请求
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+	// EncodingFormat 是服务端返回向量的编码，可选 EmbeddingEncodingFloat 或 EmbeddingEncodingBase64。
+	// 两种编码都会被解码为 []float32，选择 base64 可以显著减少传输的数据量。
+	EncodingFormat string `json:"encoding_format,omitempty"`
+	// Dimensions 是输出向量的维度，只有部分模型支持 (例如 text-embedding-3)
+	Dimensions int    `json:"dimensions,omitempty"`
+	User       string `json:"user,omitempty"`
+
+	// CustomParams 用于存放任何非官方、模型特定的参数
+	CustomParams map[string]any `json:"-"`
+	// RequestEndpoint 允许覆盖默认的 DefaultEmbeddingsEndpoint
+	RequestEndpoint string `json:"-"`
+	// BatchSize 是每次请求最多携带的输入条数，为 0 时使用 DefaultEmbeddingBatchSize
+	BatchSize int `json:"-"`
+	// MaxBatchTokens 是每次请求的输入最多占用的 Token 数 (使用客户端的 Tokenizer 计算)，为 0 时不限制
+	MaxBatchTokens int `json:"-"`
+}
+
+// Embedding 是一条输入对应的向量
+type Embedding struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"` // 对应输入在 EmbeddingRequest.Input 中的下标
+	Embedding []float32 `json:"embedding"`
+}
+
+// UnmarshalJSON 同时支持 JSON 数组和 base64 编码的向量
+func (e *Embedding) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Object    string          `json:"object"`
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	e.Object, e.Index = raw.Object, raw.Index
+
+	if len(raw.Embedding) > 0 && raw.Embedding[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(raw.Embedding, &encoded); err != nil {
+			return err
+		}
+		vector, err := decodeBase64Embedding(encoded)
+		if err != nil {
+			return err
+		}
+		e.Embedding = vector
+		return nil
+	}
+	return json.Unmarshal(raw.Embedding, &e.Embedding)
+}
+
+// decodeBase64Embedding 解码 base64 编码的小端 float32 数组
+func decodeBase64Embedding(encoded string) ([]float32, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 embedding: %w", err)
+	}
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("invalid base64 embedding: length %d is not a multiple of 4", len(b))
+	}
+	vector := make([]float32, len(b)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return vector, nil
+}
+
+// EmbeddingResponse 是向量嵌入的结果。分批请求时 Data 按输入顺序合并，Usage 为各批次之和。
+type EmbeddingResponse struct {
+	Object string      `json:"object"`
+	Data   []Embedding `json:"data"`
+	Model  string      `json:"model"`
+	Usage  Usage       `json:"usage"`
+}
+
+// Vectors 按输入顺序返回所有向量
+func (r *EmbeddingResponse) Vectors() [][]float32 {
+	vectors := make([][]float32, len(r.Data))
+	for i, e := range r.Data {
+		vectors[i] = e.Embedding
+	}
+	return vectors
+}
+
+// CreateEmbeddings 计算一组文本的向量。输入超过 BatchSize 或 MaxBatchTokens 时自动拆分为多次请求，
+// 每次请求都使用客户端的配置、请求头和重试策略，任一批次失败时返回错误。
+//
+// 使用示例
+//
+//	resp, err := client.CreateEmbeddings(ctx, aiutil.EmbeddingRequest{
+//		Model:          "text-embedding-3-small",
+//		Input:          documents,
+//		EncodingFormat: aiutil.EmbeddingEncodingBase64,
+//	})
+//	vectors := resp.Vectors()
+func (c *Client) CreateEmbeddings(ctx context.Context, request EmbeddingRequest) (*EmbeddingResponse, error) {
+	if len(request.Input) == 0 {
+		return nil, fmt.Errorf("embedding input is empty")
+	}
+
+	result := &EmbeddingResponse{Object: "list", Data: make([]Embedding, 0, len(request.Input))}
+	for _, batch := range c.embeddingBatches(request) {
+		batchReq := request
+		batchReq.Input = request.Input[batch[0]:batch[1]]
+
+		resp, err := c.doEmbeddings(ctx, batchReq)
+		if err != nil {
+			return nil, fmt.Errorf("embedding batch [%d, %d) failed: %w", batch[0], batch[1], err)
+		}
+		if len(resp.Data) != len(batchReq.Input) {
+			return nil, fmt.Errorf("embedding batch [%d, %d) returned %d vectors", batch[0], batch[1], len(resp.Data))
+		}
+		for _, e := range resp.Data {
+			e.Index += batch[0]
+			result.Data = append(result.Data, e)
+		}
+		result.Model = resp.Model
+		result.Usage.PromptTokens += resp.Usage.PromptTokens
+		result.Usage.CompletionTokens += resp.Usage.CompletionTokens
+		result.Usage.TotalTokens += resp.Usage.TotalTokens
+	}
+
+	sort.SliceStable(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+	return result, nil
+}
+
+// embeddingBatches 按条数和 Token 数把输入拆分为若干个 [start, end) 区间。
+// 单条输入超过 MaxBatchTokens 时单独成为一批，由服务端决定是否接受。
+func (c *Client) embeddingBatches(request EmbeddingRequest) [][2]int {
+	size := request.BatchSize
+	if size <= 0 {
+		size = DefaultEmbeddingBatchSize
+	}
+
+	var batches [][2]int
+	start, tokens := 0, 0
+	for i, input := range request.Input {
+		n := 0
+		if request.MaxBatchTokens > 0 {
+			n = c.tokenizer.CountTokens(input)
+		}
+		full := i-start >= size || (request.MaxBatchTokens > 0 && tokens+n > request.MaxBatchTokens)
+		if full && i > start {
+			batches = append(batches, [2]int{start, i})
+			start, tokens = i, 0
+		}
+		tokens += n
+	}
+	return append(batches, [2]int{start, len(request.Input)})
+}
+
+// doEmbeddings 发送一次向量嵌入请求
+func (c *Client) doEmbeddings(ctx context.Context, request EmbeddingRequest) (*EmbeddingResponse, error) {
+	// 1. 构建并发送请求
+	endpoint := DefaultEmbeddingsEndpoint
+	if request.RequestEndpoint != "" {
+		endpoint = request.RequestEndpoint
+	}
+	resp, err := c.sendRequest(ctx, request.Model, "", func(model string) (string, []byte, error) {
+		encoded := request
+		encoded.Model = model
+		payload, err := marshalWithParams(encoded, request.CustomParams)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to build payload: %w", err)
+		}
+		return endpoint, payload, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 2. 解析响应
+	var result EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	// 3. 记录用量
+	c.recordUsage(ctx, "embeddings", request.Model, result.Usage)
+	return &result, nil
+}
@@ -0,0 +1,102 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// embeddingHandler 为每条输入返回向量 [len(input), index]，按 encoding_format 选择编码
+func embeddingHandler(t *testing.T, calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("期望请求 /embeddings，实际 %s", r.URL.Path)
+		}
+		calls.Add(1)
+		var req EmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("解析请求体失败: %v", err)
+		}
+
+		data := make([]map[string]any, len(req.Input))
+		for i, input := range req.Input {
+			vector := []float32{float32(len(input)), float32(i)}
+			var embedding any = vector
+			if req.EncodingFormat == EmbeddingEncodingBase64 {
+				b := make([]byte, 0, len(vector)*4)
+				for _, f := range vector {
+					b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+				}
+				embedding = base64.StdEncoding.EncodeToString(b)
+			}
+			data[i] = map[string]any{"object": "embedding", "index": i, "embedding": embedding}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"data":   data,
+			"model":  req.Model,
+			"usage":  map[string]int{"prompt_tokens": len(req.Input), "total_tokens": len(req.Input)},
+		})
+	})
+}
+
+func TestCreateEmbeddings_Batching(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, embeddingHandler(t, &calls))
+
+	inputs := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	for _, format := range []string{EmbeddingEncodingFloat, EmbeddingEncodingBase64} {
+		calls.Store(0)
+		resp, err := client.CreateEmbeddings(context.Background(), EmbeddingRequest{
+			Model:          "test-embedding",
+			Input:          inputs,
+			EncodingFormat: format,
+			BatchSize:      2,
+		})
+		if err != nil {
+			t.Fatalf("[%s] CreateEmbeddings 失败: %v", format, err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("[%s] 5 条输入按每批 2 条应请求 3 次，实际 %d", format, calls.Load())
+		}
+		if resp.Usage.TotalTokens != 5 {
+			t.Errorf("[%s] 用量应为各批次之和 5，实际 %d", format, resp.Usage.TotalTokens)
+		}
+		for i, vector := range resp.Vectors() {
+			if resp.Data[i].Index != i || vector[0] != float32(len(inputs[i])) {
+				t.Errorf("[%s] 第 %d 条向量与输入不对应: index=%d vector=%v", format, i, resp.Data[i].Index, vector)
+			}
+		}
+	}
+}
+
+func TestEmbeddingBatches_MaxTokens(t *testing.T) {
+	client := NewClient(DefaultConfig("test-key"))
+	// EstimateTokenizer: 每个汉字 1 个 Token
+	batches := client.embeddingBatches(EmbeddingRequest{
+		Input:          []string{"一二", "三四", "五六七八九", "十"},
+		MaxBatchTokens: 4,
+	})
+	want := [][2]int{{0, 2}, {2, 3}, {3, 4}}
+	if len(batches) != len(want) {
+		t.Fatalf("期望 %v，实际 %v", want, batches)
+	}
+	for i := range want {
+		if batches[i] != want[i] {
+			t.Errorf("期望 %v，实际 %v", want, batches)
+		}
+	}
+}
+
+func TestCreateEmbeddings_EmptyInput(t *testing.T) {
+	client := NewClient(DefaultConfig("test-key"))
+	if _, err := client.CreateEmbeddings(context.Background(), EmbeddingRequest{Model: "m"}); err == nil {
+		t.Error("空输入应返回错误")
+	}
+}
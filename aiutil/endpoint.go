@@ -0,0 +1,225 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// =================================================================================
+// 多端点负载均衡与故障转移 (Endpoint)
+// =================================================================================
+
+// Endpoint 是一个兼容同一 Provider 的服务端点，例如同一模型的多个网关或多个区域的部署
+type Endpoint struct {
+	// Name 用于错误信息和 EndpointStatus，为空时使用 BaseURL
+	Name    string
+	BaseURL string
+	// Headers 与 Config.DefaultHeaders 合并，同名时覆盖，例如每个端点各自的 Authorization
+	Headers map[string]string
+	// Models 将请求中的模型名映射为该端点上的模型名 (例如 Azure 的部署名)，未列出的模型名保持不变
+	Models map[string]string
+	// Weight 是加权轮询的权重，<=0 时视为 1
+	Weight int
+}
+
+// CircuitBreaker 是端点的熔断策略: 连续失败 FailureThreshold 次后熔断，
+// 熔断期间该端点排在所有健康端点之后，Cooldown 之后重新参与负载均衡，成功一次即恢复。
+type CircuitBreaker struct {
+	// FailureThreshold 是触发熔断的连续失败次数，<=0 时使用 5
+	FailureThreshold int
+	// Cooldown 是熔断的持续时间，<=0 时使用 30s
+	Cooldown time.Duration
+}
+
+// EndpointStatus 是端点的健康状态
+type EndpointStatus struct {
+	Name                string
+	Healthy             bool      // 未熔断
+	ConsecutiveFailures int       // 连续失败次数
+	OpenUntil           time.Time // 熔断结束的时间，未熔断时为零值
+}
+
+// endpointState 是端点的运行时状态，由 endpointPool 的锁保护
+type endpointState struct {
+	Endpoint
+	weight        int
+	currentWeight int // 平滑加权轮询的当前权重
+	failures      int
+	openUntil     time.Time
+}
+
+func (e *endpointState) name() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.BaseURL
+}
+
+// model 返回该端点上的模型名
+func (e *endpointState) model(model string) string {
+	if mapped, ok := e.Models[model]; ok {
+		return mapped
+	}
+	return model
+}
+
+// endpointPool 按平滑加权轮询选择端点，并记录各端点的健康状态
+type endpointPool struct {
+	breaker CircuitBreaker
+
+	mu        sync.Mutex
+	endpoints []*endpointState
+}
+
+func newEndpointPool(endpoints []Endpoint, breaker CircuitBreaker) *endpointPool {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	if breaker.FailureThreshold <= 0 {
+		breaker.FailureThreshold = 5
+	}
+	if breaker.Cooldown <= 0 {
+		breaker.Cooldown = 30 * time.Second
+	}
+	p := &endpointPool{breaker: breaker}
+	for _, ep := range endpoints {
+		p.endpoints = append(p.endpoints, &endpointState{Endpoint: ep, weight: max(ep.Weight, 1)})
+	}
+	return p
+}
+
+// order 返回本次请求尝试端点的顺序: 按加权轮询选出的端点，其余健康的端点，最后是熔断中的端点
+func (p *endpointPool) order() []*endpointState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+
+	var healthy, open []*endpointState
+	for _, ep := range p.endpoints {
+		if now.Before(ep.openUntil) {
+			open = append(open, ep)
+		} else {
+			healthy = append(healthy, ep)
+		}
+	}
+	if len(healthy) == 0 {
+		return open
+	}
+
+	// 平滑加权轮询 (与 nginx 相同): 每个端点的当前权重加上其权重，选出最大的，再减去总权重
+	var total int
+	var best *endpointState
+	for _, ep := range healthy {
+		ep.currentWeight += ep.weight
+		total += ep.weight
+		if best == nil || ep.currentWeight > best.currentWeight {
+			best = ep
+		}
+	}
+	best.currentWeight -= total
+
+	result := []*endpointState{best}
+	for _, ep := range healthy {
+		if ep != best {
+			result = append(result, ep)
+		}
+	}
+	return append(result, open...)
+}
+
+// success 记录端点的一次成功，关闭熔断
+func (p *endpointPool) success(ep *endpointState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ep.failures = 0
+	ep.openUntil = time.Time{}
+}
+
+// failure 记录端点的一次失败，连续失败达到阈值时熔断
+func (p *endpointPool) failure(ep *endpointState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ep.failures++
+	if ep.failures >= p.breaker.FailureThreshold {
+		ep.openUntil = time.Now().Add(p.breaker.Cooldown)
+	}
+}
+
+// EndpointStatus 返回 Config.Endpoints 中各端点的健康状态，未配置多端点时返回 nil
+func (c *Client) EndpointStatus() []EndpointStatus {
+	if c.endpoints == nil {
+		return nil
+	}
+	c.endpoints.mu.Lock()
+	defer c.endpoints.mu.Unlock()
+	now := time.Now()
+	statuses := make([]EndpointStatus, 0, len(c.endpoints.endpoints))
+	for _, ep := range c.endpoints.endpoints {
+		status := EndpointStatus{Name: ep.name(), Healthy: !now.Before(ep.openUntil), ConsecutiveFailures: ep.failures}
+		if !status.Healthy {
+			status.OpenUntil = ep.openUntil
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// sendRequest 构建并发送请求。encode 以目标端点上的模型名返回 API 端点和请求体，
+// contentType 不为空时代替默认请求头中的 Content-Type (例如 multipart 请求)。
+//
+// 配置了 Config.Endpoints 时按负载均衡的顺序尝试各个端点 (每个端点内部仍按 Config.Retry 重试)，
+// 连接失败或返回 5xx 时切换到下一个端点；流式请求只在收到响应头之前切换，
+// 已经开始接收的流不会切换端点。其余错误 (例如 4xx) 直接返回。
+func (c *Client) sendRequest(ctx context.Context, model, contentType string, encode func(model string) (string, []byte, error)) (*http.Response, error) {
+	newRequest := func(baseURL string, headers map[string]string, model string) (*http.Request, error) {
+		endpoint, payload, err := encode(model)
+		if err != nil {
+			return nil, err
+		}
+		req, err := c.newJSONRequest(ctx, baseURL, headers, endpoint, payload)
+		if err == nil && contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return req, err
+	}
+
+	if c.endpoints == nil {
+		req, err := newRequest(c.config.BaseURL, nil, model)
+		if err != nil {
+			return nil, err
+		}
+		return c.send(req)
+	}
+
+	var errs []error
+	for _, ep := range c.endpoints.order() {
+		req, err := newRequest(ep.BaseURL, ep.Headers, ep.model(model))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.send(req)
+		if err == nil {
+			c.endpoints.success(ep)
+			return resp, nil
+		}
+		if ctx.Err() != nil || !shouldFailover(err) {
+			return nil, err
+		}
+		c.endpoints.failure(ep)
+		errs = append(errs, fmt.Errorf("endpoint %s: %w", ep.name(), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// shouldFailover 判断请求失败后是否应切换到下一个端点: 连接失败和 5xx 是端点的问题，4xx 是请求的问题
+func shouldFailover(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
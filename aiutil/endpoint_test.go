@@ -0,0 +1,183 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newEndpointServer 启动一个记录请求次数的测试服务器
+func newEndpointServer(t *testing.T, calls *atomic.Int32, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newEndpointClient(endpoints []Endpoint, breaker CircuitBreaker) *Client {
+	config := DefaultConfig("default-key")
+	config.BaseURL = ""
+	config.Endpoints = endpoints
+	config.CircuitBreaker = breaker
+	return NewClient(config)
+}
+
+func TestEndpoints_WeightedRoundRobin(t *testing.T) {
+	var a, b atomic.Int32
+	ok := func(w http.ResponseWriter, r *http.Request) { writeChatResponse(w, "ok") }
+	client := newEndpointClient([]Endpoint{
+		{Name: "a", BaseURL: newEndpointServer(t, &a, ok), Weight: 2},
+		{Name: "b", BaseURL: newEndpointServer(t, &b, ok)},
+	}, CircuitBreaker{})
+
+	for i := 0; i < 30; i++ {
+		if _, err := client.doChatCompletion(context.Background(), ChatRequest{Model: "gpt"}); err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+	}
+	if a.Load() != 20 || b.Load() != 10 {
+		t.Errorf("请求应按权重 2:1 分配，实际 a=%d b=%d", a.Load(), b.Load())
+	}
+}
+
+func TestEndpoints_Failover(t *testing.T) {
+	var bad, good atomic.Int32
+	var gotModel, gotAuth string
+	badURL := newEndpointServer(t, &bad, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusBadGateway)
+	})
+	goodURL := newEndpointServer(t, &good, func(w http.ResponseWriter, r *http.Request) {
+		gotModel, _ = decodeRequest(t, r)["model"].(string)
+		gotAuth = r.Header.Get("Authorization")
+		if gotModel == "reject" {
+			http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+			return
+		}
+		writeSSEChunks(w, "你好")
+	})
+	client := newEndpointClient([]Endpoint{
+		{Name: "bad", BaseURL: badURL, Weight: 10},
+		{Name: "good", BaseURL: goodURL, Headers: map[string]string{"Authorization": "Bearer good-key"}, Models: map[string]string{"gpt": "gpt-deployment"}},
+	}, CircuitBreaker{FailureThreshold: 2, Cooldown: time.Hour})
+	ctx := context.Background()
+
+	// 1. 流式请求在收到响应头之前切换到健康的端点，并使用该端点的模型名和请求头
+	stream, err := client.doSSEStream(ctx, ChatRequest{Model: "gpt"}, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("应切换到健康的端点: %v", err)
+	}
+	if resp, err := CollectStream(stream); err != nil || resp.Choices[0].Message.Content != "你好" {
+		t.Errorf("流式回复错误: %+v, %v", resp, err)
+	}
+	if gotModel != "gpt-deployment" || gotAuth != "Bearer good-key" {
+		t.Errorf("应使用端点的模型名和请求头: model=%q auth=%q", gotModel, gotAuth)
+	}
+
+	// 2. 连续失败达到阈值后熔断，之后的请求不再先尝试该端点
+	_, _ = client.doSSEStream(ctx, ChatRequest{Model: "gpt"}, func(ChatMessage) error { return nil })
+	status := client.EndpointStatus()
+	if status[0].Healthy || status[0].ConsecutiveFailures != 2 || status[0].OpenUntil.IsZero() || !status[1].Healthy {
+		t.Fatalf("端点状态错误: %+v", status)
+	}
+	bad.Store(0)
+	for i := 0; i < 5; i++ {
+		stream, err := client.doSSEStream(ctx, ChatRequest{Model: "gpt"}, func(ChatMessage) error { return nil })
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		_, _ = CollectStream(stream)
+	}
+	if bad.Load() != 0 {
+		t.Errorf("熔断的端点不应再被优先尝试，实际请求 %d 次", bad.Load())
+	}
+
+	// 3. 4xx 是请求本身的问题，不切换端点
+	good.Store(0)
+	if _, err := client.doChatCompletion(ctx, ChatRequest{Model: "reject"}); err == nil || good.Load() != 1 || bad.Load() != 0 {
+		t.Errorf("4xx 不应切换端点: err=%v good=%d bad=%d", err, good.Load(), bad.Load())
+	}
+}
+
+func TestEndpoints_AllFailed(t *testing.T) {
+	var calls atomic.Int32
+	down := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"down"}}`, http.StatusServiceUnavailable)
+	}
+	client := newEndpointClient([]Endpoint{
+		{Name: "a", BaseURL: newEndpointServer(t, &calls, down)},
+		{Name: "b", BaseURL: newEndpointServer(t, &calls, down)},
+	}, CircuitBreaker{})
+
+	_, err := client.doChatCompletion(context.Background(), ChatRequest{Model: "gpt"})
+	if !errors.Is(err, ErrServer) || !strings.Contains(err.Error(), "endpoint a") || !strings.Contains(err.Error(), "endpoint b") {
+		t.Errorf("所有端点都失败时应返回每个端点的错误: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("每个端点应尝试一次，实际 %d 次", calls.Load())
+	}
+}
+
+// fakeWebSocketConn 记录发送的消息，并依次返回预设的消息
+type fakeWebSocketConn struct {
+	sent     [][]byte
+	messages []string
+}
+
+func (c *fakeWebSocketConn) Send(message []byte) error {
+	c.sent = append(c.sent, message)
+	return nil
+}
+
+func (c *fakeWebSocketConn) Receive() ([]byte, error) {
+	if len(c.messages) == 0 {
+		return nil, io.EOF
+	}
+	message := c.messages[0]
+	c.messages = c.messages[1:]
+	return []byte(message), nil
+}
+
+func (c *fakeWebSocketConn) Close() error { return nil }
+
+func TestEndpoints_WebSocketFailover(t *testing.T) {
+	conn := &fakeWebSocketConn{messages: []string{`{"choices":[{"delta":{"content":"ws"}}]}`}}
+	var dialed []string
+	config := DefaultConfig("key")
+	config.Endpoints = []Endpoint{
+		{Name: "down", BaseURL: "http://down.example"},
+		{Name: "up", BaseURL: "https://up.example/v1", Models: map[string]string{"gpt": "gpt-up"}},
+	}
+	config.WebSocketDialer = func(ctx context.Context, url, origin string, header http.Header) (WebSocketConn, error) {
+		dialed = append(dialed, url)
+		if strings.Contains(url, "down") {
+			return nil, errors.New("connection refused")
+		}
+		return conn, nil
+	}
+	client := NewClient(config)
+
+	stream, err := client.doWebSocketStream(context.Background(), ChatRequest{Model: "gpt"}, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("应切换到可连接的端点: %v", err)
+	}
+	if resp, err := CollectStream(stream); err != nil || resp.Choices[0].Message.Content != "ws" {
+		t.Errorf("WebSocket 回复错误: %+v, %v", resp, err)
+	}
+	if len(dialed) != 2 || dialed[1] != "wss://up.example/v1/chat/completions" {
+		t.Errorf("连接的地址错误: %v", dialed)
+	}
+	var payload map[string]any
+	if len(conn.sent) != 1 || json.Unmarshal(conn.sent[0], &payload) != nil || payload["model"] != "gpt-up" {
+		t.Errorf("应使用端点的模型名: %s", conn.sent)
+	}
+}
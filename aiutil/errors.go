@@ -0,0 +1,199 @@
+package aiutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// =================================================================================
+// API 错误 (APIError)
+// =================================================================================
+
+// 可与 errors.Is 一起使用的错误分类，例如 errors.Is(err, aiutil.ErrRateLimited)
+var (
+	ErrRateLimited           = errors.New("aiutil: rate limited")
+	ErrQuotaExceeded         = errors.New("aiutil: quota exceeded")
+	ErrAuthentication        = errors.New("aiutil: authentication failed")
+	ErrContextLengthExceeded = errors.New("aiutil: context length exceeded")
+	ErrContentFiltered       = errors.New("aiutil: content filtered")
+	ErrServer                = errors.New("aiutil: server error")
+)
+
+// APIError 是服务端返回的错误，解析自 OpenAI 风格的错误响应:
+//
+//	{"error": {"message": "...", "type": "...", "param": "...", "code": "..."}}
+//
+// 也兼容 Anthropic、Gemini 以及 {"message": "...", "code": ...} 等常见变体。
+// 使用 errors.As 取出，或使用 errors.Is 与 ErrRateLimited 等分类比较。
+type APIError struct {
+	StatusCode int           // HTTP 状态码，流式响应中途返回的错误为 0
+	Status     string        // HTTP 状态行，例如 "429 Too Many Requests"
+	Code       string        // 服务商的错误码，例如 "rate_limit_exceeded"、"context_length_exceeded"
+	Type       string        // 服务商的错误类型，例如 "invalid_request_error"、"insufficient_quota"
+	Message    string        // 可读的错误信息，无法解析时为原始响应体
+	Param      string        // 导致错误的参数名
+	RequestID  string        // 服务端请求 ID，用于向服务商反馈问题
+	RetryAfter time.Duration // 服务端通过 Retry-After 等响应头要求的等待时间
+	Body       []byte        // 原始响应体
+}
+
+// Error 实现 error 接口
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("api error: ")
+	if e.Status != "" {
+		sb.WriteString("status=" + e.Status + ", ")
+	}
+	if e.Code != "" {
+		sb.WriteString("code=" + e.Code + ", ")
+	}
+	if e.Type != "" {
+		sb.WriteString("type=" + e.Type + ", ")
+	}
+	sb.WriteString("message=" + e.Message)
+	if e.RequestID != "" {
+		sb.WriteString(", request_id=" + e.RequestID)
+	}
+	return sb.String()
+}
+
+// Is 支持使用 errors.Is 判断错误分类
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests && !e.isQuotaExceeded()
+	case ErrQuotaExceeded:
+		return e.isQuotaExceeded()
+	case ErrAuthentication:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrContextLengthExceeded:
+		return e.matches([]string{"context_length_exceeded", "string_above_max_length"},
+			"maximum context length", "context length", "context window", "prompt is too long", "too many tokens")
+	case ErrContentFiltered:
+		return e.matches([]string{"content_filter", "content_policy_violation"},
+			"content management policy", "content filter")
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+func (e *APIError) isQuotaExceeded() bool {
+	return e.Code == "insufficient_quota" || e.Type == "insufficient_quota"
+}
+
+// matches 判断错误码/类型是否属于 codes，或错误信息中包含 phrases 之一 (不区分大小写)
+func (e *APIError) matches(codes []string, phrases ...string) bool {
+	for _, code := range codes {
+		if e.Code == code || e.Type == code {
+			return true
+		}
+	}
+	msg := strings.ToLower(e.Message)
+	for _, phrase := range phrases {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRateLimited 判断错误是否为触发速率限制 (429，额度耗尽除外)
+func IsRateLimited(err error) bool { return errors.Is(err, ErrRateLimited) }
+
+// IsQuotaExceeded 判断错误是否为账户额度耗尽，这类错误重试无效
+func IsQuotaExceeded(err error) bool { return errors.Is(err, ErrQuotaExceeded) }
+
+// IsAuthError 判断错误是否为认证或权限失败 (401/403)
+func IsAuthError(err error) bool { return errors.Is(err, ErrAuthentication) }
+
+// IsContextLengthExceeded 判断错误是否为上下文超出模型的最大长度
+func IsContextLengthExceeded(err error) bool { return errors.Is(err, ErrContextLengthExceeded) }
+
+// IsContentFiltered 判断错误是否为请求内容被安全策略拦截
+func IsContentFiltered(err error) bool { return errors.Is(err, ErrContentFiltered) }
+
+// IsServerError 判断错误是否为服务端错误 (5xx)
+func IsServerError(err error) bool { return errors.Is(err, ErrServer) }
+
+// newAPIError 根据失败的 HTTP 响应构造 APIError
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := parseAPIErrorBody(body)
+	apiErr.StatusCode = resp.StatusCode
+	apiErr.Status = resp.Status
+	apiErr.RetryAfter = retryAfter(resp.Header)
+	for _, key := range []string{"x-request-id", "request-id", "x-goog-request-id"} {
+		if id := resp.Header.Get(key); id != "" {
+			apiErr.RequestID = id
+			break
+		}
+	}
+	return apiErr
+}
+
+// parseAPIErrorBody 解析错误响应体，无法识别时以原始内容作为错误信息
+func parseAPIErrorBody(body []byte) *APIError {
+	apiErr := &APIError{Body: body}
+
+	var envelope struct {
+		Error     json.RawMessage `json:"error"`
+		Message   string          `json:"message"`
+		Code      json.RawMessage `json:"code"`
+		Type      string          `json:"type"`
+		RequestID string          `json:"request_id"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+	apiErr.RequestID = envelope.RequestID
+
+	var detail struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Param   string          `json:"param"`
+		Code    json.RawMessage `json:"code"`
+		Status  string          `json:"status"` // Gemini: "RESOURCE_EXHAUSTED"
+	}
+	switch {
+	case len(envelope.Error) > 0 && envelope.Error[0] == '{':
+		_ = json.Unmarshal(envelope.Error, &detail)
+	case len(envelope.Error) > 0 && envelope.Error[0] == '"':
+		_ = json.Unmarshal(envelope.Error, &detail.Message)
+	default:
+		detail.Message, detail.Type, detail.Code = envelope.Message, envelope.Type, envelope.Code
+	}
+
+	apiErr.Message = detail.Message
+	apiErr.Type = detail.Type
+	if apiErr.Type == "" {
+		apiErr.Type = detail.Status
+	}
+	apiErr.Param = detail.Param
+	apiErr.Code = rawCodeString(detail.Code)
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// rawCodeString 将字符串或数字形式的错误码统一转为字符串
+func rawCodeString(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String()
+	}
+	return string(raw)
+}
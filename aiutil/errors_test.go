@@ -0,0 +1,82 @@
+package aiutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAPIError_FromResponse(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-request-id", "req_123")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"message":"This model's maximum context length is 8192 tokens.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`))
+	}))
+
+	err := chatOnce(client)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("期望返回 *APIError，实际 %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "context_length_exceeded" ||
+		apiErr.Type != "invalid_request_error" || apiErr.Param != "messages" || apiErr.RequestID != "req_123" {
+		t.Errorf("解析结果不正确: %+v", apiErr)
+	}
+	if !IsContextLengthExceeded(err) || IsRateLimited(err) || IsServerError(err) {
+		t.Errorf("错误分类不正确: %v", err)
+	}
+}
+
+func TestAPIError_Classification(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		check  func(error) bool
+	}{
+		{"rate limit", 429, `{"error":{"message":"Rate limit reached","code":"rate_limit_exceeded"}}`, IsRateLimited},
+		{"quota", 429, `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota"}}`, IsQuotaExceeded},
+		{"auth", 401, `{"error":{"message":"Incorrect API key provided"}}`, IsAuthError},
+		{"content filter", 400, `{"error":{"message":"filtered","code":"content_filter"}}`, IsContentFiltered},
+		{"server", 502, `bad gateway`, IsServerError},
+		{"anthropic", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, IsContextLengthExceeded},
+		{"gemini", 429, `{"error":{"code":429,"message":"Resource has been exhausted","status":"RESOURCE_EXHAUSTED"}}`, IsRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status), Header: http.Header{}}
+			err := fmt.Errorf("wrapped: %w", newAPIError(resp, []byte(tt.body)))
+			if !tt.check(err) {
+				t.Errorf("分类失败: %v", err)
+			}
+		})
+	}
+
+	if IsQuotaExceeded(newAPIError(&http.Response{StatusCode: 429, Header: http.Header{}}, []byte(`{"error":{"message":"slow down"}}`))) {
+		t.Error("普通的 429 不应被视为额度耗尽")
+	}
+	if IsRateLimited(errors.New("rate limited")) {
+		t.Error("非 APIError 不应被分类")
+	}
+}
+
+func TestParseAPIErrorBody_Variants(t *testing.T) {
+	tests := []struct {
+		body        string
+		wantMessage string
+		wantCode    string
+	}{
+		{`{"error":{"message":"bad","code":1301}}`, "bad", "1301"},
+		{`{"error":"plain error"}`, "plain error", ""},
+		{`{"message":"top level","code":"E1"}`, "top level", "E1"},
+		{`not json`, "not json", ""},
+	}
+	for _, tt := range tests {
+		apiErr := parseAPIErrorBody([]byte(tt.body))
+		if apiErr.Message != tt.wantMessage || apiErr.Code != tt.wantCode {
+			t.Errorf("解析 %s 得到 message=%q code=%q", tt.body, apiErr.Message, apiErr.Code)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package aiutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Bronya0/go-utils/fileutil"
+)
+
+// =================================================================================
+// 对话历史存储 (HistoryStore)
+// =================================================================================
+
+// HistoryStore 负责持久化各个会话的历史记录。实现必须是并发安全的。
+type HistoryStore interface {
+	// Load 按时间顺序返回会话的全部历史记录，会话不存在时返回空切片
+	Load(ctx context.Context, conversationID string) ([]ChatMessage, error)
+	// Append 将消息追加到会话历史的末尾
+	Append(ctx context.Context, conversationID string, messages ...ChatMessage) error
+	// Truncate 只保留会话最近的 keepLast 条消息
+	Truncate(ctx context.Context, conversationID string, keepLast int) error
+	// Delete 删除会话的全部历史记录，会话不存在时不返回错误
+	Delete(ctx context.Context, conversationID string) error
+}
+
+// MemoryHistoryStore 将历史记录保存在内存中，进程重启后丢失。它是 Client 的默认存储。
+type MemoryHistoryStore struct {
+	mu      sync.RWMutex
+	history map[string][]ChatMessage
+}
+
+// NewMemoryHistoryStore 创建一个内存历史存储
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{history: make(map[string][]ChatMessage)}
+}
+
+// Load 返回会话历史的副本
+func (s *MemoryHistoryStore) Load(ctx context.Context, conversationID string) ([]ChatMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.history[conversationID]
+	result := make([]ChatMessage, len(history))
+	copy(result, history)
+	return result, nil
+}
+
+// Append 将消息追加到会话历史的末尾
+func (s *MemoryHistoryStore) Append(ctx context.Context, conversationID string, messages ...ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[conversationID] = append(s.history[conversationID], messages...)
+	return nil
+}
+
+// Truncate 只保留会话最近的 keepLast 条消息
+func (s *MemoryHistoryStore) Truncate(ctx context.Context, conversationID string, keepLast int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.history[conversationID]
+	if keepLast < 0 {
+		keepLast = 0
+	}
+	if len(history) > keepLast {
+		// 复制到新切片，释放被截掉部分的内存
+		s.history[conversationID] = append([]ChatMessage(nil), history[len(history)-keepLast:]...)
+	}
+	return nil
+}
+
+// Delete 删除会话的全部历史记录
+func (s *MemoryHistoryStore) Delete(ctx context.Context, conversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.history, conversationID)
+	return nil
+}
+
+// FileHistoryStore 将每个会话的历史记录保存为目录下的一个 JSONL 文件 (每行一条消息)，
+// 进程重启后可以继续对话，也便于离线查看和分析。
+//
+// 文件名是对会话 ID 做 URL 转义后加上 ".jsonl" 后缀，例如会话 "user/42" 对应 "user%2F42.jsonl"。
+type FileHistoryStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileHistoryStore 创建一个基于文件的历史存储，目录不存在时自动创建
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	if err := fileutil.EnsureDir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &FileHistoryStore{dir: dir}, nil
+}
+
+// Path 返回会话历史文件的路径
+func (s *FileHistoryStore) Path(conversationID string) string {
+	return filepath.Join(s.dir, url.QueryEscape(conversationID)+".jsonl")
+}
+
+// Load 读取会话历史文件
+func (s *FileHistoryStore) Load(ctx context.Context, conversationID string) ([]ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(conversationID)
+}
+
+// Append 将消息以 JSONL 格式追加到会话历史文件的末尾
+func (s *FileHistoryStore) Append(ctx context.Context, conversationID string, messages ...ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// 先在内存中完成编码，保证一次写入要么全部成功要么不写
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return fmt.Errorf("failed to encode history message: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path(conversationID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	return f.Close()
+}
+
+// Truncate 只保留会话最近的 keepLast 条消息，通过临时文件原子替换原文件
+func (s *FileHistoryStore) Truncate(ctx context.Context, conversationID string, keepLast int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.load(conversationID)
+	if err != nil {
+		return err
+	}
+	if keepLast < 0 {
+		keepLast = 0
+	}
+	if len(history) <= keepLast {
+		return nil
+	}
+	history = history[len(history)-keepLast:]
+
+	tempFile, err := os.CreateTemp(s.dir, "history-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp history file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // 重命名成功后删除会失败，可以忽略
+
+	writer := bufio.NewWriter(tempFile)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	for _, msg := range history {
+		if err := encoder.Encode(msg); err != nil {
+			tempFile.Close()
+			return fmt.Errorf("failed to encode history message: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write temp history file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp history file: %w", err)
+	}
+	if err := fileutil.SafeRename(tempFile.Name(), s.Path(conversationID)); err != nil {
+		return fmt.Errorf("failed to replace history file: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除会话历史文件
+func (s *FileHistoryStore) Delete(ctx context.Context, conversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.Path(conversationID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete history file: %w", err)
+	}
+	return nil
+}
+
+// load 读取并解析会话历史文件，调用方需持有锁
+func (s *FileHistoryStore) load(conversationID string) ([]ChatMessage, error) {
+	f, err := os.Open(s.Path(conversationID))
+	if errors.Is(err, os.ErrNotExist) {
+		return []ChatMessage{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	// 单条消息可能很长，使用 ReadBytes 而不是有长度限制的 bufio.Scanner
+	history := make([]ChatMessage, 0)
+	reader := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var msg ChatMessage
+			if jsonErr := json.Unmarshal(line, &msg); jsonErr != nil {
+				return nil, fmt.Errorf("invalid history record at %s:%d: %w", f.Name(), lineNo, jsonErr)
+			}
+			history = append(history, msg)
+		}
+		if err == io.EOF {
+			return history, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history file: %w", err)
+		}
+	}
+}
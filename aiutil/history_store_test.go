@@ -0,0 +1,116 @@
+package aiutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testHistoryStore 对 HistoryStore 的实现执行通用的行为校验
+func testHistoryStore(t *testing.T, store HistoryStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	history, err := store.Load(ctx, "missing")
+	if err != nil || len(history) != 0 {
+		t.Fatalf("不存在的会话应返回空历史, got %v, %v", history, err)
+	}
+
+	msgs := []ChatMessage{
+		{Role: RoleUser, Content: "第一条\n带换行"},
+		{Role: RoleAssistant, Content: "", ToolCalls: []ToolCall{{ID: "c1", Type: "function", Function: FunctionCall{Name: "f", Arguments: "{}"}}}},
+		ToolMessage("c1", "<ok>"),
+	}
+	if err := store.Append(ctx, "user/1", msgs[:1]...); err != nil {
+		t.Fatalf("Append 失败: %v", err)
+	}
+	if err := store.Append(ctx, "user/1", msgs[1:]...); err != nil {
+		t.Fatalf("Append 失败: %v", err)
+	}
+	_ = store.Append(ctx, "user/2", ChatMessage{Role: RoleUser, Content: "other"})
+
+	history, err = store.Load(ctx, "user/1")
+	if err != nil {
+		t.Fatalf("Load 失败: %v", err)
+	}
+	if len(history) != 3 || history[0].Content != msgs[0].Content || history[1].ToolCalls[0].ID != "c1" || history[2].Content != "<ok>" {
+		t.Errorf("Load 结果错误: %+v", history)
+	}
+
+	if err := store.Truncate(ctx, "user/1", 2); err != nil {
+		t.Fatalf("Truncate 失败: %v", err)
+	}
+	history, _ = store.Load(ctx, "user/1")
+	if len(history) != 2 || history[0].Role != RoleAssistant {
+		t.Errorf("Truncate 后应保留最近 2 条: %+v", history)
+	}
+
+	if err := store.Delete(ctx, "user/1"); err != nil {
+		t.Fatalf("Delete 失败: %v", err)
+	}
+	if err := store.Delete(ctx, "user/1"); err != nil {
+		t.Errorf("重复 Delete 不应返回错误: %v", err)
+	}
+	if history, _ = store.Load(ctx, "user/1"); len(history) != 0 {
+		t.Errorf("Delete 后历史应为空: %+v", history)
+	}
+	if history, _ = store.Load(ctx, "user/2"); len(history) != 1 {
+		t.Errorf("其他会话不应受影响: %+v", history)
+	}
+}
+
+func TestMemoryHistoryStore(t *testing.T) {
+	testHistoryStore(t, NewMemoryHistoryStore())
+}
+
+func TestFileHistoryStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileHistoryStore(filepath.Join(dir, "history"))
+	if err != nil {
+		t.Fatalf("NewFileHistoryStore 失败: %v", err)
+	}
+	testHistoryStore(t, store)
+
+	// 会话 ID 中的路径分隔符必须被转义，不能逃逸出存储目录
+	if path := store.Path("../evil"); filepath.Dir(path) != filepath.Join(dir, "history") {
+		t.Errorf("会话文件路径逃逸出存储目录: %s", path)
+	}
+}
+
+func TestFileHistoryStore_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	newClient := func() *Client {
+		store, err := NewFileHistoryStore(dir)
+		if err != nil {
+			t.Fatalf("NewFileHistoryStore 失败: %v", err)
+		}
+		client := newTestClient(t, echoHandler(t))
+		client.config.HistoryStore = store
+		return client
+	}
+
+	ctx := context.Background()
+	req := ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}
+	if _, err := newClient().Conversation("alice").CreateChatCompletion(ctx, req); err != nil {
+		t.Fatalf("第一次请求失败: %v", err)
+	}
+
+	// 模拟进程重启：新的客户端应读取到之前的历史
+	resp, err := newClient().Conversation("alice").CreateChatCompletion(ctx, req)
+	if err != nil {
+		t.Fatalf("第二次请求失败: %v", err)
+	}
+	if got := resp.Choices[0].Message.Content; got != "hi#3" {
+		t.Errorf("重启后的请求应携带之前的历史, got %q", got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "alice.jsonl"))
+	if err != nil {
+		t.Fatalf("读取历史文件失败: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("历史文件期望 4 行，实际 %d", lines)
+	}
+}
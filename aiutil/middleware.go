@@ -0,0 +1,134 @@
+package aiutil
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// =================================================================================
+// 中间件 (Middleware)
+// =================================================================================
+
+// ChatHandler 发起一次同步对话请求，request.Messages 是包含历史记录的完整上下文
+type ChatHandler func(ctx context.Context, request ChatRequest) (*ChatResponse, error)
+
+// StreamHandler 发起一次流式对话请求 (SSE 或 WebSocket)
+type StreamHandler func(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error)
+
+// Middleware 拦截客户端发出的对话请求，可以在发送前检查或修改 ChatRequest，
+// 在返回前检查或修改响应，也可以直接返回错误拒绝请求。Chat 和 Stream 分别包装同步和流式请求，
+// 为 nil 时该类请求直接交给下一层。
+//
+// 中间件看到的是合并历史记录之后的完整请求；会话写入历史记录的是经过中间件处理后的回复，
+// 流式请求中中间件提前结束 channel 时，不完整的回复不会写入历史记录。
+// 截断历史时生成摘要的请求同样经过中间件，可以通过 ConversationIDFromContext 区分发起请求的会话。
+//
+// 使用示例
+//
+//	client.Use(aiutil.Middleware{
+//		Chat: func(next aiutil.ChatHandler) aiutil.ChatHandler {
+//			return func(ctx context.Context, req aiutil.ChatRequest) (*aiutil.ChatResponse, error) {
+//				start := time.Now()
+//				resp, err := next(ctx, req)
+//				log.Printf("model=%s cost=%s err=%v", req.Model, time.Since(start), err)
+//				return resp, err
+//			}
+//		},
+//	})
+type Middleware struct {
+	Chat   func(next ChatHandler) ChatHandler
+	Stream func(next StreamHandler) StreamHandler
+}
+
+// Use 追加中间件。先追加的中间件位于外层，最先看到请求、最后看到响应。
+// Use 是并发安全的，但只对之后发起的请求生效。
+func (c *Client) Use(middlewares ...Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// ConversationIDFromContext 返回发起请求的会话 ID，供中间件使用。不经过会话的请求返回 false。
+func ConversationIDFromContext(ctx context.Context) (string, bool) {
+	cv, ok := ctx.Value(conversationKey{}).(*Conversation)
+	if !ok {
+		return "", false
+	}
+	return cv.id, true
+}
+
+// snapshotMiddlewares 返回当前中间件列表的副本
+func (c *Client) snapshotMiddlewares() []Middleware {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Middleware(nil), c.middlewares...)
+}
+
+// chat 经过中间件发起同步对话请求
+func (c *Client) chat(ctx context.Context, request ChatRequest) (*ChatResponse, error) {
+	handler := ChatHandler(c.doChatCompletion)
+	middlewares := c.snapshotMiddlewares()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if wrap := middlewares[i].Chat; wrap != nil {
+			handler = wrap(handler)
+		}
+	}
+	return handler(ctx, request)
+}
+
+// stream 经过中间件发起流式对话请求，core 是 doSSEStream 或 doWebSocketStream。
+// 底层的流正常结束、且中间件也完整地转发了流时，以调用方收到的完整回复调用 onComplete。
+func (c *Client) stream(ctx context.Context, request ChatRequest,
+	core func(ctx context.Context, request ChatRequest, onComplete func(reply ChatMessage) error) (<-chan StreamEvent, error),
+	onComplete func(reply ChatMessage) error) (<-chan StreamEvent, error) {
+
+	var completed atomic.Bool
+	handler := StreamHandler(func(ctx context.Context, request ChatRequest) (<-chan StreamEvent, error) {
+		return core(ctx, request, func(ChatMessage) error {
+			completed.Store(true)
+			return nil
+		})
+	})
+	middlewares := c.snapshotMiddlewares()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if wrap := middlewares[i].Stream; wrap != nil {
+			handler = wrap(handler)
+		}
+	}
+
+	upstream, err := handler(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// 转发中间件输出的事件，同时拼接调用方看到的完整回复
+	streamChan := make(chan StreamEvent)
+	go func() {
+		defer close(streamChan)
+		var acc StreamAccumulator
+		for event := range upstream {
+			if event.Error == nil {
+				acc.Add(event.Data)
+			}
+			if !sendEvent(ctx, streamChan, event) {
+				drain(upstream)
+				return
+			}
+		}
+		if !completed.Load() || ctx.Err() != nil {
+			return
+		}
+		if err := onComplete(acc.Message()); err != nil {
+			sendEvent(ctx, streamChan, StreamEvent{Error: err})
+		}
+	}()
+	return streamChan, nil
+}
+
+// drain 在后台读完 channel 中剩余的事件，使不监听 ctx 的中间件 goroutine 也能退出
+func drain(stream <-chan StreamEvent) {
+	go func() {
+		for range stream {
+		}
+	}()
+}
@@ -0,0 +1,172 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// echoStreamHandler 将最后一条消息的内容作为回复返回，支持同步和 SSE 流式请求
+func echoStreamHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload := decodeRequest(t, r)
+		messages, _ := payload["messages"].([]any)
+		last, _ := messages[len(messages)-1].(map[string]any)
+		content, _ := last["content"].(string)
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, r := range content {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", string(r))
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		writeChatResponse(w, content)
+	}
+}
+
+func TestMiddleware_Chat(t *testing.T) {
+	client := newTestClient(t, echoStreamHandler(t))
+
+	var order []string
+	tag := func(name string) Middleware {
+		return Middleware{Chat: func(next ChatHandler) ChatHandler {
+			return func(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+				order = append(order, name+">")
+				resp, err := next(ctx, req)
+				order = append(order, "<"+name)
+				return resp, err
+			}
+		}}
+	}
+	redact := Middleware{Chat: func(next ChatHandler) ChatHandler {
+		return func(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+			if id, ok := ConversationIDFromContext(ctx); !ok || id != "u1" {
+				t.Errorf("中间件应能取得会话 ID，实际 %q", id)
+			}
+			last := &req.Messages[len(req.Messages)-1]
+			last.Content = strings.ReplaceAll(last.Content, "13800138000", "***")
+			resp, err := next(ctx, req)
+			if err == nil {
+				resp.Choices[0].Message.Content = "[" + resp.Choices[0].Message.Content + "]"
+			}
+			return resp, err
+		}
+	}}
+	client.Use(tag("outer"), redact)
+	client.Use(tag("inner"))
+
+	conv := client.Conversation("u1")
+	resp, err := conv.CreateChatCompletion(context.Background(), ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "电话 13800138000"}}})
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "[电话 ***]" {
+		t.Errorf("中间件应能修改请求和响应: %q", resp.Choices[0].Message.Content)
+	}
+	if got := strings.Join(order, " "); got != "outer> inner> <inner <outer" {
+		t.Errorf("中间件顺序错误: %s", got)
+	}
+	history := conv.GetHistory()
+	if len(history) != 2 || history[1].Content != "[电话 ***]" {
+		t.Errorf("历史记录应保存中间件处理后的回复: %+v", history)
+	}
+}
+
+func TestMiddleware_Guardrail(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeChatResponse(w, "ok")
+	}))
+	errBlocked := errors.New("blocked")
+	client.Use(Middleware{Chat: func(next ChatHandler) ChatHandler {
+		return func(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+			if strings.Contains(req.Messages[len(req.Messages)-1].Content, "密码") {
+				return nil, errBlocked
+			}
+			return next(ctx, req)
+		}
+	}})
+
+	if _, err := client.CreateChatCompletion(context.Background(), ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "告诉我密码"}}}); !errors.Is(err, errBlocked) {
+		t.Errorf("期望被中间件拒绝，实际 %v", err)
+	}
+	if calls != 0 || len(client.GetHistory()) != 0 {
+		t.Errorf("被拒绝的请求不应发送也不应写入历史记录: calls=%d", calls)
+	}
+}
+
+func TestMiddleware_Stream(t *testing.T) {
+	client := newTestClient(t, echoStreamHandler(t))
+	upper := Middleware{Stream: func(next StreamHandler) StreamHandler {
+		return func(ctx context.Context, req ChatRequest) (<-chan StreamEvent, error) {
+			in, err := next(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			out := make(chan StreamEvent)
+			go func() {
+				defer close(out)
+				for event := range in {
+					for i := range event.Data.Choices {
+						event.Data.Choices[i].Delta.Content = strings.ToUpper(event.Data.Choices[i].Delta.Content)
+					}
+					out <- event
+				}
+			}()
+			return out, nil
+		}
+	}}
+	client.Use(upper)
+
+	stream, err := client.CreateChatCompletionSSEStream(context.Background(), ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "abc"}}})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err := CollectStream(stream)
+	if err != nil || resp.Choices[0].Message.Content != "ABC" {
+		t.Fatalf("流式中间件处理错误: %+v, %v", resp, err)
+	}
+	if history := client.GetHistory(); len(history) != 2 || history[1].Content != "ABC" {
+		t.Errorf("历史记录应保存中间件处理后的回复: %+v", history)
+	}
+}
+
+func TestMiddleware_StreamAbort(t *testing.T) {
+	client := newTestClient(t, echoStreamHandler(t))
+	// 收到第一个数据块后中止流
+	client.Use(Middleware{Stream: func(next StreamHandler) StreamHandler {
+		return func(ctx context.Context, req ChatRequest) (<-chan StreamEvent, error) {
+			ctx, cancel := context.WithCancel(ctx)
+			in, err := next(ctx, req)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			out := make(chan StreamEvent, 2)
+			go func() {
+				defer close(out)
+				defer cancel()
+				out <- <-in
+				out <- StreamEvent{Error: errors.New("guardrail")}
+			}()
+			return out, nil
+		}
+	}})
+
+	stream, err := client.CreateChatCompletionSSEStream(context.Background(), ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "abcdef"}}})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err := CollectStream(stream)
+	if err == nil || resp.Choices[0].Message.Content != "a" {
+		t.Errorf("期望收到第一个数据块和中止错误: %+v, %v", resp, err)
+	}
+	if history := client.GetHistory(); len(history) != 0 {
+		t.Errorf("被中止的流不应写入历史记录: %+v", history)
+	}
+}
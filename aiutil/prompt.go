@@ -0,0 +1,387 @@
+package aiutil
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// =================================================================================
+// 提示词模板 (PromptTemplate)
+// =================================================================================
+
+// PromptExamplesSection 是模板中放置 few-shot 示例的段落名
+const PromptExamplesSection = "examples"
+
+// ErrMissingVariable 表示渲染提示词模板时缺少变量，或 required 检查的变量为空
+var ErrMissingVariable = errors.New("aiutil: missing prompt variable")
+
+// promptSectionPattern 匹配段落分隔行，例如 "--- system ---"
+var promptSectionPattern = regexp.MustCompile(`^---\s*([a-z]+)\s*---\s*$`)
+
+// Example 是一组 few-shot 示例，渲染为一条 user 消息和一条 assistant 消息
+type Example struct {
+	Input  string
+	Output string
+}
+
+// PromptTemplate 使用 text/template 将变量渲染为多条消息，T 是变量的类型 (通常是结构体或 map[string]any)。
+//
+// 模板由 "--- 角色 ---" 分隔行划分为多个段落，每个段落渲染为一条该角色的消息 (首尾空白会被去掉)，
+// 角色可以是 system、user、assistant，"--- examples ---" 是放置 Examples 的位置。
+// 每个段落单独渲染，因此变量内容中即使包含分隔行也不会产生新的消息。
+// 第一个分隔行之前的内容只用于 {{define}} 定义子模板；没有任何分隔行时整个模板是一条 user 消息。
+//
+// 渲染之前会检查模板引用的变量，map 中不存在的键、结构体中不存在的字段返回 ErrMissingVariable
+// (range、with 内部引用的 . 在执行时报错)，可以使用 required 要求变量不能为空。除 text/template 内置函数外还可以使用:
+//
+//	json     将值序列化为 JSON，例如 {{json .Items}}
+//	xml      转义 XML 特殊字符，例如 <doc>{{xml .Document}}</doc>
+//	fence    用足够长的反引号包裹内容作为代码块，内容中的反引号不会提前结束代码块
+//	indent   为每一行添加缩进，例如 {{indent 2 .Text}}
+//	trim     去掉首尾空白
+//	join     用分隔符连接字符串切片，例如 {{join .Tags ", "}}
+//	default  变量为空时使用默认值，例如 {{default "中文" .Language}}
+//	required 变量为空时返回 ErrMissingVariable，例如 {{required "question" .Question}}
+//
+// PromptTemplate 是并发安全的。
+//
+// 使用示例
+//
+//	type QA struct{ Language, Question string }
+//	tmpl, err := aiutil.ParsePromptTemplate[QA]("qa", `
+//	--- system ---
+//	你是翻译助手，请使用{{default "中文" .Language}}回答。
+//	--- examples ---
+//	--- user ---
+//	{{required "question" .Question}}
+//	`)
+//	tmpl.Examples = []aiutil.Example{{Input: "hello", Output: "你好"}}
+//	messages, err := tmpl.Render(QA{Question: "good morning"})
+type PromptTemplate[T any] struct {
+	// Examples 是在 "--- examples ---" 处展开的 few-shot 示例
+	Examples []Example
+
+	name     string
+	tmpl     *template.Template
+	sections []promptSection
+}
+
+type promptSection struct {
+	role string
+	name string // 段落对应的子模板名，examples 段落为空
+}
+
+// ParsePromptTemplate 解析提示词模板，name 用于错误信息
+func ParsePromptTemplate[T any](name, source string) (*PromptTemplate[T], error) {
+	p := &PromptTemplate[T]{name: name, tmpl: template.New(name).Option("missingkey=error").Funcs(promptFuncs)}
+
+	// 1. 按分隔行拆分段落
+	var preamble, body strings.Builder
+	current := -1
+	flush := func() error {
+		if current < 0 || p.sections[current].role == PromptExamplesSection {
+			if current >= 0 && strings.TrimSpace(body.String()) != "" {
+				return fmt.Errorf("prompt %s: examples section must be empty", name)
+			}
+			return nil
+		}
+		if _, err := p.tmpl.New(p.sections[current].name).Parse(body.String()); err != nil {
+			return fmt.Errorf("failed to parse prompt %s: %w", name, err)
+		}
+		return nil
+	}
+	for _, line := range strings.SplitAfter(source, "\n") {
+		m := promptSectionPattern.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if m == nil {
+			if current < 0 {
+				preamble.WriteString(line)
+			} else {
+				body.WriteString(line)
+			}
+			continue
+		}
+		switch m[1] {
+		case RoleSystem, RoleUser, RoleAssistant, PromptExamplesSection:
+		default:
+			return nil, fmt.Errorf("prompt %s: unknown section %q", name, m[1])
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		body.Reset()
+		current = len(p.sections)
+		section := promptSection{role: m[1]}
+		if section.role != PromptExamplesSection {
+			section.name = fmt.Sprintf("%s#%d", name, current)
+		}
+		p.sections = append(p.sections, section)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	// 2. 分隔行之前的内容只用于定义子模板；没有分隔行时整个模板是一条 user 消息
+	if len(p.sections) == 0 {
+		p.sections = []promptSection{{role: RoleUser, name: name + "#0"}}
+		if _, err := p.tmpl.New(p.sections[0].name).Parse(preamble.String()); err != nil {
+			return nil, fmt.Errorf("failed to parse prompt %s: %w", name, err)
+		}
+		return p, nil
+	}
+	if _, err := p.tmpl.Parse(preamble.String()); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// LoadPromptTemplate 从文件加载提示词模板，partials 是其中的 {{define}} 可被模板引用的子模板文件。
+// 子模板文件也可以通过文件名直接引用，例如 {{template "style.tmpl" .}}。
+func LoadPromptTemplate[T any](path string, partials ...string) (*PromptTemplate[T], error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt template: %w", err)
+	}
+	p, err := ParsePromptTemplate[T](filepath.Base(path), string(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		data, err := os.ReadFile(partial)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt partial: %w", err)
+		}
+		if _, err := p.tmpl.New(filepath.Base(partial)).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("failed to parse prompt partial %s: %w", partial, err)
+		}
+	}
+	return p, nil
+}
+
+// Render 使用 vars 渲染模板，返回按段落顺序排列的消息
+func (p *PromptTemplate[T]) Render(vars T) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	for _, section := range p.sections {
+		if section.role == PromptExamplesSection {
+			for _, example := range p.Examples {
+				messages = append(messages,
+					ChatMessage{Role: RoleUser, Content: example.Input},
+					ChatMessage{Role: RoleAssistant, Content: example.Output},
+				)
+			}
+			continue
+		}
+		if err := checkPromptVariables(p.tmpl, section.name, reflect.ValueOf(vars)); err != nil {
+			return nil, fmt.Errorf("failed to render prompt %s: %w", p.name, err)
+		}
+		var sb strings.Builder
+		if err := p.tmpl.ExecuteTemplate(&sb, section.name, vars); err != nil {
+			return nil, fmt.Errorf("failed to render prompt %s: %w", p.name, err)
+		}
+		messages = append(messages, ChatMessage{Role: section.role, Content: strings.TrimSpace(sb.String())})
+	}
+	return messages, nil
+}
+
+// checkPromptVariables 在执行模板之前检查 name 及其通过 {{template "x" .}} 引用的子模板中
+// 以根变量为起点的字段 (例如 {{.Name}}、{{$.Items}}) 在 vars 中是否存在，缺少时返回 ErrMissingVariable。
+// range、with 内部的 . 指向其他值，不做检查，由执行时的 missingkey=error 报告。
+func checkPromptVariables(tmpl *template.Template, name string, vars reflect.Value) error {
+	c := &promptVariableChecker{tmpl: tmpl, vars: vars, visiting: map[string]bool{}}
+	return c.checkTemplate(name)
+}
+
+type promptVariableChecker struct {
+	tmpl     *template.Template
+	vars     reflect.Value
+	visiting map[string]bool // 正在检查的子模板，避免递归引用导致死循环
+}
+
+func (c *promptVariableChecker) checkTemplate(name string) error {
+	t := c.tmpl.Lookup(name)
+	if t == nil || t.Tree == nil || c.visiting[name] {
+		return nil
+	}
+	c.visiting[name] = true
+	defer delete(c.visiting, name)
+	return c.check(t.Tree.Root, true)
+}
+
+// check 检查 node 中引用的变量，rootDot 表示 node 中的 . 是否指向根变量
+func (c *promptVariableChecker) check(node parse.Node, rootDot bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := c.check(child, rootDot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return c.check(n.Pipe, rootDot)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				if err := c.check(arg, rootDot); err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.IfNode:
+		return c.checkBranch(&n.BranchNode, rootDot, rootDot)
+	case *parse.RangeNode:
+		return c.checkBranch(&n.BranchNode, rootDot, false)
+	case *parse.WithNode:
+		return c.checkBranch(&n.BranchNode, rootDot, false)
+	case *parse.TemplateNode:
+		if err := c.check(n.Pipe, rootDot); err != nil {
+			return err
+		}
+		// 只有把根变量原样传给子模板时才继续检查子模板
+		if isRootPipe(n.Pipe, rootDot) {
+			return c.checkTemplate(n.Name)
+		}
+	case *parse.FieldNode:
+		if rootDot {
+			return lookupPromptVariable(c.vars, n.Ident)
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return lookupPromptVariable(c.vars, n.Ident[1:])
+		}
+	}
+	return nil
+}
+
+// checkBranch 检查 if、range、with 的条件和分支，bodyRoot 表示主分支中的 . 是否仍指向根变量
+func (c *promptVariableChecker) checkBranch(n *parse.BranchNode, rootDot, bodyRoot bool) error {
+	if err := c.check(n.Pipe, rootDot); err != nil {
+		return err
+	}
+	if err := c.check(n.List, rootDot && bodyRoot); err != nil {
+		return err
+	}
+	return c.check(n.ElseList, rootDot)
+}
+
+// isRootPipe 判断 pipe 是否是 . 或 $，即把根变量原样传递
+func isRootPipe(pipe *parse.PipeNode, rootDot bool) bool {
+	if pipe == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return rootDot
+	case *parse.VariableNode:
+		return len(arg.Ident) == 1 && arg.Ident[0] == "$"
+	}
+	return false
+}
+
+// lookupPromptVariable 沿着 fields 在 v 中查找变量，map 中不存在的键、结构体中不存在的字段返回 ErrMissingVariable。
+// 遇到 nil、方法或无法判断的类型时停止检查。
+func lookupPromptVariable(v reflect.Value, fields []string) error {
+	for i, field := range fields {
+		if !v.IsValid() {
+			return nil
+		}
+		if v.Kind() != reflect.Interface && v.MethodByName(field).IsValid() {
+			return nil
+		}
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+			if v.MethodByName(field).IsValid() {
+				return nil
+			}
+		}
+
+		missing := fmt.Errorf("%w: .%s", ErrMissingVariable, strings.Join(fields[:i+1], "."))
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil
+			}
+			value := v.MapIndex(reflect.ValueOf(field).Convert(v.Type().Key()))
+			if !value.IsValid() {
+				return missing
+			}
+			v = value
+		case reflect.Struct:
+			f, ok := v.Type().FieldByName(field)
+			if !ok || !f.IsExported() {
+				return missing
+			}
+			value, err := v.FieldByIndexErr(f.Index)
+			if err != nil {
+				return nil // 经过了 nil 的嵌入指针
+			}
+			v = value
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// promptFuncs 是提示词模板中可用的函数
+var promptFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"xml": func(s string) (string, error) {
+		var sb strings.Builder
+		err := xml.EscapeText(&sb, []byte(s))
+		return sb.String(), err
+	},
+	"fence": func(s string) string {
+		// 围栏比内容中最长的连续反引号多一个，至少三个
+		longest, run := 0, 0
+		for _, r := range s {
+			if r == '`' {
+				run++
+				longest = max(longest, run)
+			} else {
+				run = 0
+			}
+		}
+		fence := strings.Repeat("`", max(3, longest+1))
+		return fence + "\n" + s + "\n" + fence
+	},
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+	"trim": strings.TrimSpace,
+	"join": func(elems []string, sep string) string {
+		return strings.Join(elems, sep)
+	},
+	"default": func(def string, v any) any {
+		if s, ok := v.(string); (ok && s == "") || v == nil {
+			return def
+		}
+		return v
+	},
+	"required": func(name string, v any) (any, error) {
+		if s, ok := v.(string); (ok && s == "") || v == nil {
+			return nil, fmt.Errorf("%w: %s", ErrMissingVariable, name)
+		}
+		return v, nil
+	},
+}
@@ -0,0 +1,220 @@
+package aiutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Bronya0/go-utils/sseutil"
+)
+
+// =================================================================================
+// 服务商适配 (Provider)
+// =================================================================================
+
+// Provider 负责在通用的 ChatRequest / ChatResponse / ChatStreamResponse 与服务商的线上格式之间转换，
+// 使同一套调用方式可以用于不同的服务商。认证等请求头仍由 Config.DefaultHeaders 设置。
+//
+// 内置实现: OpenAIProvider (默认)、AnthropicProvider、GeminiProvider、OllamaProvider。
+type Provider interface {
+	// EncodeRequest 将请求转换为服务商的请求路径 (拼接在 BaseURL 之后) 和可序列化为 JSON 的请求体。
+	// 路径为空时使用 Config.DefaultEndpoint。request.CustomParams 由客户端在序列化后合并。
+	EncodeRequest(request ChatRequest) (path string, body any, err error)
+	// DecodeResponse 解析同步请求的响应体
+	DecodeResponse(body io.Reader) (*ChatResponse, error)
+	// NewStreamDecoder 返回流式响应体的解码器
+	NewStreamDecoder(body io.Reader) StreamDecoder
+}
+
+// StreamDecoder 逐个解码流式响应中的数据块
+type StreamDecoder interface {
+	// Next 返回下一个数据块，流正常结束时返回 io.EOF。
+	// 其他错误会作为 StreamEvent 交给调用方，之后继续调用 Next；无法继续读取时应在之后返回 io.EOF。
+	Next() (*ChatStreamResponse, error)
+}
+
+// OpenAIProvider 是 OpenAI Chat Completions 格式，也适用于大多数兼容 OpenAI 的服务
+type OpenAIProvider struct {
+	// MaxEventSize 是流式响应中单个 SSE 事件允许的最大字节数，为 0 时使用 sseutil.DefaultMaxEventSize
+	MaxEventSize int
+}
+
+// EncodeRequest 实现 Provider
+func (OpenAIProvider) EncodeRequest(request ChatRequest) (string, any, error) {
+	return "", request, nil
+}
+
+// DecodeResponse 实现 Provider
+func (OpenAIProvider) DecodeResponse(body io.Reader) (*ChatResponse, error) {
+	var result ChatResponse
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// NewStreamDecoder 实现 Provider，解码以 "data: [DONE]" 结束的 SSE 流。
+// 服务端在流中途发送的错误 (event: error 或带 error 字段的数据块) 会作为 *APIError 返回。
+func (p OpenAIProvider) NewStreamDecoder(body io.Reader) StreamDecoder {
+	return &openAIStreamDecoder{events: newSSEReader(body, p.MaxEventSize)}
+}
+
+type openAIStreamDecoder struct {
+	events *sseReader
+}
+
+// openAIStreamChunk 在数据块之外识别服务端在流中途返回的错误
+type openAIStreamChunk struct {
+	ChatStreamResponse
+	Error json.RawMessage `json:"error"`
+}
+
+func (d *openAIStreamDecoder) Next() (*ChatStreamResponse, error) {
+	event, data, err := d.events.next()
+	if err != nil {
+		return nil, err
+	}
+	if data == "[DONE]" {
+		d.events.stop()
+		return nil, io.EOF
+	}
+	if event == "error" {
+		return nil, parseAPIErrorBody([]byte(data))
+	}
+	var chunk openAIStreamChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil, fmt.Errorf("error unmarshalling stream chunk: %w", err)
+	}
+	if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+		return nil, parseAPIErrorBody([]byte(data))
+	}
+	return &chunk.ChatStreamResponse, nil
+}
+
+// sseReader 基于 sseutil.Decoder 逐个读取 SSE 事件，读取失败后返回一次错误，此后总是返回 io.EOF
+type sseReader struct {
+	decoder *sseutil.Decoder
+	done    bool
+}
+
+func newSSEReader(r io.Reader, maxEventSize int) *sseReader {
+	decoder := sseutil.NewDecoder(r)
+	decoder.MaxEventSize = maxEventSize
+	return &sseReader{decoder: decoder}
+}
+
+func (r *sseReader) stop() { r.done = true }
+
+func (r *sseReader) next() (event, data string, err error) {
+	if r.done {
+		return "", "", io.EOF
+	}
+	ev, err := r.decoder.Decode()
+	if err != nil {
+		r.done = true
+		if err == io.EOF {
+			return "", "", io.EOF
+		}
+		return "", "", fmt.Errorf("error reading stream: %w", err)
+	}
+	return ev.Event, ev.Data, nil
+}
+
+// ndjsonReader 逐行读取 NDJSON，读取失败后返回一次错误，此后总是返回 io.EOF
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	done    bool
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (r *ndjsonReader) next() ([]byte, error) {
+	for !r.done && r.scanner.Scan() {
+		if line := strings.TrimSpace(r.scanner.Text()); line != "" {
+			return []byte(line), nil
+		}
+	}
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading stream: %w", err)
+	}
+	return nil, io.EOF
+}
+
+// recordToolCalls 记录消息中工具调用的 ID 到函数名的映射。
+// Gemini、Ollama 等格式按函数名而不是调用 ID 关联工具结果；不同轮次的调用 ID 可能重复，
+// 因此需要按消息顺序逐条记录，使工具结果总是对应最近一次同 ID 的调用。
+func recordToolCalls(names map[string]string, msg ChatMessage) {
+	for _, call := range msg.ToolCalls {
+		names[call.ID] = call.Function.Name
+	}
+}
+
+// toolArguments 将 JSON 字符串形式的工具参数转换为对象，空参数视为 {}
+func toolArguments(arguments string) (json.RawMessage, error) {
+	if strings.TrimSpace(arguments) == "" {
+		return json.RawMessage("{}"), nil
+	}
+	if !json.Valid([]byte(arguments)) {
+		return nil, fmt.Errorf("invalid tool call arguments: %q", arguments)
+	}
+	return json.RawMessage(arguments), nil
+}
+
+// toolResultObject 将工具结果转换为 JSON 对象，不是对象时包装为 {"content": ...}
+func toolResultObject(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	b, _ := json.Marshal(map[string]string{"content": content})
+	return b
+}
+
+// splitDataURL 拆分 "data:<mime>;base64,<data>" 形式的 Data URL
+func splitDataURL(url string) (mimeType, data string, ok bool) {
+	rest, found := strings.CutPrefix(url, "data:")
+	if !found {
+		return "", "", false
+	}
+	mimeType, data, ok = strings.Cut(rest, ";base64,")
+	return mimeType, data, ok
+}
+
+// toolChoiceName 返回 ToolChoiceFunction 指定的函数名
+func toolChoiceName(choice any) string {
+	m, ok := choice.(map[string]any)
+	if !ok {
+		return ""
+	}
+	switch fn := m["function"].(type) {
+	case map[string]string:
+		return fn["name"]
+	case map[string]any:
+		name, _ := fn["name"].(string)
+		return name
+	}
+	return ""
+}
+
+// reasoningBudget 将 ReasoningEffort 转换为思考的 Token 预算，未设置或无法识别时返回 0
+func reasoningBudget(effort string) int {
+	switch effort {
+	case ReasoningEffortLow:
+		return 1024
+	case ReasoningEffortMedium:
+		return 4096
+	case ReasoningEffortHigh:
+		return 16384
+	}
+	return 0
+}
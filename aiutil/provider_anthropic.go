@@ -0,0 +1,399 @@
+package aiutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// =================================================================================
+// Anthropic Messages API
+// =================================================================================
+
+// DefaultAnthropicVersion 是发送给 Anthropic 的 anthropic-version 请求头
+const DefaultAnthropicVersion = "2023-06-01"
+
+// DefaultAnthropicMaxTokens 是请求没有设置 MaxTokens 时使用的值 (Anthropic 要求必须设置)
+const DefaultAnthropicMaxTokens = 4096
+
+// DefaultAnthropicConfig 创建一个调用 Anthropic Messages API 的配置
+func DefaultAnthropicConfig(apiKey string) Config {
+	config := DefaultConfig("")
+	config.BaseURL = "https://api.anthropic.com/v1"
+	config.DefaultEndpoint = "/messages"
+	config.DefaultHeaders = map[string]string{
+		"x-api-key":         apiKey,
+		"anthropic-version": DefaultAnthropicVersion,
+		"Content-Type":      "application/json",
+	}
+	config.Provider = AnthropicProvider{}
+	return config
+}
+
+// AnthropicProvider 适配 Anthropic Messages API (/v1/messages)。
+// system 消息被合并为顶层的 system 字段，工具调用和工具结果被转换为 tool_use / tool_result 内容块。
+// 设置 ReasoningEffort 时开启扩展思考，thinking 内容块被转换为 ReasoningContent；
+// 历史消息中的 ReasoningContent 不会发回 (Anthropic 要求附带签名)。
+type AnthropicProvider struct {
+	// DefaultMaxTokens 是请求没有设置 MaxTokens 时使用的值，为 0 时使用 DefaultAnthropicMaxTokens
+	DefaultMaxTokens int
+	// MaxEventSize 是流式响应中单个 SSE 事件允许的最大字节数，为 0 时使用 sseutil.DefaultMaxEventSize
+	MaxEventSize int
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   float32            `json:"temperature,omitempty"`
+	TopP          float32            `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    map[string]any     `json:"tool_choice,omitempty"`
+	Thinking      *anthropicThinking `json:"thinking,omitempty"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"` // "enabled"
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type string `json:"type"`
+	// text
+	Text string `json:"text,omitempty"`
+	// image / document
+	Source *anthropicSource `json:"source,omitempty"`
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	// thinking
+	Thinking string `json:"thinking,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"` // "base64" 或 "url"
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
+// usage 转换为 Usage。Anthropic 的 input_tokens 不包含缓存读写的部分，需要加回到 PromptTokens 中。
+func (u anthropicUsage) usage() Usage {
+	prompt := u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens
+	usage := Usage{PromptTokens: prompt, CompletionTokens: u.OutputTokens, TotalTokens: prompt + u.OutputTokens}
+	if u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: u.CacheReadInputTokens}
+	}
+	return usage
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// EncodeRequest 实现 Provider
+func (p AnthropicProvider) EncodeRequest(request ChatRequest) (string, any, error) {
+	req := anthropicRequest{
+		Model:         request.Model,
+		MaxTokens:     request.MaxTokens,
+		Temperature:   request.Temperature,
+		TopP:          request.TopP,
+		StopSequences: request.Stop,
+		Stream:        request.Stream,
+	}
+	if req.MaxTokens <= 0 {
+		req.MaxTokens = p.DefaultMaxTokens
+		if req.MaxTokens <= 0 {
+			req.MaxTokens = DefaultAnthropicMaxTokens
+		}
+	}
+	// 扩展思考的预算计入 max_tokens，且不能与 temperature、top_p 同时设置
+	if budget := reasoningBudget(request.ReasoningEffort); budget > 0 {
+		req.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		req.MaxTokens += budget
+		req.Temperature, req.TopP = 0, 0
+	}
+
+	var system []string
+	for _, msg := range request.Messages {
+		if msg.Role == RoleSystem {
+			system = append(system, msg.Text())
+			continue
+		}
+		converted, err := anthropicConvertMessage(msg)
+		if err != nil {
+			return "", nil, err
+		}
+		// Anthropic 要求 user 和 assistant 交替出现，合并相邻的同角色消息
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == converted.Role {
+			req.Messages[n-1].Content = append(req.Messages[n-1].Content, converted.Content...)
+			continue
+		}
+		req.Messages = append(req.Messages, converted)
+	}
+	req.System = strings.Join(system, "\n\n")
+
+	for _, tool := range request.Tools {
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		req.Tools = append(req.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	switch choice := request.ToolChoice.(type) {
+	case nil:
+	case string:
+		switch choice {
+		case "auto":
+			req.ToolChoice = map[string]any{"type": "auto"}
+		case "required":
+			req.ToolChoice = map[string]any{"type": "any"}
+		case "none":
+			req.ToolChoice = map[string]any{"type": "none"}
+		}
+	default:
+		if name := toolChoiceName(choice); name != "" {
+			req.ToolChoice = map[string]any{"type": "tool", "name": name}
+		}
+	}
+	return "/messages", req, nil
+}
+
+// anthropicConvertMessage 将一条非 system 消息转换为 Anthropic 的消息
+func anthropicConvertMessage(msg ChatMessage) (anthropicMessage, error) {
+	if msg.Role == RoleTool {
+		return anthropicMessage{Role: RoleUser, Content: []anthropicBlock{
+			{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Text()},
+		}}, nil
+	}
+
+	out := anthropicMessage{Role: msg.Role}
+	if out.Role != RoleAssistant {
+		out.Role = RoleUser
+	}
+	if msg.Content != "" {
+		out.Content = append(out.Content, anthropicBlock{Type: "text", Text: msg.Content})
+	}
+	for _, part := range msg.MultiContent {
+		switch part.Type {
+		case ContentPartText:
+			out.Content = append(out.Content, anthropicBlock{Type: "text", Text: part.Text})
+		case ContentPartImageURL:
+			out.Content = append(out.Content, anthropicBlock{Type: "image", Source: anthropicSourceFromURL(part.ImageURL.URL)})
+		case ContentPartFile:
+			if part.File.FileData == "" {
+				return out, fmt.Errorf("anthropic: file_id content is not supported")
+			}
+			out.Content = append(out.Content, anthropicBlock{Type: "document", Source: anthropicSourceFromURL(part.File.FileData)})
+		default:
+			return out, fmt.Errorf("anthropic: unsupported content part %q", part.Type)
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		input, err := toolArguments(call.Function.Arguments)
+		if err != nil {
+			return out, err
+		}
+		out.Content = append(out.Content, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+	}
+	if len(out.Content) == 0 {
+		out.Content = []anthropicBlock{{Type: "text", Text: ""}}
+	}
+	return out, nil
+}
+
+func anthropicSourceFromURL(url string) *anthropicSource {
+	if mimeType, data, ok := splitDataURL(url); ok {
+		return &anthropicSource{Type: "base64", MediaType: mimeType, Data: data}
+	}
+	return &anthropicSource{Type: "url", URL: url}
+}
+
+// anthropicFinishReason 将 stop_reason 转换为 OpenAI 的 finish_reason
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	}
+	return reason
+}
+
+// DecodeResponse 实现 Provider
+func (AnthropicProvider) DecodeResponse(body io.Reader) (*ChatResponse, error) {
+	var resp anthropicResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	msg := ChatMessage{Role: RoleAssistant}
+	var text, thinking strings.Builder
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			thinking.WriteString(block.Thinking)
+		case "tool_use":
+			input := string(block.Input)
+			if input == "" {
+				input = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID: block.ID, Type: "function", Function: FunctionCall{Name: block.Name, Arguments: input},
+			})
+		}
+	}
+	msg.Content = text.String()
+	msg.ReasoningContent = thinking.String()
+
+	return &ChatResponse{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Model:   resp.Model,
+		Choices: []ChatChoice{{Message: msg, FinishReason: anthropicFinishReason(resp.StopReason)}},
+		Usage:   resp.Usage.usage(),
+	}, nil
+}
+
+// NewStreamDecoder 实现 Provider，解码 message_start、content_block_delta 等 SSE 事件
+func (p AnthropicProvider) NewStreamDecoder(body io.Reader) StreamDecoder {
+	return &anthropicStreamDecoder{events: newSSEReader(body, p.MaxEventSize), toolIndex: make(map[int]int)}
+}
+
+type anthropicStreamDecoder struct {
+	events    *sseReader
+	id, model string
+	usage     anthropicUsage // message_start 中的输入用量，message_delta 中的输出用量
+	toolIndex map[int]int    // 内容块下标 -> 工具调用下标
+}
+
+type anthropicStreamEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message"`
+	ContentBlock *anthropicBlock    `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+}
+
+func (d *anthropicStreamDecoder) Next() (*ChatStreamResponse, error) {
+	for {
+		event, data, err := d.events.next()
+		if err != nil {
+			return nil, err
+		}
+
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return nil, fmt.Errorf("error unmarshalling stream chunk: %w", err)
+		}
+		if ev.Type == "" {
+			ev.Type = event
+		}
+
+		var delta ChatDelta
+		var finishReason string
+		var usage *Usage
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil {
+				d.id, d.model = ev.Message.ID, ev.Message.Model
+				d.usage = ev.Message.Usage
+			}
+			delta.Role = RoleAssistant
+		case "content_block_start":
+			if ev.ContentBlock == nil || ev.ContentBlock.Type != "tool_use" {
+				continue
+			}
+			index := len(d.toolIndex)
+			d.toolIndex[ev.Index] = index
+			delta.ToolCalls = []ToolCall{{
+				Index: &index, ID: ev.ContentBlock.ID, Type: "function",
+				Function: FunctionCall{Name: ev.ContentBlock.Name},
+			}}
+		case "content_block_delta":
+			switch ev.Delta.Type {
+			case "text_delta":
+				delta.Content = ev.Delta.Text
+			case "thinking_delta":
+				delta.ReasoningContent = ev.Delta.Thinking
+			case "input_json_delta":
+				index, ok := d.toolIndex[ev.Index]
+				if !ok {
+					continue
+				}
+				delta.ToolCalls = []ToolCall{{Index: &index, Function: FunctionCall{Arguments: ev.Delta.PartialJSON}}}
+			default:
+				continue
+			}
+		case "message_delta":
+			if ev.Usage != nil {
+				d.usage.OutputTokens = ev.Usage.OutputTokens
+				u := d.usage.usage()
+				usage = &u
+			}
+			if ev.Delta.StopReason == "" && usage == nil {
+				continue
+			}
+			finishReason = anthropicFinishReason(ev.Delta.StopReason)
+		case "message_stop":
+			d.events.stop()
+			return nil, io.EOF
+		case "error":
+			apiErr := parseAPIErrorBody([]byte(data))
+			return nil, apiErr
+		default: // ping、content_block_stop 等
+			continue
+		}
+
+		return &ChatStreamResponse{
+			ID:      d.id,
+			Object:  "chat.completion.chunk",
+			Model:   d.model,
+			Choices: []ChatStreamChoice{{Delta: delta, FinishReason: finishReason}},
+			Usage:   usage,
+		}, nil
+	}
+}
@@ -0,0 +1,392 @@
+package aiutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// =================================================================================
+// Google Gemini generateContent API
+// =================================================================================
+
+// DefaultGeminiConfig 创建一个调用 Gemini API 的配置
+func DefaultGeminiConfig(apiKey string) Config {
+	config := DefaultConfig("")
+	config.BaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	config.DefaultHeaders = map[string]string{
+		"x-goog-api-key": apiKey,
+		"Content-Type":   "application/json",
+	}
+	config.Provider = GeminiProvider{}
+	return config
+}
+
+// GeminiProvider 适配 Gemini 的 generateContent / streamGenerateContent 接口。
+// 请求路径由模型名决定 (/models/{model}:generateContent)，流式请求使用 alt=sse。
+// Gemini 的函数调用没有 ID，GeminiProvider 会为其生成 "call_{序号}" 形式的 ID，
+// 并在发送工具结果时根据 ID 找回函数名。
+type GeminiProvider struct {
+	// MaxEventSize 是流式响应中单个 SSE 事件允许的最大字节数，为 0 时使用 sseutil.DefaultMaxEventSize
+	MaxEventSize int
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string              `json:"text,omitempty"`
+	InlineData       *geminiBlob         `json:"inlineData,omitempty"`
+	FileData         *geminiFileData     `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResp `json:"functionResponse,omitempty"`
+	// Thought 为 true 时 Text 是思考过程的摘要
+	Thought bool `json:"thought,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResp struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature        float32  `json:"temperature,omitempty"`
+	TopP               float32  `json:"topP,omitempty"`
+	MaxOutputTokens    int      `json:"maxOutputTokens,omitempty"`
+	CandidateCount     int      `json:"candidateCount,omitempty"`
+	StopSequences      []string `json:"stopSequences,omitempty"`
+	PresencePenalty    float32  `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   float32  `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType   string   `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema any      `json:"responseJsonSchema,omitempty"`
+
+	ThinkingConfig *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget"`
+	IncludeThoughts bool `json:"includeThoughts"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parametersJsonSchema,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+		Index        int           `json:"index"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string          `json:"modelVersion"`
+	ResponseID   string          `json:"responseId"`
+	Error        json.RawMessage `json:"error"` // 流式响应中途的错误
+}
+
+// EncodeRequest 实现 Provider
+func (GeminiProvider) EncodeRequest(request ChatRequest) (string, any, error) {
+	if request.Model == "" {
+		return "", nil, fmt.Errorf("gemini: model is required")
+	}
+	req := geminiRequest{}
+	names := make(map[string]string)
+
+	var system []geminiPart
+	for _, msg := range request.Messages {
+		if msg.Role == RoleSystem {
+			system = append(system, geminiPart{Text: msg.Text()})
+			continue
+		}
+		converted, err := geminiConvertMessage(msg, names)
+		if err != nil {
+			return "", nil, err
+		}
+		recordToolCalls(names, msg)
+		if n := len(req.Contents); n > 0 && req.Contents[n-1].Role == converted.Role {
+			req.Contents[n-1].Parts = append(req.Contents[n-1].Parts, converted.Parts...)
+			continue
+		}
+		req.Contents = append(req.Contents, converted)
+	}
+	if len(system) > 0 {
+		req.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	cfg := geminiGenerationConfig{
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		MaxOutputTokens:  request.MaxTokens,
+		CandidateCount:   request.N,
+		StopSequences:    request.Stop,
+		PresencePenalty:  request.PresencePenalty,
+		FrequencyPenalty: request.FrequencyPenalty,
+	}
+	if rf := request.ResponseFormat; rf != nil && rf.Type != ResponseFormatText {
+		cfg.ResponseMimeType = "application/json"
+		if rf.JSONSchema != nil {
+			cfg.ResponseJSONSchema = rf.JSONSchema.Schema
+		}
+	}
+	if budget := reasoningBudget(request.ReasoningEffort); budget > 0 {
+		cfg.ThinkingConfig = &geminiThinkingConfig{ThinkingBudget: budget, IncludeThoughts: true}
+	}
+	req.GenerationConfig = &cfg
+
+	if len(request.Tools) > 0 {
+		tool := geminiTool{}
+		for _, t := range request.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, geminiFunctionDeclaration{
+				Name: t.Function.Name, Description: t.Function.Description, Parameters: t.Function.Parameters,
+			})
+		}
+		req.Tools = []geminiTool{tool}
+	}
+	if request.ToolChoice != nil {
+		tc := &geminiToolConfig{}
+		switch choice := request.ToolChoice.(type) {
+		case string:
+			tc.FunctionCallingConfig.Mode = map[string]string{"auto": "AUTO", "required": "ANY", "none": "NONE"}[choice]
+		default:
+			if name := toolChoiceName(choice); name != "" {
+				tc.FunctionCallingConfig.Mode = "ANY"
+				tc.FunctionCallingConfig.AllowedFunctionNames = []string{name}
+			}
+		}
+		if tc.FunctionCallingConfig.Mode != "" {
+			req.ToolConfig = tc
+		}
+	}
+
+	method := ":generateContent"
+	if request.Stream {
+		method = ":streamGenerateContent?alt=sse"
+	}
+	return "/models/" + url.PathEscape(strings.TrimPrefix(request.Model, "models/")) + method, req, nil
+}
+
+// geminiConvertMessage 将一条非 system 消息转换为 Gemini 的 content
+func geminiConvertMessage(msg ChatMessage, names map[string]string) (geminiContent, error) {
+	if msg.Role == RoleTool {
+		name := names[msg.ToolCallID]
+		if name == "" {
+			return geminiContent{}, fmt.Errorf("gemini: no tool call found for tool message %q", msg.ToolCallID)
+		}
+		return geminiContent{Role: "user", Parts: []geminiPart{{
+			FunctionResponse: &geminiFunctionResp{Name: name, Response: toolResultObject(msg.Text())},
+		}}}, nil
+	}
+
+	out := geminiContent{Role: "user"}
+	if msg.Role == RoleAssistant {
+		out.Role = "model"
+	}
+	if msg.Content != "" {
+		out.Parts = append(out.Parts, geminiPart{Text: msg.Content})
+	}
+	for _, part := range msg.MultiContent {
+		switch part.Type {
+		case ContentPartText:
+			out.Parts = append(out.Parts, geminiPart{Text: part.Text})
+		case ContentPartImageURL:
+			out.Parts = append(out.Parts, geminiMediaPart(part.ImageURL.URL))
+		case ContentPartInputAudio:
+			out.Parts = append(out.Parts, geminiPart{InlineData: &geminiBlob{MimeType: "audio/" + part.InputAudio.Format, Data: part.InputAudio.Data}})
+		case ContentPartFile:
+			if part.File.FileData == "" {
+				out.Parts = append(out.Parts, geminiPart{FileData: &geminiFileData{FileURI: part.File.FileID}})
+				continue
+			}
+			out.Parts = append(out.Parts, geminiMediaPart(part.File.FileData))
+		default:
+			return out, fmt.Errorf("gemini: unsupported content part %q", part.Type)
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		args, err := toolArguments(call.Function.Arguments)
+		if err != nil {
+			return out, err
+		}
+		out.Parts = append(out.Parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Function.Name, Args: args}})
+	}
+	if len(out.Parts) == 0 {
+		out.Parts = []geminiPart{{Text: ""}}
+	}
+	return out, nil
+}
+
+func geminiMediaPart(url string) geminiPart {
+	if mimeType, data, ok := splitDataURL(url); ok {
+		return geminiPart{InlineData: &geminiBlob{MimeType: mimeType, Data: data}}
+	}
+	return geminiPart{FileData: &geminiFileData{FileURI: url}}
+}
+
+// geminiFinishReason 将 finishReason 转换为 OpenAI 的 finish_reason
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	}
+	return strings.ToLower(reason)
+}
+
+// convert 将一个 Gemini 响应转换为各个候选的消息，callOffset 是已经生成的工具调用 ID 数
+func (r *geminiResponse) convert(callOffset int) (messages []ChatMessage, reasons []string) {
+	for _, cand := range r.Candidates {
+		msg := ChatMessage{Role: RoleAssistant}
+		var text, thought strings.Builder
+		for _, part := range cand.Content.Parts {
+			if part.Thought {
+				thought.WriteString(part.Text)
+				continue
+			}
+			text.WriteString(part.Text)
+			if part.FunctionCall != nil {
+				id := part.FunctionCall.ID
+				if id == "" {
+					id = fmt.Sprintf("call_%d", callOffset)
+				}
+				callOffset++
+				args := string(part.FunctionCall.Args)
+				if args == "" {
+					args = "{}"
+				}
+				msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+					ID: id, Type: "function", Function: FunctionCall{Name: part.FunctionCall.Name, Arguments: args},
+				})
+			}
+		}
+		msg.Content = text.String()
+		msg.ReasoningContent = thought.String()
+		reason := geminiFinishReason(cand.FinishReason)
+		if reason == "stop" && len(msg.ToolCalls) > 0 {
+			reason = "tool_calls"
+		}
+		messages = append(messages, msg)
+		reasons = append(reasons, reason)
+	}
+	return messages, reasons
+}
+
+func (r *geminiResponse) usage() Usage {
+	usage := Usage{
+		PromptTokens:     r.UsageMetadata.PromptTokenCount,
+		CompletionTokens: r.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:      r.UsageMetadata.TotalTokenCount,
+	}
+	if cached := r.UsageMetadata.CachedContentTokenCount; cached > 0 {
+		usage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: cached}
+	}
+	return usage
+}
+
+// DecodeResponse 实现 Provider
+func (GeminiProvider) DecodeResponse(body io.Reader) (*ChatResponse, error) {
+	var resp geminiResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	result := &ChatResponse{ID: resp.ResponseID, Object: "chat.completion", Model: resp.ModelVersion, Usage: resp.usage()}
+	messages, reasons := resp.convert(0)
+	for i := range messages {
+		result.Choices = append(result.Choices, ChatChoice{Index: i, Message: messages[i], FinishReason: reasons[i]})
+	}
+	return result, nil
+}
+
+// NewStreamDecoder 实现 Provider，每个 SSE 事件都是一个完整的 generateContent 响应
+func (p GeminiProvider) NewStreamDecoder(body io.Reader) StreamDecoder {
+	return &geminiStreamDecoder{events: newSSEReader(body, p.MaxEventSize)}
+}
+
+type geminiStreamDecoder struct {
+	events *sseReader
+	calls  int // 已生成的工具调用数，用于生成 ID 和流式下标
+}
+
+func (d *geminiStreamDecoder) Next() (*ChatStreamResponse, error) {
+	_, data, err := d.events.next()
+	if err != nil {
+		return nil, err
+	}
+	var resp geminiResponse
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		return nil, fmt.Errorf("error unmarshalling stream chunk: %w", err)
+	}
+	if len(resp.Error) > 0 {
+		return nil, parseAPIErrorBody([]byte(data))
+	}
+
+	chunk := &ChatStreamResponse{ID: resp.ResponseID, Object: "chat.completion.chunk", Model: resp.ModelVersion}
+	if resp.UsageMetadata.TotalTokenCount > 0 {
+		// 每个数据块都携带截至当前的累计用量
+		usage := resp.usage()
+		chunk.Usage = &usage
+	}
+	messages, reasons := resp.convert(d.calls)
+	for i, msg := range messages {
+		delta := ChatDelta{Content: msg.Content, ReasoningContent: msg.ReasoningContent}
+		for _, call := range msg.ToolCalls {
+			index := d.calls
+			d.calls++
+			call.Index = &index
+			delta.ToolCalls = append(delta.ToolCalls, call)
+		}
+		chunk.Choices = append(chunk.Choices, ChatStreamChoice{Index: i, Delta: delta, FinishReason: reasons[i]})
+	}
+	return chunk, nil
+}
@@ -0,0 +1,243 @@
+package aiutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// =================================================================================
+// Ollama 原生 /api/chat 接口
+// =================================================================================
+
+// DefaultOllamaConfig 创建一个调用本地 Ollama 服务 (http://localhost:11434) 的配置
+func DefaultOllamaConfig() Config {
+	config := DefaultConfig("")
+	config.BaseURL = "http://localhost:11434"
+	config.DefaultEndpoint = "/api/chat"
+	config.DefaultHeaders = map[string]string{"Content-Type": "application/json"}
+	config.Provider = OllamaProvider{}
+	return config
+}
+
+// OllamaProvider 适配 Ollama 的原生 /api/chat 接口，流式响应为 NDJSON (每行一个 JSON 对象)。
+// 图片只支持 Data URL 形式的内联图片。Ollama 的工具调用没有 ID，
+// OllamaProvider 会为其生成 "call_{序号}" 形式的 ID，并在发送工具结果时根据 ID 找回函数名。
+type OllamaProvider struct{}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"` // Ollama 默认使用流式响应，必须显式设置
+	Tools    []Tool          `json:"tools,omitempty"`
+	Format   any             `json:"format,omitempty"` // "json" 或 JSON Schema
+	Think    bool            `json:"think,omitempty"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"` // base64 编码的图片
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// EncodeRequest 实现 Provider
+func (OllamaProvider) EncodeRequest(request ChatRequest) (string, any, error) {
+	req := ollamaRequest{Model: request.Model, Stream: request.Stream, Tools: request.Tools, Think: request.ReasoningEffort != ""}
+	names := make(map[string]string)
+	for _, msg := range request.Messages {
+		out := ollamaMessage{Role: msg.Role, Content: msg.Text()}
+		for _, part := range msg.MultiContent {
+			switch part.Type {
+			case ContentPartText:
+			case ContentPartImageURL:
+				_, data, ok := splitDataURL(part.ImageURL.URL)
+				if !ok {
+					return "", nil, fmt.Errorf("ollama: only data url images are supported")
+				}
+				out.Images = append(out.Images, data)
+			default:
+				return "", nil, fmt.Errorf("ollama: unsupported content part %q", part.Type)
+			}
+		}
+		for _, call := range msg.ToolCalls {
+			args, err := toolArguments(call.Function.Arguments)
+			if err != nil {
+				return "", nil, err
+			}
+			var oc ollamaToolCall
+			oc.Function.Name, oc.Function.Arguments = call.Function.Name, args
+			out.ToolCalls = append(out.ToolCalls, oc)
+		}
+		if msg.Role == RoleTool {
+			out.ToolName = names[msg.ToolCallID]
+		}
+		recordToolCalls(names, msg)
+		req.Messages = append(req.Messages, out)
+	}
+
+	if rf := request.ResponseFormat; rf != nil {
+		switch {
+		case rf.JSONSchema != nil:
+			req.Format = rf.JSONSchema.Schema
+		case rf.Type == ResponseFormatJSONObject:
+			req.Format = "json"
+		}
+	}
+
+	options := map[string]any{}
+	if request.Temperature != 0 {
+		options["temperature"] = request.Temperature
+	}
+	if request.TopP != 0 {
+		options["top_p"] = request.TopP
+	}
+	if request.MaxTokens > 0 {
+		options["num_predict"] = request.MaxTokens
+	}
+	if len(request.Stop) > 0 {
+		options["stop"] = request.Stop
+	}
+	if request.PresencePenalty != 0 {
+		options["presence_penalty"] = request.PresencePenalty
+	}
+	if request.FrequencyPenalty != 0 {
+		options["frequency_penalty"] = request.FrequencyPenalty
+	}
+	if len(options) > 0 {
+		req.Options = options
+	}
+	return "/api/chat", req, nil
+}
+
+// convert 将 Ollama 的消息转换为 ChatMessage，callOffset 是已经生成的工具调用 ID 数
+func (r *ollamaResponse) convert(callOffset int) ChatMessage {
+	msg := ChatMessage{Role: RoleAssistant, Content: r.Message.Content, ReasoningContent: r.Message.Thinking}
+	for i, call := range r.Message.ToolCalls {
+		args := string(call.Function.Arguments)
+		if args == "" || args == "null" {
+			args = "{}"
+		}
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+			ID:       fmt.Sprintf("call_%d", callOffset+i),
+			Type:     "function",
+			Function: FunctionCall{Name: call.Function.Name, Arguments: args},
+		})
+	}
+	return msg
+}
+
+func (r *ollamaResponse) created() int64 {
+	if r.CreatedAt.IsZero() {
+		return 0
+	}
+	return r.CreatedAt.Unix()
+}
+
+func (r *ollamaResponse) usage() Usage {
+	return Usage{
+		PromptTokens:     r.PromptEvalCount,
+		CompletionTokens: r.EvalCount,
+		TotalTokens:      r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+func (r *ollamaResponse) finishReason(hasToolCalls bool) string {
+	if !r.Done {
+		return ""
+	}
+	switch {
+	case hasToolCalls:
+		return "tool_calls"
+	case r.DoneReason == "length":
+		return "length"
+	}
+	return "stop"
+}
+
+// DecodeResponse 实现 Provider
+func (OllamaProvider) DecodeResponse(body io.Reader) (*ChatResponse, error) {
+	var resp ollamaResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	msg := resp.convert(0)
+	return &ChatResponse{
+		Object:  "chat.completion",
+		Created: resp.created(),
+		Model:   resp.Model,
+		Choices: []ChatChoice{{Message: msg, FinishReason: resp.finishReason(len(msg.ToolCalls) > 0)}},
+		Usage:   resp.usage(),
+	}, nil
+}
+
+// NewStreamDecoder 实现 Provider，解码 NDJSON 流，done 为 true 的一行是最后一个数据块
+func (OllamaProvider) NewStreamDecoder(body io.Reader) StreamDecoder {
+	return &ollamaStreamDecoder{lines: newNDJSONReader(body)}
+}
+
+type ollamaStreamDecoder struct {
+	lines *ndjsonReader
+	calls int
+	done  bool
+}
+
+func (d *ollamaStreamDecoder) Next() (*ChatStreamResponse, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	line, err := d.lines.next()
+	if err != nil {
+		return nil, err
+	}
+	var resp ollamaResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("error unmarshalling stream chunk: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, &APIError{Message: resp.Error, Body: line}
+	}
+	d.done = resp.Done
+
+	msg := resp.convert(d.calls)
+	delta := ChatDelta{Content: msg.Content, ReasoningContent: msg.ReasoningContent}
+	for _, call := range msg.ToolCalls {
+		index := d.calls
+		d.calls++
+		call.Index = &index
+		delta.ToolCalls = append(delta.ToolCalls, call)
+	}
+	chunk := &ChatStreamResponse{
+		Object:  "chat.completion.chunk",
+		Created: resp.created(),
+		Model:   resp.Model,
+		Choices: []ChatStreamChoice{{Delta: delta, FinishReason: resp.finishReason(d.calls > 0)}},
+	}
+	if resp.Done {
+		usage := resp.usage()
+		chunk.Usage = &usage
+	}
+	return chunk, nil
+}
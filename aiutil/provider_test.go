@@ -0,0 +1,335 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Bronya0/go-utils/sseutil"
+)
+
+// collectStream 读取流中的全部事件，返回拼接的内容和遇到的错误
+func collectStream(t *testing.T, stream <-chan StreamEvent) (string, []error) {
+	t.Helper()
+	var content strings.Builder
+	var errs []error
+	for event := range stream {
+		if event.Error != nil {
+			errs = append(errs, event.Error)
+			continue
+		}
+		if len(event.Data.Choices) > 0 {
+			content.WriteString(event.Data.Choices[0].Delta.Content)
+		}
+	}
+	return content.String(), errs
+}
+
+// toolRoundMessages 返回一段包含工具调用及其结果的对话
+func toolRoundMessages() []ChatMessage {
+	return []ChatMessage{
+		{Role: RoleSystem, Content: "你是助手"},
+		{Role: RoleUser, Content: "北京天气？"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_0", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"北京"}`}}}},
+		ToolMessage("call_0", "晴"),
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" {
+			t.Errorf("期望请求 /messages，实际 %s", r.URL.Path)
+		}
+		payload := decodeRequest(t, r)
+		if payload["system"] != "你是助手" || payload["max_tokens"] != float64(DefaultAnthropicMaxTokens) {
+			t.Errorf("system 或 max_tokens 转换错误: %v", payload)
+		}
+		messages, _ := payload["messages"].([]any)
+		if len(messages) != 3 {
+			t.Fatalf("期望 3 条消息 (user, assistant, user)，实际 %d", len(messages))
+		}
+		b, _ := json.Marshal(messages[1:]) // 重新序列化后字段按字母顺序排列
+		if !strings.Contains(string(b), `{"id":"call_0","input":{"city":"北京"},"name":"get_weather","type":"tool_use"}`) ||
+			!strings.Contains(string(b), `{"content":"晴","tool_use_id":"call_0","type":"tool_result"}`) {
+			t.Errorf("工具调用转换错误: %s", b)
+		}
+
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, ev := range []string{
+				`event: message_start` + "\n" + `data: {"type":"message_start","message":{"id":"msg_1","model":"claude"}}`,
+				`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"北京"}}`,
+				`event: ping` + "\n" + `data: {"type":"ping"}`,
+				`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"晴天"}}`,
+				`event: message_delta` + "\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn"}}`,
+				`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+			} {
+				fmt.Fprint(w, ev+"\n\n")
+			}
+			return
+		}
+		fmt.Fprint(w, `{"id":"msg_1","model":"claude","content":[{"type":"text","text":"北京晴天"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":4}}`)
+	}))
+	client.config.Provider = AnthropicProvider{}
+	request := ChatRequest{Model: "claude", Messages: toolRoundMessages()}
+
+	resp, err := client.doChatCompletion(context.Background(), request)
+	if err != nil {
+		t.Fatalf("同步请求失败: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "北京晴天" || resp.Choices[0].FinishReason != "stop" || resp.Usage.TotalTokens != 14 {
+		t.Errorf("响应转换错误: %+v", resp)
+	}
+
+	stream, err := client.doSSEStream(context.Background(), request, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	if content, errs := collectStream(t, stream); content != "北京晴天" || len(errs) > 0 {
+		t.Errorf("流式内容错误: %q, %v", content, errs)
+	}
+}
+
+func TestAnthropicProvider_StreamToolUse(t *testing.T) {
+	body := strings.Join([]string{
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"查询中"}}`,
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"北京\"}"}}`,
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`data: {"type":"message_stop"}`,
+	}, "\n\n")
+
+	decoder := AnthropicProvider{}.NewStreamDecoder(strings.NewReader(body))
+	var acc StreamAccumulator
+	for {
+		chunk, err := decoder.Next()
+		if err != nil {
+			break
+		}
+		acc.Add(*chunk)
+	}
+	resp := acc.Response()
+	calls, finish := resp.Choices[0].Message.ToolCalls, resp.Choices[0].FinishReason
+	if len(calls) != 1 || calls[0].ID != "toolu_1" || calls[0].Function.Arguments != `{"city":"北京"}` || finish != "tool_calls" {
+		t.Errorf("工具调用拼接错误: %+v, finish=%s", calls, finish)
+	}
+	if resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 20 || resp.Usage.TotalTokens != 32 {
+		t.Errorf("用量转换错误: %+v", resp.Usage)
+	}
+}
+
+func TestGeminiProvider(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := decodeRequest(t, r)
+		b, _ := json.Marshal(payload)
+		if !strings.Contains(string(b), `"systemInstruction":{"parts":[{"text":"你是助手"}]}`) ||
+			!strings.Contains(string(b), `"functionResponse":{"name":"get_weather","response":{"content":"晴"}}`) {
+			t.Errorf("请求转换错误: %s", b)
+		}
+
+		switch r.URL.Path {
+		case "/models/gemini-pro:generateContent":
+			fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_time","args":{}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":1,"totalTokenCount":6}}`)
+		case "/models/gemini-pro:streamGenerateContent":
+			if r.URL.Query().Get("alt") != "sse" {
+				t.Error("流式请求应使用 alt=sse")
+			}
+			fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"北京\"}]}}]}\n\n")
+			fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"晴天\"}]},\"finishReason\":\"STOP\"}]}\n\n")
+		default:
+			t.Errorf("未知路径 %s", r.URL.Path)
+		}
+	}))
+	client.config.Provider = GeminiProvider{}
+	request := ChatRequest{Model: "gemini-pro", Messages: toolRoundMessages()}
+
+	resp, err := client.doChatCompletion(context.Background(), request)
+	if err != nil {
+		t.Fatalf("同步请求失败: %v", err)
+	}
+	msg := resp.Choices[0].Message
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Name != "get_time" || msg.ToolCalls[0].ID != "call_0" ||
+		resp.Choices[0].FinishReason != "tool_calls" || resp.Usage.TotalTokens != 6 {
+		t.Errorf("响应转换错误: %+v", resp)
+	}
+
+	stream, err := client.doSSEStream(context.Background(), request, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	if content, errs := collectStream(t, stream); content != "北京晴天" || len(errs) > 0 {
+		t.Errorf("流式内容错误: %q, %v", content, errs)
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("期望请求 /api/chat，实际 %s", r.URL.Path)
+		}
+		payload := decodeRequest(t, r)
+		messages, _ := payload["messages"].([]any)
+		last, _ := messages[len(messages)-1].(map[string]any)
+		if last["tool_name"] != "get_weather" {
+			t.Errorf("tool 消息应携带 tool_name: %v", last)
+		}
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"北京"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"晴天"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`)
+			return
+		}
+		fmt.Fprint(w, `{"model":"llama3","message":{"role":"assistant","content":"北京晴天"},"done":true,"prompt_eval_count":5,"eval_count":2}`)
+	}))
+	client.config.Provider = OllamaProvider{}
+	request := ChatRequest{Model: "llama3", Messages: toolRoundMessages()}
+
+	resp, err := client.doChatCompletion(context.Background(), request)
+	if err != nil {
+		t.Fatalf("同步请求失败: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "北京晴天" || resp.Usage.TotalTokens != 7 {
+		t.Errorf("响应转换错误: %+v", resp)
+	}
+
+	stream, err := client.doSSEStream(context.Background(), request, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	if content, errs := collectStream(t, stream); content != "北京晴天" || len(errs) > 0 {
+		t.Errorf("流式内容错误: %q, %v", content, errs)
+	}
+}
+
+func TestWebSocketStream_RequiresOpenAIProvider(t *testing.T) {
+	client := NewClient(DefaultAnthropicConfig("key"))
+	if _, err := client.CreateChatCompletionWebSocketStream(context.Background(), ChatRequest{Model: "claude"}); err == nil {
+		t.Error("非 OpenAI 格式应拒绝 WebSocket 流")
+	}
+}
+
+func TestOpenAIProvider_StreamErrorEvents(t *testing.T) {
+	big := strings.Repeat("长", 40*1024) // 单个数据块超过 64KB
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": OPENROUTER PROCESSING\n\n")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"%s\"}}]}\n\n", big)
+		fmt.Fprint(w, "event: error\ndata: {\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+		fmt.Fprint(w, "data: {\"error\":{\"code\":\"server_error\",\"message\":\"upstream failed\"}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+
+	stream, err := client.doSSEStream(context.Background(), ChatRequest{Model: "gpt"}, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	content, errs := collectStream(t, stream)
+	if content != big {
+		t.Errorf("超过 64KB 的数据块读取错误，长度 %d", len(content))
+	}
+	if len(errs) != 2 {
+		t.Fatalf("期望 2 个流错误，实际 %v", errs)
+	}
+	var apiErr *APIError
+	if !errors.As(errs[0], &apiErr) || apiErr.Type != "overloaded_error" || apiErr.Message != "Overloaded" {
+		t.Errorf("error 事件应转换为 APIError: %v", errs[0])
+	}
+	if !errors.As(errs[1], &apiErr) || apiErr.Code != "server_error" {
+		t.Errorf("带 error 字段的数据块应转换为 APIError: %v", errs[1])
+	}
+}
+
+func TestOpenAIProvider_MaxEventSize(t *testing.T) {
+	body := "data: {\"choices\":[{\"delta\":{\"content\":\"" + strings.Repeat("x", 2048) + "\"}}]}\n\n"
+	decoder := OpenAIProvider{MaxEventSize: 1024}.NewStreamDecoder(strings.NewReader(body))
+	if _, err := decoder.Next(); !errors.Is(err, sseutil.ErrEventTooLarge) {
+		t.Fatalf("期望 ErrEventTooLarge，实际 %v", err)
+	}
+	if _, err := decoder.Next(); err != io.EOF {
+		t.Errorf("读取失败后应返回 io.EOF，实际 %v", err)
+	}
+}
+
+func TestProviders_Reasoning(t *testing.T) {
+	request := ChatRequest{Model: "m", ReasoningEffort: ReasoningEffortMedium, Temperature: 0.5, Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}
+
+	t.Run("anthropic", func(t *testing.T) {
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload := decodeRequest(t, r)
+			thinking, _ := payload["thinking"].(map[string]any)
+			if thinking["budget_tokens"] != float64(4096) || payload["max_tokens"] != float64(DefaultAnthropicMaxTokens+4096) || payload["temperature"] != nil {
+				t.Errorf("扩展思考参数转换错误: %v", payload)
+			}
+			if payload["stream"] == true {
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\"}}\n\n")
+				fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"嗯\"}}\n\n")
+				fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"好\"}}\n\n")
+				fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+				return
+			}
+			fmt.Fprint(w, `{"id":"msg_1","content":[{"type":"thinking","thinking":"嗯","signature":"sig"},{"type":"text","text":"好"}],"stop_reason":"end_turn"}`)
+		}))
+		client.config.Provider = AnthropicProvider{}
+		checkReasoningReply(t, client, request)
+	})
+
+	t.Run("gemini", func(t *testing.T) {
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload := decodeRequest(t, r)
+			cfg, _ := payload["generationConfig"].(map[string]any)
+			thinking, _ := cfg["thinkingConfig"].(map[string]any)
+			if thinking["thinkingBudget"] != float64(4096) || thinking["includeThoughts"] != true {
+				t.Errorf("思考参数转换错误: %v", cfg)
+			}
+			body := `{"candidates":[{"content":{"parts":[{"text":"嗯","thought":true},{"text":"好"}]},"finishReason":"STOP"}]}`
+			if strings.Contains(r.URL.Path, "stream") {
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "data: "+body+"\n\n")
+				return
+			}
+			fmt.Fprint(w, body)
+		}))
+		client.config.Provider = GeminiProvider{}
+		checkReasoningReply(t, client, request)
+	})
+
+	t.Run("ollama", func(t *testing.T) {
+		client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload := decodeRequest(t, r)
+			if payload["think"] != true {
+				t.Errorf("应开启思考模式: %v", payload)
+			}
+			fmt.Fprint(w, `{"message":{"role":"assistant","thinking":"嗯","content":"好"},"done":true}`+"\n")
+		}))
+		client.config.Provider = OllamaProvider{}
+		checkReasoningReply(t, client, request)
+	})
+}
+
+// checkReasoningReply 检查同步和流式回复都将思考过程解析为 ReasoningContent
+func checkReasoningReply(t *testing.T, client *Client, request ChatRequest) {
+	t.Helper()
+	ctx := context.Background()
+	resp, err := client.doChatCompletion(ctx, request)
+	if err != nil || resp.Choices[0].Message.Content != "好" || resp.Choices[0].Message.ReasoningContent != "嗯" {
+		t.Errorf("同步回复的思考过程解析错误: %+v, %v", resp, err)
+	}
+	stream, err := client.doSSEStream(ctx, request, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	resp, err = CollectStream(stream)
+	if err != nil || resp.Choices[0].Message.Content != "好" || resp.Choices[0].Message.ReasoningContent != "嗯" {
+		t.Errorf("流式回复的思考过程解析错误: %+v, %v", resp, err)
+	}
+}
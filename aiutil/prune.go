@@ -0,0 +1,425 @@
+package aiutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// =================================================================================
+// 历史截断策略 (HistoryPruner)
+// =================================================================================
+
+// PruneRequest 是一次历史截断的输入
+type PruneRequest struct {
+	History []ChatMessage         // 会话的全部历史记录，按时间顺序
+	Budget  int                   // 历史记录可使用的 Token 数 (已扣除新消息和回复预留)
+	Count   func(ChatMessage) int // 计算单条消息的 Token 数
+	Client  *Client               // 发起请求的客户端，可用于调用模型生成摘要
+	Model   string                // 本次请求使用的模型
+}
+
+// HistoryPruner 决定在 Token 预算内把哪些历史消息发送给模型。
+// 返回的消息必须保持原有顺序，实现可以插入新的消息 (例如摘要)。
+type HistoryPruner interface {
+	Prune(ctx context.Context, req PruneRequest) ([]ChatMessage, error)
+}
+
+// indexPruner 由只保留原有消息的内置策略实现，返回被保留的消息在 History 中的下标 (升序)，
+// SummaryPruner 据此准确地找出被丢弃的消息，不受内容重复的消息影响
+type indexPruner interface {
+	pruneIndices(ctx context.Context, req PruneRequest) ([]int, error)
+}
+
+// RecentPruner 从最新的消息开始向前保留，直到用完 Token 预算。它是默认的截断策略。
+//
+// 无论如何配置，结果都不会以 tool 消息开头，避免发出缺少对应工具调用的非法上下文。
+type RecentPruner struct {
+	// PinSystem 为 true 时始终保留所有 system 消息，其余消息使用剩余的预算
+	PinSystem bool
+	// WholeTurns 为 true 时以 "轮" 为单位丢弃消息，不会把一问一答拆开。
+	// 每条 user 消息开启新的一轮，其后的 assistant、tool 消息属于同一轮。
+	WholeTurns bool
+}
+
+// Prune 实现 HistoryPruner
+func (p RecentPruner) Prune(ctx context.Context, req PruneRequest) ([]ChatMessage, error) {
+	return pruneByIndices(ctx, p, req)
+}
+
+func (p RecentPruner) pruneIndices(ctx context.Context, req PruneRequest) ([]int, error) {
+	sel := newPruneSelection(req, p.PinSystem)
+
+	var start int
+	if p.WholeTurns {
+		start = keepRecentTurns(sel.rest, sel.budget, req.Count)
+	} else {
+		start = keepRecent(sel.rest, sel.budget, req.Count)
+	}
+	sel.keepRange(start, len(sel.rest))
+	return sel.indices(), nil
+}
+
+// FirstLastPruner 保留最早的 First 条消息 (通常是设定背景的开场) 和最近的至多 Last 条消息，
+// 丢弃中间的部分。两部分都受 Token 预算限制，预算不足时优先保证最早的消息。
+// 最早的部分以完整的工具调用结束: 第 First 条消息是工具调用时会多保留其后的 tool 消息，预算不足时整体丢弃。
+// Last 为负数时不限制最近消息的条数，只受预算限制。
+type FirstLastPruner struct {
+	First int
+	Last  int
+	// PinSystem 为 true 时额外始终保留所有 system 消息
+	PinSystem bool
+}
+
+// Prune 实现 HistoryPruner
+func (p FirstLastPruner) Prune(ctx context.Context, req PruneRequest) ([]ChatMessage, error) {
+	return pruneByIndices(ctx, p, req)
+}
+
+func (p FirstLastPruner) pruneIndices(ctx context.Context, req PruneRequest) ([]int, error) {
+	sel := newPruneSelection(req, p.PinSystem)
+	budget := sel.budget
+
+	// 1. 最早的 First 条消息
+	first := 0
+	for first < len(sel.rest) && first < p.First {
+		tokens := req.Count(sel.rest[first])
+		if tokens > budget {
+			break
+		}
+		budget -= tokens
+		first++
+	}
+	// 最早的部分不能在工具调用和它的结果之间截断: 尽量带上紧随其后的 tool 消息，
+	// 预算不足时连同发起调用的 assistant 消息一起丢弃
+	for first < len(sel.rest) && sel.rest[first].Role == RoleTool {
+		tokens := req.Count(sel.rest[first])
+		if tokens > budget {
+			break
+		}
+		budget -= tokens
+		first++
+	}
+	if first < len(sel.rest) && sel.rest[first].Role == RoleTool {
+		for first > 0 && (sel.rest[first-1].Role == RoleTool || len(sel.rest[first-1].ToolCalls) > 0) {
+			first--
+			budget += req.Count(sel.rest[first])
+		}
+	}
+	sel.keepRange(0, first)
+
+	// 2. 最近的至多 Last 条消息，不与前一部分重叠
+	tailStart := first
+	if p.Last >= 0 && len(sel.rest)-tailStart > p.Last {
+		tailStart = len(sel.rest) - p.Last
+	}
+	start := tailStart + keepRecent(sel.rest[tailStart:], budget, req.Count)
+	sel.keepRange(start, len(sel.rest))
+	return sel.indices(), nil
+}
+
+// DefaultSummaryPrompt 是 SummaryPruner 默认的摘要指令
+const DefaultSummaryPrompt = "请将以下对话内容压缩为一段简洁的摘要，保留关键事实、用户的偏好和尚未解决的问题，直接输出摘要正文。"
+
+// DefaultSummaryTokens 是 SummaryPruner 默认为摘要预留的 Token 数
+const DefaultSummaryTokens = 512
+
+// DefaultSummaryCacheSize 是 SummaryPruner 默认缓存的摘要条数
+const DefaultSummaryCacheSize = 128
+
+// SummaryPruner 在 Base 策略丢弃消息时，调用模型把被丢弃的消息压缩成一条摘要，
+// 以 system 消息的形式插入在保留的 system 消息之后，使模型仍能了解更早的上下文。
+//
+// 相同的被丢弃内容只会生成一次摘要，最近使用的摘要按 LRU 缓存在内存中。摘要只用于构造请求，不会写回历史存储。
+type SummaryPruner struct {
+	// Base 决定保留哪些消息，为空时使用 RecentPruner{PinSystem: true, WholeTurns: true}。
+	// 内置的策略按下标区分保留和丢弃的消息；自定义的策略按内容从最新的消息开始匹配，
+	// 内容完全相同的消息会被视为保留了较新的一条
+	Base HistoryPruner
+	// Model 是生成摘要使用的模型，为空时使用本次请求的模型
+	Model string
+	// Prompt 是生成摘要的指令，为空时使用 DefaultSummaryPrompt
+	Prompt string
+	// MaxSummaryTokens 是为摘要预留的 Token 数，为 0 时使用 DefaultSummaryTokens
+	MaxSummaryTokens int
+	// CacheSize 是缓存的摘要条数，多个会话共用一个 SummaryPruner 时超出后淘汰最久未使用的摘要，
+	// <=0 时使用 DefaultSummaryCacheSize
+	CacheSize int
+
+	once  sync.Once
+	cache *MemoryCacheStore
+}
+
+// Prune 实现 HistoryPruner
+func (p *SummaryPruner) Prune(ctx context.Context, req PruneRequest) ([]ChatMessage, error) {
+	base := p.Base
+	if base == nil {
+		base = RecentPruner{PinSystem: true, WholeTurns: true}
+	}
+
+	// 1. 预算充足时无需摘要
+	kept, err := base.Prune(ctx, req)
+	if err != nil || len(kept) == len(req.History) {
+		return kept, err
+	}
+
+	// 2. 为摘要预留空间后重新截断，并找出被丢弃的消息
+	reserve := p.MaxSummaryTokens
+	if reserve <= 0 {
+		reserve = DefaultSummaryTokens
+	}
+	reduced := req
+	reduced.Budget -= reserve
+	kept, dropped, err := splitHistory(ctx, base, reduced)
+	if err != nil {
+		return nil, err
+	}
+	if len(dropped) == 0 {
+		return kept, nil
+	}
+
+	// 3. 生成摘要，插入在开头的 system 消息之后
+	summary, err := p.summarize(ctx, req, dropped, reserve)
+	if err != nil {
+		return nil, err
+	}
+	summaryMsg := ChatMessage{Role: RoleSystem, Content: "此前对话的摘要：\n" + summary}
+	pos := 0
+	for pos < len(kept) && kept[pos].Role == RoleSystem {
+		pos++
+	}
+	result := make([]ChatMessage, 0, len(kept)+1)
+	result = append(result, kept[:pos]...)
+	result = append(result, summaryMsg)
+	result = append(result, kept[pos:]...)
+	return result, nil
+}
+
+// summarize 调用模型生成摘要，结果按被丢弃消息的内容缓存
+func (p *SummaryPruner) summarize(ctx context.Context, req PruneRequest, dropped []ChatMessage, maxTokens int) (string, error) {
+	b, err := json.Marshal(dropped)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal dropped messages: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	key := hex.EncodeToString(sum[:])
+
+	p.once.Do(func() {
+		size := p.CacheSize
+		if size <= 0 {
+			size = DefaultSummaryCacheSize
+		}
+		p.cache = NewMemoryCacheStore(size)
+	})
+	if cached, ok, _ := p.cache.Get(ctx, key); ok {
+		return string(cached), nil
+	}
+
+	if req.Client == nil {
+		return "", fmt.Errorf("summary pruner requires a client")
+	}
+	var transcript strings.Builder
+	for _, msg := range dropped {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Text())
+	}
+	model := p.Model
+	if model == "" {
+		model = req.Model
+	}
+	prompt := p.Prompt
+	if prompt == "" {
+		prompt = DefaultSummaryPrompt
+	}
+
+	// 经过中间件直接发送请求，摘要本身不进入任何会话的历史记录
+	resp, err := req.Client.chat(ctx, ChatRequest{
+		Model: model,
+		Messages: []ChatMessage{
+			{Role: RoleSystem, Content: prompt},
+			{Role: RoleUser, Content: transcript.String()},
+		},
+		MaxTokens: maxTokens,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize history: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("failed to summarize history: empty response")
+	}
+	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+	_ = p.cache.Set(ctx, key, []byte(summary), 0)
+	return summary, nil
+}
+
+// pruneSelection 记录一次截断中 history 的哪些消息被保留
+type pruneSelection struct {
+	history []ChatMessage
+	keep    []bool
+	rest    []ChatMessage // 参与截断的消息 (固定保留的 system 消息除外)
+	restIdx []int         // rest 中每条消息在 history 中的下标
+	budget  int           // 扣除固定消息后剩余的预算
+}
+
+// newPruneSelection 在 pinSystem 为 true 时固定保留所有 system 消息，并从预算中扣除它们的 Token 数
+func newPruneSelection(req PruneRequest, pinSystem bool) *pruneSelection {
+	sel := &pruneSelection{
+		history: req.History,
+		keep:    make([]bool, len(req.History)),
+		budget:  req.Budget,
+	}
+	for i, msg := range req.History {
+		if pinSystem && msg.Role == RoleSystem {
+			sel.keep[i] = true
+			sel.budget -= req.Count(msg)
+			continue
+		}
+		sel.rest = append(sel.rest, msg)
+		sel.restIdx = append(sel.restIdx, i)
+	}
+	return sel
+}
+
+// keepRange 保留 rest[from:to]
+func (s *pruneSelection) keepRange(from, to int) {
+	for j := from; j < to; j++ {
+		s.keep[s.restIdx[j]] = true
+	}
+}
+
+// indices 按升序返回被保留的消息在 history 中的下标
+func (s *pruneSelection) indices() []int {
+	indices := make([]int, 0, len(s.history))
+	for i, keep := range s.keep {
+		if keep {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// pruneByIndices 按 p 返回的下标取出被保留的消息
+func pruneByIndices(ctx context.Context, p indexPruner, req PruneRequest) ([]ChatMessage, error) {
+	indices, err := p.pruneIndices(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]ChatMessage, 0, len(indices))
+	for _, i := range indices {
+		kept = append(kept, req.History[i])
+	}
+	return kept, nil
+}
+
+// keepRecent 从最新的消息开始向前保留，直到超出预算，返回保留部分的起始下标
+func keepRecent(messages []ChatMessage, budget int, count func(ChatMessage) int) int {
+	used := 0
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		tokens := count(messages[i])
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start = i
+	}
+	return skipLeadingToolMessages(messages, start)
+}
+
+// keepRecentTurns 以轮为单位从最新的一轮开始向前保留，直到超出预算，返回保留部分的起始下标
+func keepRecentTurns(messages []ChatMessage, budget int, count func(ChatMessage) int) int {
+	used := 0
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		// 每条 user 消息 (或第一条消息) 是一轮的开始
+		if messages[i].Role != RoleUser && i > 0 {
+			continue
+		}
+		tokens := 0
+		for _, msg := range messages[i:start] {
+			tokens += count(msg)
+		}
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start = i
+	}
+	return skipLeadingToolMessages(messages, start)
+}
+
+// skipLeadingToolMessages 跳过保留部分开头缺少对应工具调用的 tool 消息
+func skipLeadingToolMessages(messages []ChatMessage, start int) int {
+	for start < len(messages) && messages[start].Role == RoleTool {
+		start++
+	}
+	return start
+}
+
+// splitHistory 使用 base 截断 req.History，返回保留的消息和被丢弃的消息
+func splitHistory(ctx context.Context, base HistoryPruner, req PruneRequest) (kept, dropped []ChatMessage, err error) {
+	// 1. 自定义策略: 保留其返回的消息 (可能包含插入的新消息)，按内容找出被丢弃的消息
+	p, ok := base.(indexPruner)
+	if !ok {
+		if kept, err = base.Prune(ctx, req); err != nil {
+			return nil, nil, err
+		}
+		keep, err := matchKept(req.History, kept)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, msg := range req.History {
+			if !keep[i] {
+				dropped = append(dropped, msg)
+			}
+		}
+		return kept, dropped, nil
+	}
+
+	// 2. 内置策略: 按下标区分
+	indices, err := p.pruneIndices(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	next := 0
+	for i, msg := range req.History {
+		if next < len(indices) && indices[next] == i {
+			kept = append(kept, msg)
+			next++
+			continue
+		}
+		dropped = append(dropped, msg)
+	}
+	return kept, dropped, nil
+}
+
+// matchKept 返回 history 中的每条消息是否出现在 kept 中，用于无法给出下标的自定义策略。
+// 消息按序列化后的内容比较，从末尾开始匹配，因此内容重复时视为保留了较新的一条
+func matchKept(history, kept []ChatMessage) ([]bool, error) {
+	keep := make([]bool, len(history))
+	k := len(kept) - 1
+	var want []byte
+	for i := len(history) - 1; i >= 0 && k >= 0; i-- {
+		if want == nil {
+			b, err := json.Marshal(kept[k])
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal kept message: %w", err)
+			}
+			want = b
+		}
+		b, err := json.Marshal(history[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal history message: %w", err)
+		}
+		if bytes.Equal(b, want) {
+			keep[i] = true
+			k--
+			want = nil
+		}
+	}
+	return keep, nil
+}
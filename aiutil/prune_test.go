@@ -0,0 +1,235 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countBytes 按内容字节数计数，便于精确构造测试用例
+func countBytes(msg ChatMessage) int { return len(msg.Content) }
+
+// contents 提取消息内容，便于比较
+func contents(msgs []ChatMessage) []string {
+	result := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		result = append(result, msg.Content)
+	}
+	return result
+}
+
+func prune(t *testing.T, p HistoryPruner, history []ChatMessage, budget int) []string {
+	t.Helper()
+	kept, err := p.Prune(context.Background(), PruneRequest{History: history, Budget: budget, Count: countBytes})
+	if err != nil {
+		t.Fatalf("Prune 失败: %v", err)
+	}
+	return contents(kept)
+}
+
+var pruneHistoryFixture = []ChatMessage{
+	{Role: RoleSystem, Content: "sys"},
+	{Role: RoleUser, Content: "u1"},
+	{Role: RoleAssistant, Content: "a1----"},
+	{Role: RoleUser, Content: "u2"},
+	{Role: RoleAssistant, Content: "a2"},
+}
+
+func TestRecentPruner(t *testing.T) {
+	tests := []struct {
+		name   string
+		pruner RecentPruner
+		budget int
+		want   []string
+	}{
+		{"全部放得下", RecentPruner{}, 100, []string{"sys", "u1", "a1----", "u2", "a2"}},
+		{"保留最近的消息", RecentPruner{}, 10, []string{"a1----", "u2", "a2"}},
+		{"固定保留 system", RecentPruner{PinSystem: true}, 10, []string{"sys", "u2", "a2"}},
+		{"按轮丢弃", RecentPruner{WholeTurns: true}, 10, []string{"u2", "a2"}},
+		{"固定 system 且按轮丢弃", RecentPruner{PinSystem: true, WholeTurns: true}, 13, []string{"sys", "u2", "a2"}},
+		{"预算不足时只保留 system", RecentPruner{PinSystem: true}, 3, []string{"sys"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prune(t, tt.pruner, pruneHistoryFixture, tt.budget); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentPruner_SkipsOrphanToolMessages(t *testing.T) {
+	history := []ChatMessage{
+		{Role: RoleUser, Content: "u1"},
+		{Role: RoleAssistant, Content: "call-----", ToolCalls: []ToolCall{{ID: "c1"}}},
+		ToolMessage("c1", "r1"),
+		{Role: RoleAssistant, Content: "a1"},
+	}
+	// 预算只够最后两条，但 tool 消息的调用方已被丢弃，必须一起丢掉
+	if got := prune(t, RecentPruner{}, history, 5); !reflect.DeepEqual(got, []string{"a1"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFirstLastPruner(t *testing.T) {
+	history := []ChatMessage{
+		{Role: RoleUser, Content: "m1"},
+		{Role: RoleAssistant, Content: "m2"},
+		{Role: RoleUser, Content: "m3"},
+		{Role: RoleAssistant, Content: "m4"},
+		{Role: RoleUser, Content: "m5"},
+		{Role: RoleAssistant, Content: "m6"},
+	}
+	tests := []struct {
+		name   string
+		pruner FirstLastPruner
+		budget int
+		want   []string
+	}{
+		{"保留首尾", FirstLastPruner{First: 2, Last: 2}, 100, []string{"m1", "m2", "m5", "m6"}},
+		{"尾部受预算限制", FirstLastPruner{First: 2, Last: 3}, 6, []string{"m1", "m2", "m6"}},
+		{"Last 为负数时只受预算限制", FirstLastPruner{First: 1, Last: -1}, 8, []string{"m1", "m4", "m5", "m6"}},
+		{"首尾重叠", FirstLastPruner{First: 4, Last: 4}, 100, []string{"m1", "m2", "m3", "m4", "m5", "m6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prune(t, tt.pruner, history, tt.budget); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstLastPruner_WholeToolCalls(t *testing.T) {
+	history := []ChatMessage{
+		{Role: RoleUser, Content: "u1"},
+		{Role: RoleAssistant, Content: "c1", ToolCalls: []ToolCall{{ID: "c1"}, {ID: "c2"}}},
+		ToolMessage("c1", "r1"),
+		ToolMessage("c2", "r2"),
+		{Role: RoleAssistant, Content: "a1"},
+		{Role: RoleUser, Content: "u2"},
+		{Role: RoleAssistant, Content: "a2"},
+	}
+	// First 停在工具调用上时带上它的全部结果
+	if got := prune(t, FirstLastPruner{First: 2, Last: 2}, history, 100); !reflect.DeepEqual(got, []string{"u1", "c1", "r1", "r2", "u2", "a2"}) {
+		t.Errorf("应保留完整的工具调用: %q", got)
+	}
+	// 预算不足以带上全部结果时，连同工具调用一起丢弃
+	if got := prune(t, FirstLastPruner{First: 3, Last: 2}, history, 7); !reflect.DeepEqual(got, []string{"u1", "u2", "a2"}) {
+		t.Errorf("不应保留缺少结果的工具调用: %q", got)
+	}
+}
+
+func TestSummaryPruner(t *testing.T) {
+	var calls atomic.Int32
+	var summaryRequest ChatRequest
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if err := json.NewDecoder(r.Body).Decode(&summaryRequest); err != nil {
+			t.Errorf("解析请求体失败: %v", err)
+		}
+		writeChatResponse(w, "用户叫小明")
+	}))
+	before := len(client.GetHistory())
+
+	pruner := &SummaryPruner{MaxSummaryTokens: 5}
+	req := PruneRequest{
+		History: pruneHistoryFixture,
+		Budget:  12, // 全部需要 15；预留 5 后剩 7: sys + 最后一轮
+		Count:   countBytes,
+		Client:  client,
+		Model:   "test-model",
+	}
+	for i := 0; i < 2; i++ {
+		kept, err := pruner.Prune(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Prune 失败: %v", err)
+		}
+		got := contents(kept)
+		if len(got) != 4 || got[0] != "sys" || !strings.Contains(got[1], "用户叫小明") || got[2] != "u2" {
+			t.Fatalf("摘要结果错误: %q", got)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("相同的被丢弃内容只应摘要一次，实际调用 %d 次", calls.Load())
+	}
+	transcript := summaryRequest.Messages[len(summaryRequest.Messages)-1].Content
+	if !strings.Contains(transcript, "u1") || strings.Contains(transcript, "u2") {
+		t.Errorf("摘要请求应只包含被丢弃的消息: %q", transcript)
+	}
+	if summaryRequest.Model != "test-model" || summaryRequest.MaxTokens != 5 {
+		t.Errorf("摘要请求参数错误: %+v", summaryRequest)
+	}
+	if len(client.GetHistory()) != before {
+		t.Error("摘要请求不应写入会话历史")
+	}
+
+	// 预算充足时不应调用模型
+	req.Budget = 100
+	if _, err := pruner.Prune(context.Background(), req); err != nil || calls.Load() != 1 {
+		t.Errorf("预算充足时不应生成摘要, calls=%d, err=%v", calls.Load(), err)
+	}
+}
+
+// customPruner 包装一个 HistoryPruner，使 SummaryPruner 只能看到它返回的消息
+type customPruner struct{ HistoryPruner }
+
+func TestSummaryPruner_DuplicateMessages(t *testing.T) {
+	var transcript string
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		transcript = req.Messages[len(req.Messages)-1].Content
+		writeChatResponse(w, "摘要")
+	}))
+	// 两条内容相同的 user 消息，保留的是后一条
+	history := []ChatMessage{
+		{Role: RoleUser, Content: "ok"},
+		{Role: RoleAssistant, Content: "first"},
+		{Role: RoleUser, Content: "ok"},
+		{Role: RoleAssistant, Content: "second"},
+	}
+	want := "user: ok\nassistant: first\n"
+
+	for _, base := range []HistoryPruner{RecentPruner{}, customPruner{RecentPruner{}}} {
+		pruner := &SummaryPruner{Base: base, MaxSummaryTokens: 5}
+		// 全部需要 15；预留 5 后剩 8: 最后两条
+		kept, err := pruner.Prune(context.Background(), PruneRequest{History: history, Budget: 13, Count: countBytes, Client: client, Model: "test-model"})
+		if err != nil {
+			t.Fatalf("Prune 失败: %v", err)
+		}
+		if got := contents(kept); len(got) != 3 || got[1] != "ok" || got[2] != "second" {
+			t.Errorf("%T 保留的消息错误: %q", base, got)
+		}
+		if transcript != want {
+			t.Errorf("%T 摘要的应是被丢弃的前两条消息，实际 %q", base, transcript)
+		}
+	}
+}
+
+func TestSummaryPruner_CacheBounded(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeChatResponse(w, "摘要")
+	}))
+	pruner := &SummaryPruner{MaxSummaryTokens: 5, CacheSize: 1}
+
+	// 两个会话的被丢弃内容不同，容量为 1 时交替截断会互相淘汰
+	other := append([]ChatMessage(nil), pruneHistoryFixture...)
+	other[1].Content = "x1"
+	for _, history := range [][]ChatMessage{pruneHistoryFixture, other, pruneHistoryFixture} {
+		req := PruneRequest{History: history, Budget: 12, Count: countBytes, Client: client, Model: "test-model"}
+		if _, err := pruner.Prune(context.Background(), req); err != nil {
+			t.Fatalf("Prune 失败: %v", err)
+		}
+	}
+	if calls.Load() != 3 || pruner.cache.Len() != 1 {
+		t.Errorf("缓存应只保留 1 条摘要: calls=%d len=%d", calls.Load(), pruner.cache.Len())
+	}
+}
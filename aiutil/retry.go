@@ -0,0 +1,232 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bronya0/go-utils/uid"
+)
+
+// =================================================================================
+// 自动重试 (RetryPolicy)
+// =================================================================================
+
+// RetryPolicy 描述请求失败后的重试策略。
+//
+// 对话请求不是幂等的：服务端可能已经处理了请求 (并计费) 但响应丢失。因此默认只重试
+// 能确定服务端没有处理请求的失败：429、408、503 以及建立连接失败。
+// 其余的 5xx 和连接中断只有在 RetryUnsafe 或 IdempotencyKey 为 true 时才会重试。
+// 服务端返回的 "x-should-retry" 头优先于以上规则。
+type RetryPolicy struct {
+	// MaxAttempts 是包括第一次请求在内的最大尝试次数，<=1 表示不重试
+	MaxAttempts int
+	// InitialBackoff 是第一次重试前的等待时间
+	InitialBackoff time.Duration
+	// MaxBackoff 是指数退避的上限
+	MaxBackoff time.Duration
+	// Multiplier 是每次重试后等待时间的增长倍数
+	Multiplier float64
+	// Jitter 是随机抖动的比例 (0~1)，实际等待时间在 [d*(1-Jitter), d] 之间均匀分布
+	Jitter float64
+	// MaxRetryAfter 限制服务端通过 Retry-After 等响应头要求的等待时间，
+	// 超过该值时不再重试而是直接返回错误，0 表示不限制
+	MaxRetryAfter time.Duration
+	// RetryUnsafe 为 true 时也重试可能已被服务端处理的失败
+	RetryUnsafe bool
+	// IdempotencyKey 为 true 时为每个逻辑请求生成 Idempotency-Key 请求头 (所有重试共用)，
+	// 由服务端负责去重，所有可重试的失败都视为安全
+	IdempotencyKey bool
+}
+
+// DefaultRetryPolicy 返回一个适合批量任务的重试策略: 最多尝试 4 次，退避从 500ms 开始翻倍，最长 30s
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxRetryAfter:  2 * time.Minute,
+	}
+}
+
+// backoff 返回第 attempt 次失败后的指数退避时间 (attempt 从 1 开始)
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// retryPolicyKey 是 context 中保存覆盖 Config.Retry 的重试策略的键
+type retryPolicyKey struct{}
+
+// withRetryPolicy 使 ctx 发起的请求使用 policy 代替 Config.Retry
+func withRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// send 发送请求，并按照 Config.Retry (或 withRetryPolicy 指定的策略) 重试可恢复的失败。
+// 成功时返回状态码为 2xx 的响应，由调用方负责关闭响应体。
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	policy := c.config.Retry
+	if p, ok := req.Context().Value(retryPolicyKey{}).(*RetryPolicy); ok {
+		policy = p
+	}
+	if policy != nil && policy.MaxAttempts > 1 && policy.IdempotencyKey && req.Header.Get("Idempotency-Key") == "" {
+		req.Header.Set("Idempotency-Key", uid.NewULID())
+	}
+	idempotent := req.Header.Get("Idempotency-Key") != ""
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			// 请求体已被上一次尝试读取，需要重新创建
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("failed to rewind request body: %w", err)
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := c.httpClient.Do(attemptReq)
+		if err == nil && resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+			return resp, nil
+		}
+
+		var retryable bool
+		var wait time.Duration
+		if err != nil {
+			retryable = retryableNetworkError(err, idempotent || (policy != nil && policy.RetryUnsafe))
+			err = fmt.Errorf("http request failed: %w", err)
+		} else {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			apiErr := newAPIError(resp, bodyBytes)
+			retryable = retryableStatus(resp, apiErr, idempotent || (policy != nil && policy.RetryUnsafe))
+			wait = apiErr.RetryAfter
+			err = apiErr
+		}
+
+		if policy == nil || !retryable || attempt >= policy.MaxAttempts {
+			return nil, err
+		}
+		if wait > 0 && policy.MaxRetryAfter > 0 && wait > policy.MaxRetryAfter {
+			return nil, err
+		}
+		if wait <= 0 {
+			wait = policy.backoff(attempt)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (retry aborted: %v)", err, req.Context().Err())
+		}
+	}
+}
+
+// retryableStatus 判断一个失败的响应是否可以重试，unsafe 为 true 时允许重试可能已被处理的请求。
+// 额度用尽 (insufficient_quota) 虽然也是 429，但重试不会成功，因此不重试。
+func retryableStatus(resp *http.Response, apiErr *APIError, unsafe bool) bool {
+	switch strings.ToLower(resp.Header.Get("x-should-retry")) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if apiErr.isQuotaExceeded() {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusServiceUnavailable:
+		return true
+	case http.StatusConflict:
+		return unsafe
+	}
+	return resp.StatusCode >= http.StatusInternalServerError && unsafe
+}
+
+// retryableNetworkError 判断一个网络错误是否可以重试。
+// 建立连接失败时请求一定没有发出，总是可以重试；其余错误只有 unsafe 为 true 时才重试。
+func retryableNetworkError(err error, unsafe bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	return unsafe
+}
+
+// retryAfter 从响应头中解析服务端要求的等待时间，没有相关响应头时返回 0。
+// 支持 retry-after-ms、Retry-After (秒数或 HTTP 日期)，以及 OpenAI 风格的
+// x-ratelimit-reset-requests / x-ratelimit-reset-tokens (仅在对应的 remaining 为 0 时生效)。
+func retryAfter(header http.Header) time.Duration {
+	if v := header.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(time.Until(t), 0)
+		}
+	}
+
+	var wait time.Duration
+	for _, kind := range []string{"requests", "tokens"} {
+		if header.Get("x-ratelimit-remaining-"+kind) != "0" {
+			continue
+		}
+		if d := parseResetDuration(header.Get("x-ratelimit-reset-" + kind)); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// parseResetDuration 解析 "1s"、"6m0s"、"20ms" 形式的时长，也接受纯数字 (秒)
+func parseResetDuration(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d
+	}
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return 0
+}
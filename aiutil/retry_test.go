@@ -0,0 +1,237 @@
+package aiutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetryPolicy 返回一个几乎不等待的重试策略，便于测试
+func fastRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: maxAttempts, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Multiplier: 2}
+}
+
+// flakyHandler 前 failures 次请求返回 status，之后返回正常回复
+func flakyHandler(failures int32, status int, header http.Header) (http.Handler, *atomic.Int32) {
+	var calls atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "hi") {
+			http.Error(w, "empty body on retry", http.StatusBadRequest)
+			return
+		}
+		if calls.Add(1) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			http.Error(w, `{"error":{"message":"try later"}}`, status)
+			return
+		}
+		writeChatResponse(w, "ok")
+	}), &calls
+}
+
+func chatOnce(client *Client) error {
+	_, err := client.CreateChatCompletion(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}},
+	})
+	return err
+}
+
+func TestRetry_RateLimited(t *testing.T) {
+	handler, calls := flakyHandler(2, http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}})
+	client := newTestClient(t, handler)
+	client.config.Retry = fastRetryPolicy(3)
+
+	if err := chatOnce(client); err != nil {
+		t.Fatalf("429 应被重试直到成功: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("期望请求 3 次，实际 %d", calls.Load())
+	}
+}
+
+func TestRetry_QuotaExceeded(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`, http.StatusTooManyRequests)
+	}))
+	client.config.Retry = fastRetryPolicy(3)
+
+	if err := chatOnce(client); !IsQuotaExceeded(err) {
+		t.Fatalf("期望额度用尽错误，实际 %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("额度用尽时不应重试，实际请求 %d 次", calls.Load())
+	}
+}
+
+func TestRetry_MaxAttempts(t *testing.T) {
+	handler, calls := flakyHandler(10, http.StatusServiceUnavailable, nil)
+	client := newTestClient(t, handler)
+	client.config.Retry = fastRetryPolicy(2)
+
+	if err := chatOnce(client); err == nil || !strings.Contains(err.Error(), "try later") {
+		t.Fatalf("超过最大尝试次数后应返回最后一次的错误: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("期望请求 2 次，实际 %d", calls.Load())
+	}
+}
+
+func TestRetry_UnsafeStatus(t *testing.T) {
+	handler, calls := flakyHandler(1, http.StatusInternalServerError, nil)
+	client := newTestClient(t, handler)
+	client.config.Retry = fastRetryPolicy(3)
+
+	// 500 可能已被服务端处理，默认不重试
+	if err := chatOnce(client); err == nil {
+		t.Fatal("默认策略不应重试 500")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("期望只请求 1 次，实际 %d", calls.Load())
+	}
+
+	client.config.Retry.RetryUnsafe = true
+	if err := chatOnce(client); err != nil {
+		t.Fatalf("RetryUnsafe 时应重试 500: %v", err)
+	}
+}
+
+func TestRetry_ShouldRetryHeader(t *testing.T) {
+	handler, calls := flakyHandler(1, http.StatusTooManyRequests, http.Header{"X-Should-Retry": {"false"}})
+	client := newTestClient(t, handler)
+	client.config.Retry = fastRetryPolicy(3)
+
+	if err := chatOnce(client); err == nil || calls.Load() != 1 {
+		t.Errorf("x-should-retry: false 时不应重试, calls=%d, err=%v", calls.Load(), err)
+	}
+}
+
+func TestRetry_IdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+		if calls.Add(1) == 1 {
+			http.Error(w, "oops", http.StatusBadGateway)
+			return
+		}
+		writeChatResponse(w, "ok")
+	}))
+	client.config.Retry = fastRetryPolicy(3)
+	client.config.Retry.IdempotencyKey = true
+
+	if err := chatOnce(client); err != nil {
+		t.Fatalf("带 Idempotency-Key 时应重试 502: %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("所有重试应共用同一个 Idempotency-Key: %q", keys)
+	}
+}
+
+func TestRetry_SSEStreamInit(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		writeSSEChunks(w, "ok")
+	}))
+	client.config.Retry = fastRetryPolicy(2)
+
+	ch, err := client.CreateChatCompletionSSEStream(context.Background(), ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("流式请求建立前应重试: %v", err)
+	}
+	for event := range ch {
+		if event.Error != nil {
+			t.Fatalf("流式处理出错: %v", event.Error)
+		}
+	}
+}
+
+func TestRetry_ContextCanceledWhileWaiting(t *testing.T) {
+	handler, _ := flakyHandler(10, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+	client := newTestClient(t, handler)
+	client.config.Retry = fastRetryPolicy(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.CreateChatCompletion(ctx, ChatRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}})
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("context 取消后应立即停止等待, err=%v, elapsed=%v", err, time.Since(start))
+	}
+}
+
+func TestRetry_DialError(t *testing.T) {
+	// 占用一个端口后立即关闭，确保连接会被拒绝
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听端口失败: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	config := DefaultConfig("k")
+	config.BaseURL = "http://" + addr
+	config.Retry = fastRetryPolicy(2)
+	if err := chatOnce(NewClient(config)); err == nil || !strings.Contains(err.Error(), "http request failed") {
+		t.Errorf("连接失败应在重试后返回网络错误: %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"无相关响应头", http.Header{}, 0},
+		{"秒数", http.Header{"Retry-After": {"2"}}, 2 * time.Second},
+		{"毫秒", http.Header{"Retry-After-Ms": {"150"}}, 150 * time.Millisecond},
+		{"请求数耗尽", http.Header{"X-Ratelimit-Remaining-Requests": {"0"}, "X-Ratelimit-Reset-Requests": {"6m0s"}}, 6 * time.Minute},
+		{"Token 耗尽取较大者", http.Header{
+			"X-Ratelimit-Remaining-Requests": {"0"}, "X-Ratelimit-Reset-Requests": {"1s"},
+			"X-Ratelimit-Remaining-Tokens": {"0"}, "X-Ratelimit-Reset-Tokens": {"20ms"},
+		}, time.Second},
+		{"未耗尽时忽略", http.Header{"X-Ratelimit-Remaining-Tokens": {"10"}, "X-Ratelimit-Reset-Tokens": {"5s"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header); got != tt.want {
+				t.Errorf("retryAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	date := http.Header{"Retry-After": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}
+	if got := retryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("HTTP 日期格式解析错误: %v", got)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+	for attempt, base := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(attempt); d > base || d < base/2 {
+				t.Errorf("backoff(%d) = %v, 应在 [%v, %v] 之间", attempt, d, base/2, base)
+			}
+		}
+	}
+}
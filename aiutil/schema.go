@@ -0,0 +1,438 @@
+package aiutil
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =================================================================================
+// JSON Schema 生成与校验
+// =================================================================================
+
+// JSONSchema 是 JSON Schema 的一个子集，覆盖结构化输出和工具参数需要的关键字。
+// 序列化时 properties 按结构体字段的声明顺序输出，模型通常也会按该顺序生成字段。
+type JSONSchema struct {
+	Type        string // "object"、"array"、"string"、"integer"、"number"、"boolean"，为空表示任意类型
+	Nullable    bool   // 允许 null，序列化为 "type": ["string", "null"]
+	Description string
+	Format      string // 例如 "date-time"
+	Enum        []any
+	Properties  map[string]*JSONSchema
+	Required    []string
+	Items       *JSONSchema
+	// AdditionalProperties 为 false 时禁止未声明的字段，也可以是描述 map 值的 *JSONSchema
+	AdditionalProperties any
+
+	propertyOrder []string
+}
+
+// MarshalJSON 按固定顺序输出关键字，properties 按声明顺序输出
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	write := func(key string, value any) error {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		buf.WriteString(strconv.Quote(key))
+		buf.WriteByte(':')
+		buf.Write(b)
+		return nil
+	}
+
+	var err error
+	switch {
+	case s.Type != "" && s.Nullable:
+		err = write("type", []string{s.Type, "null"})
+	case s.Type != "":
+		err = write("type", s.Type)
+	}
+	if err == nil && s.Description != "" {
+		err = write("description", s.Description)
+	}
+	if err == nil && s.Format != "" {
+		err = write("format", s.Format)
+	}
+	if err == nil && len(s.Enum) > 0 {
+		enum := s.Enum
+		if s.Nullable {
+			enum = append(enum[:len(enum):len(enum)], nil)
+		}
+		err = write("enum", enum)
+	}
+	if err == nil && s.Properties != nil {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		buf.WriteString(`"properties":{`)
+		for i, name := range s.orderedProperties() {
+			b, err := json.Marshal(s.Properties[name])
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.Quote(name))
+			buf.WriteByte(':')
+			buf.Write(b)
+		}
+		buf.WriteByte('}')
+	}
+	if err == nil && s.Required != nil {
+		err = write("required", s.Required)
+	}
+	if err == nil && s.Items != nil {
+		err = write("items", s.Items)
+	}
+	if err == nil && s.AdditionalProperties != nil {
+		err = write("additionalProperties", s.AdditionalProperties)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// orderedProperties 先按声明顺序返回生成器记录的属性，再按字母顺序返回其余属性
+func (s *JSONSchema) orderedProperties() []string {
+	names := make([]string, 0, len(s.Properties))
+	seen := make(map[string]bool, len(s.Properties))
+	for _, name := range s.propertyOrder {
+		if _, ok := s.Properties[name]; ok && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	var rest []string
+	for name := range s.Properties {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// SchemaFor 根据类型 T 生成 JSON Schema，规则见 GenerateSchema
+func SchemaFor[T any]() (*JSONSchema, error) {
+	return GenerateSchema(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// GenerateSchema 通过反射根据 Go 类型生成 JSON Schema，结构体按照 OpenAI strict 模式的要求生成:
+//   - 字段名取自 json 标签，`json:"-"` 和未导出的字段被忽略，匿名嵌入的结构体会被展开
+//   - 所有字段都列入 required；指针字段和带 omitempty 的字段允许为 null
+//   - 结构体禁止未声明的字段 (additionalProperties: false)
+//   - `description:"..."` 标签设置字段说明，`enum:"a,b,c"` 标签限定可选值
+//
+// map 生成以值的 Schema 作为 additionalProperties 的对象，interface{} 和 json.RawMessage 生成不限类型的空 Schema，
+// 它们可以用于 Validate 和非 strict 的工具参数，但 strict 模式 (例如 CreateStructured) 会拒绝包含它们的 Schema，
+// 此时请改用结构体或由键值结构体组成的切片。
+// []byte 按 encoding/json 的规则生成 base64 字符串，[N]byte 数组生成整数数组。
+// 实现了 encoding.TextMarshaler 的类型生成字符串；实现了 json.Marshaler 的类型无法从结构推断输出格式，返回错误。
+// 不支持递归类型、chan、func 等无法用 JSON 表示的类型。
+func GenerateSchema(t reflect.Type) (*JSONSchema, error) {
+	return schemaForType(t, make(map[reflect.Type]bool))
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// implements 判断 t 或 *t 是否实现了接口 iface，encoding/json 对可寻址的值也会使用指针接收者的方法
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+func schemaForType(t reflect.Type, visiting map[reflect.Type]bool) (*JSONSchema, error) {
+	switch t {
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}, nil
+	case rawMessageType:
+		return &JSONSchema{}, nil
+	}
+	// 自定义编码的类型不按字段反射，指针和接口交给下面的规则处理
+	if k := t.Kind(); k != reflect.Pointer && k != reflect.Interface {
+		switch {
+		case implements(t, jsonMarshalerType):
+			return nil, fmt.Errorf("type %s implements json.Marshaler and is not supported", t)
+		case implements(t, textMarshalerType):
+			return &JSONSchema{Type: "string"}, nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s, err := schemaForType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		s.Nullable = true
+		return s, nil
+	case reflect.String:
+		return &JSONSchema{Type: "string"}, nil
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}, nil
+	case reflect.Interface:
+		return &JSONSchema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// []byte 被 encoding/json 编码为 base64 字符串，[N]byte 数组仍编码为整数数组
+			return &JSONSchema{Type: "string"}, nil
+		}
+		items, err := schemaForType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := schemaForType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return nil, fmt.Errorf("recursive type %s is not supported", t)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, Required: []string{}, AdditionalProperties: false}
+		if err := addStructFields(s, t, visiting); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// addStructFields 将结构体的字段加入 object 类型的 schema，匿名嵌入的结构体会被展开
+func addStructFields(s *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := addStructFields(s, ft, visiting); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop, err := schemaForType(field.Type, visiting)
+		if err != nil {
+			return fmt.Errorf("field %s.%s: %w", t.Name(), field.Name, err)
+		}
+		if hasTagOption(opts, "string") && prop.Type != "" {
+			prop.Type = "string"
+		}
+		if hasTagOption(opts, "omitempty") || hasTagOption(opts, "omitzero") {
+			prop.Nullable = true
+		}
+		if desc := field.Tag.Get("description"); desc != "" {
+			prop.Description = desc
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			values, err := parseEnumTag(enum, prop.Type)
+			if err != nil {
+				return fmt.Errorf("field %s.%s: %w", t.Name(), field.Name, err)
+			}
+			prop.Enum = values
+		}
+
+		if _, exists := s.Properties[name]; !exists {
+			s.propertyOrder = append(s.propertyOrder, name)
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	return nil
+}
+
+func hasTagOption(opts, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEnumTag 按字段类型解析 `enum:"a,b,c"` 标签
+func parseEnumTag(tag, typ string) ([]any, error) {
+	parts := strings.Split(tag, ",")
+	values := make([]any, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		switch typ {
+		case "integer":
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer enum value %q", part)
+			}
+			values = append(values, n)
+		case "number":
+			f, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number enum value %q", part)
+			}
+			values = append(values, f)
+		default:
+			values = append(values, part)
+		}
+	}
+	return values, nil
+}
+
+// Validate 校验一段 JSON 是否符合 schema，返回的错误指出第一个不符合的位置，例如 "$.items[0].name"
+func (s *JSONSchema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid json: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid json: unexpected data after top-level value")
+	}
+	return s.validate("$", value)
+}
+
+func (s *JSONSchema) validate(path string, value any) error {
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %s", path, jsonTypeName(value))
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			prop, ok := s.Properties[key]
+			if !ok {
+				switch extra := s.AdditionalProperties.(type) {
+				case bool:
+					if !extra {
+						return fmt.Errorf("%s: unexpected property %q", path, key)
+					}
+				case *JSONSchema:
+					prop = extra
+				}
+			}
+			if prop == nil {
+				continue
+			}
+			if err := prop.validate(path+"."+key, obj[key]); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %s", path, jsonTypeName(value))
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case "string", "boolean", "number", "integer":
+		if got := jsonTypeName(value); got != s.Type && !(s.Type == "number" && got == "integer") {
+			return fmt.Errorf("%s: expected %s, got %s", path, s.Type, got)
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: value %v is not one of %v", path, value, s.Enum)
+	}
+	return nil
+}
+
+// jsonTypeName 返回使用 UseNumber 解码出的值对应的 JSON 类型名
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,156 @@
+package aiutil
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schemaBase struct {
+	ID string `json:"id"`
+}
+
+type schemaItem struct {
+	Name  string  `json:"name" description:"商品名称"`
+	Price float64 `json:"price"`
+}
+
+type schemaOrder struct {
+	schemaBase
+	Status   string            `json:"status" enum:"pending,paid"`
+	Priority int               `json:"priority" enum:"1,2,3"`
+	Items    []schemaItem      `json:"items"`
+	Note     *string           `json:"note"`
+	Tags     []string          `json:"tags,omitempty"`
+	Meta     map[string]int    `json:"meta"`
+	Created  time.Time         `json:"created"`
+	Extra    map[string]string `json:"-"`
+	internal int
+}
+
+func TestGenerateSchema(t *testing.T) {
+	schema, err := SchemaFor[schemaOrder]()
+	if err != nil {
+		t.Fatalf("SchemaFor 失败: %v", err)
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	got := string(b)
+
+	// properties 按声明顺序输出，嵌入的结构体被展开
+	want := `{"type":"object","properties":{` +
+		`"id":{"type":"string"},` +
+		`"status":{"type":"string","enum":["pending","paid"]},` +
+		`"priority":{"type":"integer","enum":[1,2,3]},` +
+		`"items":{"type":"array","items":{"type":"object","properties":{"name":{"type":"string","description":"商品名称"},"price":{"type":"number"}},"required":["name","price"],"additionalProperties":false}},` +
+		`"note":{"type":["string","null"]},` +
+		`"tags":{"type":["array","null"],"items":{"type":"string"}},` +
+		`"meta":{"type":"object","additionalProperties":{"type":"integer"}},` +
+		`"created":{"type":"string","format":"date-time"}},` +
+		`"required":["id","status","priority","items","note","tags","meta","created"],"additionalProperties":false}`
+	if got != want {
+		t.Errorf("生成的 schema 不符合预期:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+// schemaLevel 以文本形式编码
+type schemaLevel int
+
+func (l schemaLevel) MarshalText() ([]byte, error) { return []byte(strconv.Itoa(int(l))), nil }
+
+// schemaPoint 以自定义的 JSON 格式编码
+type schemaPoint struct{ x, y int }
+
+func (p *schemaPoint) MarshalJSON() ([]byte, error) { return json.Marshal([]int{p.x, p.y}) }
+
+func TestGenerateSchema_Encoders(t *testing.T) {
+	type payload struct {
+		Data  []byte      `json:"data"`
+		Hash  [4]byte     `json:"hash"`
+		Level schemaLevel `json:"level"`
+	}
+	schema, err := SchemaFor[payload]()
+	if err != nil {
+		t.Fatalf("SchemaFor 失败: %v", err)
+	}
+	b, _ := json.Marshal(schema)
+	want := `{"type":"object","properties":{` +
+		`"data":{"type":"string"},` +
+		`"hash":{"type":"array","items":{"type":"integer"}},` +
+		`"level":{"type":"string"}},` +
+		`"required":["data","hash","level"],"additionalProperties":false}`
+	if string(b) != want {
+		t.Errorf("生成的 schema 不符合预期:\n got: %s\nwant: %s", b, want)
+	}
+
+	// Schema 应与 encoding/json 的实际输出一致
+	encoded, _ := json.Marshal(payload{Data: []byte("hi"), Hash: [4]byte{1, 2, 3, 4}, Level: 2})
+	if err := schema.Validate(encoded); err != nil {
+		t.Errorf("encoding/json 的输出应通过校验: %s, %v", encoded, err)
+	}
+
+	// 实现了 json.Marshaler 的类型 (包括指针接收者) 无法推断格式
+	if _, err := SchemaFor[struct {
+		P schemaPoint `json:"p"`
+	}](); err == nil || !strings.Contains(err.Error(), "json.Marshaler") {
+		t.Errorf("实现 json.Marshaler 的类型应返回错误，实际: %v", err)
+	}
+}
+
+func TestGenerateSchema_Unsupported(t *testing.T) {
+	type node struct {
+		Next *node `json:"next"`
+	}
+	if _, err := SchemaFor[node](); err == nil || !strings.Contains(err.Error(), "recursive") {
+		t.Errorf("递归类型应返回错误，实际: %v", err)
+	}
+	if _, err := SchemaFor[struct{ C chan int }](); err == nil {
+		t.Error("chan 类型应返回错误")
+	}
+	if _, err := SchemaFor[map[int]string](); err == nil {
+		t.Error("键不是字符串的 map 应返回错误")
+	}
+
+	// map 可以生成 Schema，但 additionalProperties 是 Schema 而不是 false，不满足 strict 模式
+	schema, err := SchemaFor[map[string]int]()
+	if err != nil {
+		t.Fatalf("生成 map 的 Schema 失败: %v", err)
+	}
+	if values, ok := schema.AdditionalProperties.(*JSONSchema); !ok || values.Type != "integer" {
+		t.Errorf("map 应以值的 Schema 作为 additionalProperties，实际 %#v", schema.AdditionalProperties)
+	}
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema, err := SchemaFor[schemaOrder]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := `{"id":"1","status":"paid","priority":2,"items":[{"name":"a","price":1}],"note":null,"tags":null,"meta":{"x":1},"created":"2024-01-01T00:00:00Z"}`
+	if err := schema.Validate([]byte(valid)); err != nil {
+		t.Errorf("合法的 JSON 校验失败: %v", err)
+	}
+
+	tests := []struct {
+		json    string
+		wantErr string
+	}{
+		{strings.Replace(valid, `"paid"`, `"shipped"`, 1), "$.status"},
+		{strings.Replace(valid, `"priority":2`, `"priority":2.5`, 1), "$.priority: expected integer"},
+		{strings.Replace(valid, `"price":1`, `"price":"1"`, 1), "$.items[0].price"},
+		{strings.Replace(valid, `"id":"1",`, ``, 1), `missing required property "id"`},
+		{strings.Replace(valid, `"id":"1"`, `"id":"1","bogus":true`, 1), `unexpected property "bogus"`},
+		{strings.Replace(valid, `"meta":{"x":1}`, `"meta":{"x":"1"}`, 1), "$.meta.x"},
+		{strings.Replace(valid, `"id":"1"`, `"id":null`, 1), "$.id: must not be null"},
+		{`{"id":`, "invalid json"},
+	}
+	for _, tt := range tests {
+		if err := schema.Validate([]byte(tt.json)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("期望错误包含 %q，实际: %v", tt.wantErr, err)
+		}
+	}
+}
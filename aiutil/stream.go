@@ -0,0 +1,53 @@
+package aiutil
+
+import (
+	"context"
+	"iter"
+)
+
+// =================================================================================
+// 迭代器形式的流式 API
+// =================================================================================
+
+// StreamChatCompletion 以迭代器的形式通过 SSE 发起流式对话请求，历史记录由客户端的默认会话维护。
+// 详见 Conversation.StreamChatCompletion。
+func (c *Client) StreamChatCompletion(ctx context.Context, request ChatRequest) iter.Seq2[ChatStreamResponse, error] {
+	return c.defaultConv.StreamChatCompletion(ctx, request)
+}
+
+// StreamChatCompletion 以迭代器的形式在会话中通过 SSE 发起流式对话请求，流正常结束后更新历史记录。
+// 每次 range 都会发起一次新的请求；请求失败或流中出现错误时产生一个非 nil 的 error。
+// 提前 break 会取消请求并关闭连接，不需要额外清理，不完整的回复不会写入历史记录。
+//
+// 使用示例
+//
+//	for chunk, err := range conv.StreamChatCompletion(ctx, request) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Print(chunk.Choices[0].Delta.Content)
+//	}
+func (cv *Conversation) StreamChatCompletion(ctx context.Context, request ChatRequest) iter.Seq2[ChatStreamResponse, error] {
+	return streamSeq(ctx, func(ctx context.Context) (<-chan StreamEvent, error) {
+		return cv.CreateChatCompletionSSEStream(ctx, request)
+	})
+}
+
+// streamSeq 将 channel 形式的流转换为迭代器，迭代结束或提前退出时取消 start 使用的 ctx
+func streamSeq(ctx context.Context, start func(ctx context.Context) (<-chan StreamEvent, error)) iter.Seq2[ChatStreamResponse, error] {
+	return func(yield func(ChatStreamResponse, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream, err := start(ctx)
+		if err != nil {
+			yield(ChatStreamResponse{}, err)
+			return
+		}
+		for event := range stream {
+			if !yield(event.Data, event.Error) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// endlessStreamHandler 每隔 5ms 发送一个数据块直到客户端断开，断开后关闭 closed
+func endlessStreamHandler(closed chan<- struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for {
+			if _, err := fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"x\"}}]}\n\n"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// waitClosed 等待 ch 被关闭，超时则测试失败
+func waitClosed[T any](t *testing.T, ch <-chan T, what string) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s 没有在取消后及时结束", what)
+		}
+	}
+}
+
+func TestSSEStream_CancelWithoutReading(t *testing.T) {
+	closed := make(chan struct{})
+	client := newTestClient(t, endlessStreamHandler(closed))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.CreateChatCompletionSSEStream(ctx, ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	<-stream // 读取一个数据块后停止读取
+
+	cancel()
+	waitClosed(t, closed, "服务端连接")
+	waitClosed(t, stream, "流 goroutine")
+	if history := client.GetHistory(); len(history) != 0 {
+		t.Errorf("取消的流不应写入历史记录: %+v", history)
+	}
+}
+
+func TestSSEStream_CancelWhileReading(t *testing.T) {
+	closed := make(chan struct{})
+	client := newTestClient(t, endlessStreamHandler(closed))
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	stream, err := client.doSSEStream(ctx, ChatRequest{Model: "gpt"}, func(ChatMessage) error { return nil })
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+
+	stopErr := errors.New("用户停止")
+	var last error
+	for event := range stream {
+		if event.Error != nil {
+			last = event.Error
+			continue
+		}
+		cancel(stopErr)
+	}
+	if !errors.Is(last, stopErr) {
+		t.Errorf("正在读取的调用方应收到取消原因，实际 %v", last)
+	}
+	waitClosed(t, closed, "服务端连接")
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"世界\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+
+	var sb strings.Builder
+	for chunk, err := range client.StreamChatCompletion(context.Background(), ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}) {
+		if err != nil {
+			t.Fatalf("流中出现错误: %v", err)
+		}
+		sb.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if sb.String() != "你好世界" {
+		t.Errorf("流式内容错误: %q", sb.String())
+	}
+	if history := client.GetHistory(); len(history) != 2 || history[1].Content != "你好世界" {
+		t.Errorf("流结束后应更新历史记录: %+v", history)
+	}
+}
+
+func TestStreamChatCompletion_Break(t *testing.T) {
+	closed := make(chan struct{})
+	client := newTestClient(t, endlessStreamHandler(closed))
+
+	count := 0
+	for _, err := range client.StreamChatCompletion(context.Background(), ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}) {
+		if err != nil {
+			t.Fatalf("流中出现错误: %v", err)
+		}
+		if count++; count == 3 {
+			break
+		}
+	}
+	waitClosed(t, closed, "服务端连接")
+	if history := client.GetHistory(); len(history) != 0 {
+		t.Errorf("提前退出的流不应写入历史记录: %+v", history)
+	}
+}
+
+func TestStreamChatCompletion_RequestError(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"bad key"}}`, http.StatusUnauthorized)
+	}))
+
+	calls := 0
+	for _, err := range client.StreamChatCompletion(context.Background(), ChatRequest{Model: "gpt"}) {
+		calls++
+		if !IsAuthError(err) {
+			t.Errorf("期望认证错误，实际 %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("请求失败时应只产生一次错误，实际 %d 次", calls)
+	}
+}
+
+func TestSSEStream_ErrorEvent(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"error\":{\"message\":\"overloaded\",\"code\":\"overloaded\"}}\n\n")
+	}))
+
+	stream, err := client.CreateChatCompletionSSEStream(context.Background(), ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	_, err = CollectStream(stream)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "overloaded" {
+		t.Errorf("error 事件应返回 APIError，实际 %v", err)
+	}
+	if history := client.GetHistory(); len(history) != 0 {
+		t.Errorf("中途出错的流不应写入历史记录: %+v", history)
+	}
+}
+
+func TestWebSocketStream_CancelWithoutReading(t *testing.T) {
+	closed := make(chan struct{})
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		defer close(closed)
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		for {
+			if err := websocket.JSON.Send(ws, ChatStreamResponse{Choices: []ChatStreamChoice{{Delta: ChatDelta{Content: "x"}}}}); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.CreateChatCompletionWebSocketStream(ctx, ChatRequest{Model: "gpt"})
+	if err != nil {
+		t.Fatalf("WebSocket 流式请求失败: %v", err)
+	}
+	<-stream
+
+	cancel()
+	waitClosed(t, stream, "流 goroutine")
+	waitClosed(t, closed, "服务端连接")
+	if history := client.GetHistory(); len(history) != 0 {
+		t.Errorf("取消的流不应写入历史记录: %+v", history)
+	}
+}
@@ -0,0 +1,158 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// =================================================================================
+// 结构化输出 (Structured Output)
+// =================================================================================
+
+// ResponseFormat 的类型
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object" // 回复是合法的 JSON 对象，需要在提示词中说明结构
+	ResponseFormatJSONSchema = "json_schema" // 回复符合 JSONSchema 指定的结构
+)
+
+// ResponseFormat 对应请求中的 response_format 字段
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat 描述 json_schema 类型的回复格式
+type JSONSchemaFormat struct {
+	Name        string `json:"name"` // 只能包含字母、数字、下划线和连字符，最长 64 个字符
+	Description string `json:"description,omitempty"`
+	// Schema 可以是 *JSONSchema，也可以是 map[string]any 或 json.RawMessage 等任何可序列化的值
+	Schema any  `json:"schema"`
+	Strict bool `json:"strict,omitempty"`
+}
+
+// JSONObjectFormat 返回要求模型输出 JSON 对象的 ResponseFormat
+func JSONObjectFormat() *ResponseFormat {
+	return &ResponseFormat{Type: ResponseFormatJSONObject}
+}
+
+// JSONSchemaResponseFormat 返回要求模型严格按照 schema 输出的 ResponseFormat
+func JSONSchemaResponseFormat(name string, schema any) *ResponseFormat {
+	return &ResponseFormat{
+		Type:       ResponseFormatJSONSchema,
+		JSONSchema: &JSONSchemaFormat{Name: name, Schema: schema, Strict: true},
+	}
+}
+
+// ErrInvalidStructuredOutput 表示模型的回复无法解析为要求的结构
+var ErrInvalidStructuredOutput = errors.New("aiutil: invalid structured output")
+
+// StructuredResult 是 CreateStructured 的结果
+type StructuredResult[T any] struct {
+	Value    T
+	Response *ChatResponse // 最后一次请求的原始响应
+	Attempts int           // 请求模型的次数，校验失败重新提问时为 2
+}
+
+// CreateStructured 要求模型按照 T 的结构回复，并将回复解码为 T。
+//
+// request.ResponseFormat 为空时根据 T 生成 JSON Schema 并使用 json_schema 格式 (规则见 GenerateSchema)；
+// 服务商不支持 json_schema 时，可以传入 JSONObjectFormat() 并在提示词中描述结构。
+// 回复会依次经过 Schema 校验、解码，以及 T 实现的 Validate() error 方法 (如果有)。
+// 校验失败时把错误反馈给模型并重新提问一次，仍然失败则返回包装了 ErrInvalidStructuredOutput 的错误。
+// 与 Toolkit.Run 一样，重新提问只发送反馈消息，之前的上下文依赖 completer 维护的对话历史。
+//
+// 使用示例
+//
+//	type Review struct {
+//		Sentiment string   `json:"sentiment" enum:"positive,neutral,negative"`
+//		Keywords  []string `json:"keywords" description:"评论中的关键词"`
+//	}
+//	result, err := aiutil.CreateStructured[Review](ctx, client, aiutil.ChatRequest{
+//		Model:    "gpt-4o-mini",
+//		Messages: []aiutil.ChatMessage{{Role: aiutil.RoleUser, Content: "分析这条评论：" + text}},
+//	})
+//	fmt.Println(result.Value.Sentiment)
+func CreateStructured[T any](ctx context.Context, completer ChatCompleter, request ChatRequest) (*StructuredResult[T], error) {
+	schema, err := SchemaFor[T]()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate schema: %w", err)
+	}
+	if request.ResponseFormat == nil {
+		request.ResponseFormat = JSONSchemaResponseFormat(schemaName(reflect.TypeOf((*T)(nil)).Elem()), schema)
+	}
+
+	const maxAttempts = 2
+	for attempt := 1; ; attempt++ {
+		resp, err := completer.CreateChatCompletion(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Choices) == 0 {
+			return nil, fmt.Errorf("%w: empty response", ErrInvalidStructuredOutput)
+		}
+
+		value, verr := decodeStructured[T](resp.Choices[0].Message.Content, schema)
+		if verr == nil {
+			return &StructuredResult[T]{Value: value, Response: resp, Attempts: attempt}, nil
+		}
+		if attempt >= maxAttempts {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidStructuredOutput, verr)
+		}
+
+		// 把校验错误反馈给模型，要求其修正
+		request.Messages = []ChatMessage{{
+			Role:    RoleUser,
+			Content: fmt.Sprintf("你的回复没有通过校验：%v\n请修正后重新回复，只输出符合要求的 JSON，不要包含其他内容。", verr),
+		}}
+	}
+}
+
+// decodeStructured 校验并解码模型的回复
+func decodeStructured[T any](content string, schema *JSONSchema) (T, error) {
+	var value T
+	data := []byte(extractJSON(content))
+	if err := schema.Validate(data); err != nil {
+		return value, err
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, err
+	}
+	if v, ok := any(&value).(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+var codeFencePattern = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*\\n(.*?)\\n?```$")
+
+// extractJSON 去掉部分模型在 JSON 外面包裹的 Markdown 代码块
+func extractJSON(content string) string {
+	content = strings.TrimSpace(content)
+	if m := codeFencePattern.FindStringSubmatch(content); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return content
+}
+
+var invalidSchemaNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// schemaName 根据类型名生成符合要求的 json_schema 名称
+func schemaName(t reflect.Type) string {
+	name := invalidSchemaNameChars.ReplaceAllString(t.Name(), "_")
+	name = strings.Trim(name, "_")
+	if name == "" {
+		return "response"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,94 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type sentiment struct {
+	Label string  `json:"label" enum:"positive,negative"`
+	Score float64 `json:"score"`
+}
+
+func (s sentiment) Validate() error {
+	if s.Score < 0 || s.Score > 1 {
+		return errors.New("score must be between 0 and 1")
+	}
+	return nil
+}
+
+func TestCreateStructured(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := decodeRequest(t, r)
+		format, _ := payload["response_format"].(map[string]any)
+		schema, _ := format["json_schema"].(map[string]any)
+		if format["type"] != ResponseFormatJSONSchema || schema["name"] != "sentiment" || schema["strict"] != true {
+			t.Errorf("response_format 不正确: %v", format)
+		}
+		writeChatResponse(w, "```json\n{\"label\":\"positive\",\"score\":0.9}\n```")
+	}))
+
+	result, err := CreateStructured[sentiment](context.Background(), client, ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "好评"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateStructured 失败: %v", err)
+	}
+	if result.Value.Label != "positive" || result.Value.Score != 0.9 || result.Attempts != 1 {
+		t.Errorf("解码结果不正确: %+v", result)
+	}
+}
+
+func TestCreateStructured_Reprompt(t *testing.T) {
+	replies := []string{
+		`{"label":"positive","score":3}`,   // Validate 失败
+		`{"label":"positive","score":0.8}`, // 修正后的回复
+	}
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 2 {
+			messages, _ := decodeRequest(t, r)["messages"].([]any)
+			last, _ := messages[len(messages)-1].(map[string]any)
+			if content, _ := last["content"].(string); !strings.Contains(content, "score must be between 0 and 1") {
+				t.Errorf("重新提问应包含校验错误，实际: %q", content)
+			}
+		}
+		writeChatResponse(w, replies[n-1])
+	}))
+
+	result, err := CreateStructured[sentiment](context.Background(), client, ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "好评"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateStructured 失败: %v", err)
+	}
+	if result.Value.Score != 0.8 || result.Attempts != 2 {
+		t.Errorf("期望第二次回复被采用: %+v", result)
+	}
+}
+
+func TestCreateStructured_GiveUp(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeChatResponse(w, `{"label":"unknown","score":0.5}`)
+	}))
+
+	_, err := CreateStructured[sentiment](context.Background(), client, ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "好评"}},
+	})
+	if !errors.Is(err, ErrInvalidStructuredOutput) || !strings.Contains(err.Error(), "$.label") {
+		t.Errorf("期望 ErrInvalidStructuredOutput，实际: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("只应重新提问一次，实际请求 %d 次", calls.Load())
+	}
+}
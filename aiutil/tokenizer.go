@@ -0,0 +1,273 @@
+package aiutil
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// =================================================================================
+// Token 计数 (Tokenizer)
+// =================================================================================
+
+// Tokenizer 计算一段文本的 Token 数，用于历史记录截断等需要精确预算的场景。
+// 实现必须是并发安全的。
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenOverhead 描述消息格式本身带来的额外 Token 开销。
+// 以 OpenAI 的 Chat 格式为例，每条消息会被包裹成
+// "<|start|>{role}\n{content}<|end|>\n"，回复前还会追加 "<|start|>assistant<|message|>"。
+type TokenOverhead struct {
+	PerMessage int // 每条消息的固定开销
+	PerName    int // 消息带有 Name 字段时的额外开销
+	PerReply   int // 每次请求为模型回复预留的开销
+}
+
+// DefaultTokenOverhead 是 OpenAI gpt-3.5-turbo / gpt-4 系列模型的消息开销
+var DefaultTokenOverhead = TokenOverhead{PerMessage: 3, PerName: 1, PerReply: 3}
+
+// CountMessageTokens 计算一条消息占用的 Token 数，包括角色、内容、名称、工具调用、思考过程和消息格式开销。
+// 多模态消息中的图片按 OpenAI 的图块规则估算，音频和文件不计入。
+func CountMessageTokens(tokenizer Tokenizer, overhead TokenOverhead, msg ChatMessage) int {
+	n := overhead.PerMessage + tokenizer.CountTokens(msg.Role) + tokenizer.CountTokens(msg.Content)
+	for _, part := range msg.MultiContent {
+		switch {
+		case part.Type == ContentPartText:
+			n += tokenizer.CountTokens(part.Text)
+		case part.Type == ContentPartImageURL && part.ImageURL != nil:
+			n += imageTokens(part.ImageURL)
+		}
+	}
+	if msg.Name != "" {
+		n += overhead.PerName + tokenizer.CountTokens(msg.Name)
+	}
+	for _, call := range msg.ToolCalls {
+		n += tokenizer.CountTokens(call.Function.Name) + tokenizer.CountTokens(call.Function.Arguments)
+	}
+	if msg.ToolCallID != "" {
+		n += tokenizer.CountTokens(msg.ToolCallID)
+	}
+	if msg.ReasoningContent != "" {
+		n += tokenizer.CountTokens(msg.ReasoningContent)
+	}
+	return n
+}
+
+// CountTokens 使用客户端配置的 Tokenizer 计算一组消息作为请求上下文时占用的 Token 数
+func (c *Client) CountTokens(messages []ChatMessage) int {
+	n := c.config.TokenOverhead.PerReply
+	for _, msg := range messages {
+		n += c.countMessageTokens(msg)
+	}
+	return n
+}
+
+// countMessageTokens 使用客户端配置的 Tokenizer 计算单条消息的 Token 数
+func (c *Client) countMessageTokens(msg ChatMessage) int {
+	return CountMessageTokens(c.tokenizer, c.config.TokenOverhead, msg)
+}
+
+// EstimateTokenizer 是不依赖词表的粗略估算，未配置 Tokenizer 时使用。
+// 中日韩文字按每个字 1 个 Token 计算，其余文本按每 4 个字节 1 个 Token 计算。
+type EstimateTokenizer struct{}
+
+// CountTokens 估算文本的 Token 数
+func (EstimateTokenizer) CountTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other += utf8.RuneLen(r)
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// =================================================================================
+// BPE Tokenizer (兼容 tiktoken 的词表文件)
+// =================================================================================
+
+// 预分词正则。Go 的 regexp 不支持 tiktoken 原始表达式中的 `\s+(?!\S)`，
+// 这里去掉了该分支，由 BPETokenizer 在切分时模拟其效果。
+const (
+	// Cl100kPattern 是 cl100k_base (gpt-4, gpt-3.5-turbo, text-embedding-3) 的预分词正则
+	Cl100kPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`
+	// O200kPattern 是 o200k_base (gpt-4o, o1, o3) 的预分词正则
+	O200kPattern = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+`
+)
+
+// BPETokenizer 是字节级 BPE 分词器，可加载 tiktoken 格式的词表
+// (例如 cl100k_base.tiktoken、o200k_base.tiktoken)，计数结果与 OpenAI 计费一致。
+//
+// 使用示例
+//
+//	tokenizer, err := aiutil.LoadBPETokenizer("o200k_base.tiktoken", aiutil.O200kPattern)
+//	config.Tokenizer = tokenizer
+type BPETokenizer struct {
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+// NewBPETokenizer 使用词表 (token 字节串 -> rank) 和预分词正则创建分词器
+func NewBPETokenizer(ranks map[string]int, pattern string) (*BPETokenizer, error) {
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("empty bpe ranks")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pre-tokenize pattern: %w", err)
+	}
+	return &BPETokenizer{ranks: ranks, pattern: re}, nil
+}
+
+// LoadBPETokenizer 从 tiktoken 格式的词表文件创建分词器
+func LoadBPETokenizer(path, pattern string) (*BPETokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bpe rank file: %w", err)
+	}
+	defer f.Close()
+
+	ranks, err := ParseBPERanks(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewBPETokenizer(ranks, pattern)
+}
+
+// ParseBPERanks 解析 tiktoken 格式的词表，每行为 "<base64 编码的 token> <rank>"
+func ParseBPERanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		encoded, rankStr, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid bpe rank line %d: %q", lineNo, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bpe token at line %d: %w", lineNo, err)
+		}
+		rank, err := strconv.Atoi(strings.TrimSpace(rankStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bpe rank at line %d: %w", lineNo, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bpe ranks: %w", err)
+	}
+	return ranks, nil
+}
+
+// CountTokens 返回文本编码后的 Token 数
+func (t *BPETokenizer) CountTokens(text string) int {
+	n := 0
+	for _, piece := range t.split(text) {
+		if _, ok := t.ranks[piece]; ok {
+			n++
+			continue
+		}
+		n += len(t.bytePairMerge(piece)) - 1
+	}
+	return n
+}
+
+// Encode 将文本编码为 Token ID 序列。词表中缺失的字节会被跳过。
+func (t *BPETokenizer) Encode(text string) []int {
+	tokens := make([]int, 0, len(text)/3)
+	for _, piece := range t.split(text) {
+		if rank, ok := t.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		bounds := t.bytePairMerge(piece)
+		for i := 0; i < len(bounds)-1; i++ {
+			if rank, ok := t.ranks[piece[bounds[i]:bounds[i+1]]]; ok {
+				tokens = append(tokens, rank)
+			}
+		}
+	}
+	return tokens
+}
+
+// split 使用预分词正则将文本切分为片段，并模拟 `\s+(?!\S)`:
+// 纯空白片段后面紧跟非空白字符时，最后一个空白字符留给下一个片段 (例如 "  foo" 切分为 " " 和 " foo")。
+func (t *BPETokenizer) split(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := t.pattern.FindStringIndex(text)
+		if loc == nil || loc[1] == 0 {
+			// 正则没有覆盖的字符，单独作为一个片段
+			_, size := utf8.DecodeRuneInString(text)
+			pieces = append(pieces, text[:size])
+			text = text[size:]
+			continue
+		}
+		if loc[0] > 0 {
+			pieces = append(pieces, text[:loc[0]])
+		}
+
+		end := loc[1]
+		piece := text[loc[0]:end]
+		if end < len(text) && isHorizontalSpace(piece) {
+			if _, size := utf8.DecodeLastRuneInString(piece); size < len(piece) {
+				end -= size
+				piece = piece[:len(piece)-size]
+			}
+		}
+		pieces = append(pieces, piece)
+		text = text[end:]
+	}
+	return pieces
+}
+
+// isHorizontalSpace 判断字符串是否全部由不含换行的空白字符组成
+func isHorizontalSpace(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) || r == '\r' || r == '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+// bytePairMerge 对片段执行 BPE 合并，返回合并后各 Token 的边界 (长度为 Token 数 + 1)
+func (t *BPETokenizer) bytePairMerge(piece string) []int {
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	// 每次合并 rank 最小的相邻对，直到没有可合并的对
+	for len(bounds) > 2 {
+		minRank, minIndex := math.MaxInt, -1
+		for i := 0; i < len(bounds)-2; i++ {
+			if rank, ok := t.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < minRank {
+				minRank, minIndex = rank, i
+			}
+		}
+		if minIndex < 0 {
+			break
+		}
+		bounds = append(bounds[:minIndex+1], bounds[minIndex+2:]...)
+	}
+	return bounds
+}
@@ -0,0 +1,131 @@
+package aiutil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestRanks 生成一个 tiktoken 格式的小词表: 256 个单字节 token 加上若干合并规则
+func writeTestRanks(t *testing.T, merges ...string) string {
+	t.Helper()
+	var sb strings.Builder
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	for i, m := range merges {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(m)), 256+i)
+	}
+	path := filepath.Join(t.TempDir(), "test.tiktoken")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		t.Fatalf("写入词表失败: %v", err)
+	}
+	return path
+}
+
+func TestBPETokenizer_Encode(t *testing.T) {
+	path := writeTestRanks(t, "he", "ll", "hell", "hello", " w", " wo", " wor", "你")
+	tokenizer, err := LoadBPETokenizer(path, Cl100kPattern)
+	if err != nil {
+		t.Fatalf("LoadBPETokenizer 失败: %v", err)
+	}
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"hello", []int{259}},            // 整个片段命中词表
+		{"hellx", []int{258, 'x'}},       // he + ll -> hell，x 无法合并
+		{" world", []int{262, 'l', 'd'}}, // " w" -> " wo" -> " wor"
+		{"你", []int{263}},                // 多字节字符整体命中
+		{"hello world", []int{259, 262, 'l', 'd'}},
+	}
+	for _, tt := range tests {
+		if got := tokenizer.Encode(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+		if got := tokenizer.CountTokens(tt.text); got != len(tt.want) {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.text, got, len(tt.want))
+		}
+	}
+}
+
+func TestBPETokenizer_Split(t *testing.T) {
+	tokenizer, err := NewBPETokenizer(map[string]int{"a": 0}, Cl100kPattern)
+	if err != nil {
+		t.Fatalf("NewBPETokenizer 失败: %v", err)
+	}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"a  b", []string{"a", " ", " b"}}, // 空白后跟单词时留一个空格给单词
+		{"foo  ", []string{"foo", "  "}},   // 末尾空白保持完整
+		{"I'm 12345!\n\n  x", []string{"I", "'m", " ", "123", "45", "!\n\n", " ", " x"}},
+		{"你好，世界", []string{"你好", "，世界"}}, // 标点与后续文字合并为一个片段
+	}
+	for _, tt := range tests {
+		if got := tokenizer.split(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("split(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseBPERanks_Invalid(t *testing.T) {
+	if _, err := ParseBPERanks(strings.NewReader("YQ==\n")); err == nil {
+		t.Error("缺少 rank 的行应返回错误")
+	}
+	if _, err := ParseBPERanks(strings.NewReader("!!! 1\n")); err == nil {
+		t.Error("非法 base64 应返回错误")
+	}
+}
+
+func TestEstimateTokenizer(t *testing.T) {
+	var tokenizer EstimateTokenizer
+	if got := tokenizer.CountTokens("你好世界"); got != 4 {
+		t.Errorf("中文应按每字 1 个 token 估算, got %d", got)
+	}
+	if got := tokenizer.CountTokens("abcdefgh"); got != 2 {
+		t.Errorf("英文应按每 4 字节 1 个 token 估算, got %d", got)
+	}
+}
+
+func TestCountMessageTokens(t *testing.T) {
+	var tokenizer EstimateTokenizer
+	msg := ChatMessage{Role: RoleUser, Name: "bob", Content: "你好"}
+	// 3 (每条消息) + 1 (user) + 2 (你好) + 1 (name) + 1 (bob)
+	if got := CountMessageTokens(tokenizer, DefaultTokenOverhead, msg); got != 8 {
+		t.Errorf("CountMessageTokens = %d, want 8", got)
+	}
+
+	client := NewClient(DefaultConfig("k"))
+	// 3 (回复预留) + 6
+	if got := client.CountTokens([]ChatMessage{{Role: RoleUser, Content: "你好"}}); got != 9 {
+		t.Errorf("Client.CountTokens = %d, want 9", got)
+	}
+}
+
+func TestConversation_PruneWithTokenizer(t *testing.T) {
+	client := newTestClient(t, echoHandler(t))
+	// 每条中文消息约 3+1+10=14 个 token，按字节计算则超过 30，会被全部丢弃
+	client.config.MaxHistoryTokens = 50
+	conv := client.Conversation("zh")
+	history := []ChatMessage{
+		{Role: RoleUser, Content: strings.Repeat("中", 10)},
+		{Role: RoleAssistant, Content: strings.Repeat("文", 10)},
+	}
+	_ = client.config.HistoryStore.Append(t.Context(), conv.ID(), history...)
+
+	messages, err := conv.buildMessages(t.Context(), "test-model", []ChatMessage{{Role: RoleUser, Content: "好"}})
+	if err != nil {
+		t.Fatalf("buildMessages 失败: %v", err)
+	}
+	if len(messages) != 3 {
+		t.Errorf("按 Token 计数应保留全部历史, got %d 条", len(messages))
+	}
+}
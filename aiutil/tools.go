@@ -0,0 +1,268 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// =================================================================================
+// 工具调用 (Function Calling)
+// =================================================================================
+
+// Tool 描述一个可供模型调用的工具，目前 OpenAI 只支持 "function" 类型
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition 描述一个函数工具的名称、用途和参数
+type FunctionDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters 是参数的 JSON Schema，可以是 map[string]any 或 json.RawMessage 等任何可序列化的值
+	Parameters any  `json:"parameters,omitempty"`
+	Strict     bool `json:"strict,omitempty"`
+}
+
+// ToolCall 是模型发起的一次工具调用。
+// 在流式响应中，同一个调用会被拆成多个片段，通过 Index 关联。
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall 是函数调用的名称和 JSON 编码的参数
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolChoiceFunction 返回强制模型调用指定函数的 tool_choice 值
+func ToolChoiceFunction(name string) any {
+	return map[string]any{
+		"type":     "function",
+		"function": map[string]string{"name": name},
+	}
+}
+
+// ToolMessage 构造一条响应工具调用的 tool 角色消息
+func ToolMessage(toolCallID, content string) ChatMessage {
+	return ChatMessage{Role: RoleTool, ToolCallID: toolCallID, Content: content}
+}
+
+// mergeToolCallDeltas 将流式响应中的工具调用片段按 Index 拼接到已有的调用中。
+// 首个片段携带 ID、类型和函数名，后续片段只携带参数的一部分。
+func mergeToolCallDeltas(calls []ToolCall, deltas []ToolCall) []ToolCall {
+	for _, delta := range deltas {
+		pos := -1
+		if delta.Index != nil {
+			for i := range calls {
+				if calls[i].Index != nil && *calls[i].Index == *delta.Index {
+					pos = i
+					break
+				}
+			}
+		}
+		if pos < 0 {
+			call := delta
+			if delta.Index != nil {
+				index := *delta.Index
+				call.Index = &index
+			}
+			calls = append(calls, call)
+			continue
+		}
+
+		call := &calls[pos]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// completeToolCalls 在流结束后清除拼接用的 Index，使调用可以原样写回历史记录
+func completeToolCalls(calls []ToolCall) []ToolCall {
+	for i := range calls {
+		calls[i].Index = nil
+	}
+	return calls
+}
+
+// =================================================================================
+// 工具注册与自动调用循环
+// =================================================================================
+
+// ChatCompleter 是能够发起同步对话请求的对象，*Client 和 *Conversation 都实现了该接口
+type ChatCompleter interface {
+	CreateChatCompletion(ctx context.Context, request ChatRequest) (*ChatResponse, error)
+}
+
+// ToolHandler 执行一次工具调用，arguments 是模型给出的 JSON 参数，返回值作为 tool 消息的内容
+type ToolHandler func(ctx context.Context, arguments string) (string, error)
+
+// ErrMaxToolRounds 表示模型在允许的轮数内没有给出最终回复
+var ErrMaxToolRounds = errors.New("aiutil: tool call rounds exceeded")
+
+// DefaultMaxToolRounds 是 Toolkit 默认允许的最大调用轮数
+const DefaultMaxToolRounds = 10
+
+type registeredTool struct {
+	definition FunctionDefinition
+	handler    ToolHandler
+}
+
+// Toolkit 管理一组可供模型调用的 Go 函数，并负责执行 "调用-响应" 循环。
+// 它是并发安全的。
+//
+// 使用示例
+//
+//	toolkit := aiutil.NewToolkit()
+//	// parameters 为 nil 时根据参数类型生成 JSON Schema
+//	_ = aiutil.RegisterFunc(toolkit, "get_weather", "查询城市天气", nil,
+//		func(ctx context.Context, args struct{ City string `json:"city" description:"城市名"` }) (string, error) {
+//			return args.City + ": 晴, 25°C", nil
+//		})
+//	resp, err := toolkit.Run(ctx, client, aiutil.ChatRequest{
+//		Model:    "gpt-4o-mini",
+//		Messages: []aiutil.ChatMessage{{Role: aiutil.RoleUser, Content: "北京天气怎么样？"}},
+//	})
+type Toolkit struct {
+	mu    sync.RWMutex
+	tools map[string]registeredTool
+	order []string
+
+	// MaxRounds 限制一次 Run 中最多请求模型的次数，<=0 时使用 DefaultMaxToolRounds
+	MaxRounds int
+}
+
+// NewToolkit 创建一个空的 Toolkit
+func NewToolkit() *Toolkit {
+	return &Toolkit{tools: make(map[string]registeredTool)}
+}
+
+// Register 注册一个工具。同名工具会被覆盖。
+func (t *Toolkit) Register(definition FunctionDefinition, handler ToolHandler) error {
+	if definition.Name == "" {
+		return errors.New("tool name must not be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("tool %q has nil handler", definition.Name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.tools[definition.Name]; !exists {
+		t.order = append(t.order, definition.Name)
+	}
+	t.tools[definition.Name] = registeredTool{definition: definition, handler: handler}
+	return nil
+}
+
+// RegisterFunc 将一个强类型的 Go 函数注册为工具。
+// 模型给出的 JSON 参数会被解码为 A，返回值 R 为 string 时直接作为结果，否则序列化为 JSON。
+// parameters 是参数 A 对应的 JSON Schema，为 nil 时通过 SchemaFor[A] 自动生成。
+func RegisterFunc[A any, R any](t *Toolkit, name, description string, parameters any, fn func(ctx context.Context, args A) (R, error)) error {
+	if parameters == nil {
+		schema, err := SchemaFor[A]()
+		if err != nil {
+			return fmt.Errorf("failed to generate parameters schema for tool %q: %w", name, err)
+		}
+		parameters = schema
+	}
+	handler := func(ctx context.Context, arguments string) (string, error) {
+		var args A
+		if arguments != "" {
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments for tool %q: %w", name, err)
+			}
+		}
+		result, err := fn(ctx, args)
+		if err != nil {
+			return "", err
+		}
+		if s, ok := any(result).(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal result of tool %q: %w", name, err)
+		}
+		return string(b), nil
+	}
+	return t.Register(FunctionDefinition{Name: name, Description: description, Parameters: parameters}, handler)
+}
+
+// Tools 按注册顺序返回所有工具的定义，可直接用于 ChatRequest.Tools
+func (t *Toolkit) Tools() []Tool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tools := make([]Tool, 0, len(t.order))
+	for _, name := range t.order {
+		tools = append(tools, Tool{Type: "function", Function: t.tools[name].definition})
+	}
+	return tools
+}
+
+// Call 执行一次工具调用并返回对应的 tool 消息。
+// 未知工具或执行失败时，错误信息会作为消息内容返回给模型，由模型决定如何继续。
+func (t *Toolkit) Call(ctx context.Context, call ToolCall) ChatMessage {
+	t.mu.RLock()
+	tool, ok := t.tools[call.Function.Name]
+	t.mu.RUnlock()
+	if !ok {
+		return ToolMessage(call.ID, fmt.Sprintf("error: unknown tool %q", call.Function.Name))
+	}
+
+	result, err := tool.handler(ctx, call.Function.Arguments)
+	if err != nil {
+		return ToolMessage(call.ID, "error: "+err.Error())
+	}
+	return ToolMessage(call.ID, result)
+}
+
+// Run 发送请求并自动执行模型发起的工具调用，直到模型给出不含工具调用的最终回复。
+// request.Tools 为空时自动填入 Toolkit 中注册的全部工具。
+// 每一轮只发送新产生的 tool 消息，之前的上下文依赖 completer 维护的对话历史。
+func (t *Toolkit) Run(ctx context.Context, completer ChatCompleter, request ChatRequest) (*ChatResponse, error) {
+	if len(request.Tools) == 0 {
+		request.Tools = t.Tools()
+	}
+	maxRounds := t.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = DefaultMaxToolRounds
+	}
+
+	for round := 0; round < maxRounds; round++ {
+		resp, err := completer.CreateChatCompletion(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Choices) == 0 || len(resp.Choices[0].Message.ToolCalls) == 0 {
+			return resp, nil
+		}
+
+		calls := resp.Choices[0].Message.ToolCalls
+		results := make([]ChatMessage, 0, len(calls))
+		for _, call := range calls {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			results = append(results, t.Call(ctx, call))
+		}
+		request.Messages = results
+		// 强制调用工具的设置只对第一轮有效，否则模型无法给出最终回复
+		request.ToolChoice = nil
+	}
+	return nil, ErrMaxToolRounds
+}
@@ -0,0 +1,167 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func intPtr(i int) *int { return &i }
+
+func TestMergeToolCallDeltas(t *testing.T) {
+	var calls []ToolCall
+	deltas := [][]ToolCall{
+		{{Index: intPtr(0), ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"ci`}}},
+		{{Index: intPtr(0), Function: FunctionCall{Arguments: `ty":"北京"}`}}},
+		{{Index: intPtr(1), ID: "call_2", Type: "function", Function: FunctionCall{Name: "get_time", Arguments: `{}`}}},
+	}
+	for _, d := range deltas {
+		calls = mergeToolCallDeltas(calls, d)
+	}
+	calls = completeToolCalls(calls)
+
+	if len(calls) != 2 {
+		t.Fatalf("期望拼接出 2 个调用，实际 %d", len(calls))
+	}
+	if calls[0].ID != "call_1" || calls[0].Function.Arguments != `{"city":"北京"}` {
+		t.Errorf("第一个调用拼接错误: %+v", calls[0])
+	}
+	if calls[1].Function.Name != "get_time" || calls[1].Index != nil {
+		t.Errorf("第二个调用拼接错误: %+v", calls[1])
+	}
+}
+
+func TestToolkit_Call(t *testing.T) {
+	toolkit := NewToolkit()
+	err := RegisterFunc(toolkit, "add", "两数相加", nil,
+		func(ctx context.Context, args struct{ A, B int }) (map[string]int, error) {
+			return map[string]int{"sum": args.A + args.B}, nil
+		})
+	if err != nil {
+		t.Fatalf("RegisterFunc 失败: %v", err)
+	}
+	_ = RegisterFunc(toolkit, "fail", "总是失败", nil,
+		func(ctx context.Context, args struct{}) (string, error) {
+			return "", errors.New("boom")
+		})
+
+	if got := toolkit.Tools(); len(got) != 2 || got[0].Function.Name != "add" || got[0].Type != "function" {
+		t.Errorf("Tools 返回错误: %+v", got)
+	}
+
+	msg := toolkit.Call(context.Background(), ToolCall{ID: "c1", Function: FunctionCall{Name: "add", Arguments: `{"A":1,"B":2}`}})
+	if msg.Role != RoleTool || msg.ToolCallID != "c1" || msg.Content != `{"sum":3}` {
+		t.Errorf("工具结果错误: %+v", msg)
+	}
+	msg = toolkit.Call(context.Background(), ToolCall{ID: "c2", Function: FunctionCall{Name: "fail"}})
+	if !strings.Contains(msg.Content, "boom") {
+		t.Errorf("工具错误应返回给模型: %+v", msg)
+	}
+	msg = toolkit.Call(context.Background(), ToolCall{ID: "c3", Function: FunctionCall{Name: "missing"}})
+	if !strings.Contains(msg.Content, "unknown tool") {
+		t.Errorf("未知工具应返回错误信息: %+v", msg)
+	}
+}
+
+func TestToolkit_Run(t *testing.T) {
+	var round atomic.Int32
+	var secondRequest ChatRequest
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if round.Add(1) == 1 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"",`+
+				`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"北京\"}"}}]}}]}`)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&secondRequest); err != nil {
+			t.Errorf("解析请求体失败: %v", err)
+		}
+		writeChatResponse(w, "北京今天晴")
+	}))
+
+	toolkit := NewToolkit()
+	_ = RegisterFunc(toolkit, "get_weather", "查询天气", map[string]any{"type": "object"},
+		func(ctx context.Context, args struct {
+			City string `json:"city"`
+		}) (string, error) {
+			return args.City + ": 晴", nil
+		})
+
+	resp, err := toolkit.Run(context.Background(), client, ChatRequest{
+		Model:      "test-model",
+		Messages:   []ChatMessage{{Role: RoleUser, Content: "北京天气怎么样？"}},
+		ToolChoice: ToolChoiceFunction("get_weather"),
+	})
+	if err != nil {
+		t.Fatalf("Run 失败: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "北京今天晴" {
+		t.Errorf("最终回复错误: %+v", resp.Choices[0].Message)
+	}
+
+	// 第二轮请求应包含: user, assistant(tool_calls), tool
+	msgs := secondRequest.Messages
+	if len(msgs) != 3 {
+		t.Fatalf("第二轮请求期望 3 条消息，实际 %d: %+v", len(msgs), msgs)
+	}
+	if len(msgs[1].ToolCalls) != 1 || msgs[2].Role != RoleTool || msgs[2].ToolCallID != "call_1" || msgs[2].Content != "北京: 晴" {
+		t.Errorf("第二轮请求消息错误: %+v", msgs)
+	}
+	if secondRequest.ToolChoice != nil || len(secondRequest.Tools) != 1 {
+		t.Errorf("第二轮请求应保留 tools 并取消强制调用: %+v", secondRequest)
+	}
+}
+
+func TestToolkit_RunMaxRounds(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"",`+
+			`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"loop","arguments":"{}"}}]}}]}`)
+	}))
+	toolkit := NewToolkit()
+	toolkit.MaxRounds = 2
+	_ = toolkit.Register(FunctionDefinition{Name: "loop"}, func(ctx context.Context, arguments string) (string, error) {
+		return "again", nil
+	})
+
+	_, err := toolkit.Run(context.Background(), client, ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}},
+	})
+	if !errors.Is(err, ErrMaxToolRounds) {
+		t.Errorf("期望 ErrMaxToolRounds，实际 %v", err)
+	}
+}
+
+func TestSSEStream_ToolCalls(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"上海\"}"}}]},"finish_reason":"tool_calls"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+
+	streamChan, err := client.CreateChatCompletionSSEStream(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "上海天气"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletionSSEStream 失败: %v", err)
+	}
+	for event := range streamChan {
+		if event.Error != nil {
+			t.Fatalf("流式处理出错: %v", event.Error)
+		}
+	}
+
+	history := client.GetHistory()
+	last := history[len(history)-1]
+	if len(last.ToolCalls) != 1 || last.ToolCalls[0].Function.Arguments != `{"city":"上海"}` || last.ToolCalls[0].Index != nil {
+		t.Errorf("历史记录中的工具调用拼接错误: %+v", last)
+	}
+}
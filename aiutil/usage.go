@@ -0,0 +1,196 @@
+package aiutil
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// =================================================================================
+// 用量统计与费用计算
+// =================================================================================
+
+// PromptTokensDetails 是输入 Token 的明细
+type PromptTokensDetails struct {
+	// CachedTokens 是命中服务端提示词缓存的输入 Token 数，已包含在 PromptTokens 中
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// CachedTokens 返回命中缓存的输入 Token 数
+func (u Usage) CachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+// ModelPrice 是一个模型的价格，单位为每百万 Token 的金额，币种由使用者自行约定
+type ModelPrice struct {
+	Input  float64 // 输入 Token 单价
+	Output float64 // 输出 Token 单价
+	// CachedInput 是命中缓存的输入 Token 单价，为 0 时按 Input 计费
+	CachedInput float64
+}
+
+// PriceTable 是按模型名配置的价格表。
+// 查找价格时先精确匹配模型名，再使用最长的前缀匹配，
+// 例如 "gpt-4o" 的价格同样适用于 "gpt-4o-2024-08-06"。
+type PriceTable map[string]ModelPrice
+
+// Lookup 返回模型对应的价格
+func (t PriceTable) Lookup(model string) (ModelPrice, bool) {
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+	var best string
+	for name := range t {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return t[best], true
+}
+
+// Cost 计算一次请求的费用，价格表中没有该模型时返回 0
+func (t PriceTable) Cost(model string, usage Usage) float64 {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0
+	}
+	cached := usage.CachedTokens()
+	cachedPrice := price.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = price.Input
+	}
+	return (float64(usage.PromptTokens-cached)*price.Input +
+		float64(cached)*cachedPrice +
+		float64(usage.CompletionTokens)*price.Output) / 1e6
+}
+
+// UsageStats 是累计的用量
+type UsageStats struct {
+	Requests         int     // 请求次数 (分批的向量嵌入每批计一次)
+	PromptTokens     int     // 输入 Token 数，包含命中缓存的部分
+	CompletionTokens int     // 输出 Token 数
+	CachedTokens     int     // 命中缓存的输入 Token 数
+	TotalTokens      int     // 总 Token 数
+	Cost             float64 // 按 Config.Prices 计算的费用
+}
+
+func (s *UsageStats) add(usage Usage, cost float64) {
+	s.Requests++
+	s.PromptTokens += usage.PromptTokens
+	s.CompletionTokens += usage.CompletionTokens
+	s.CachedTokens += usage.CachedTokens()
+	s.TotalTokens += usage.TotalTokens
+	s.Cost += cost
+}
+
+// UsageEvent 是一次请求完成后交给 Config.OnUsage 的用量记录
+type UsageEvent struct {
+	// ConversationID 是发起请求的会话 ID，不经过会话的请求 (如 CreateEmbeddings) 为空
+	ConversationID string
+	// Operation 是请求的类型: "chat"、"stream"、"embeddings" 或 "transcription"
+	Operation string
+	Model     string
+	Usage     Usage
+	Cost      float64
+}
+
+// usageCounter 是并发安全的用量计数器，同时按模型分别统计
+type usageCounter struct {
+	mu      sync.Mutex
+	total   UsageStats
+	byModel map[string]UsageStats
+}
+
+func (c *usageCounter) add(model string, usage Usage, cost float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total.add(usage, cost)
+	if c.byModel == nil {
+		c.byModel = make(map[string]UsageStats)
+	}
+	stats := c.byModel[model]
+	stats.add(usage, cost)
+	c.byModel[model] = stats
+}
+
+func (c *usageCounter) stats() UsageStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+func (c *usageCounter) statsByModel() map[string]UsageStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[string]UsageStats, len(c.byModel))
+	for model, stats := range c.byModel {
+		result[model] = stats
+	}
+	return result
+}
+
+func (c *usageCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total = UsageStats{}
+	c.byModel = nil
+}
+
+// Usage 返回客户端创建以来 (或上次 ResetUsage 以来) 所有请求的累计用量，包括所有会话
+func (c *Client) Usage() UsageStats {
+	return c.usage.stats()
+}
+
+// UsageByModel 返回按模型名分别统计的累计用量
+func (c *Client) UsageByModel() map[string]UsageStats {
+	return c.usage.statsByModel()
+}
+
+// ResetUsage 清零客户端的累计用量，不影响各会话的用量
+func (c *Client) ResetUsage() {
+	c.usage.reset()
+}
+
+// Usage 返回会话的累计用量，包括截断历史时调用模型生成摘要的请求
+func (cv *Conversation) Usage() UsageStats {
+	return cv.usage.stats()
+}
+
+// conversationKey 是 context 中保存发起请求的会话的键
+type conversationKey struct{}
+
+// withConversation 在 ctx 中记录发起请求的会话，使该请求的用量同时计入会话
+func withConversation(ctx context.Context, cv *Conversation) context.Context {
+	return context.WithValue(ctx, conversationKey{}, cv)
+}
+
+// recordUsage 记录一次请求的用量并调用 Config.OnUsage
+func (c *Client) recordUsage(ctx context.Context, operation, model string, usage Usage) {
+	cost := c.config.Prices.Cost(model, usage)
+	c.usage.add(model, usage, cost)
+
+	event := UsageEvent{Operation: operation, Model: model, Usage: usage, Cost: cost}
+	if cv, ok := ctx.Value(conversationKey{}).(*Conversation); ok {
+		cv.usage.add(model, usage, cost)
+		event.ConversationID = cv.id
+	}
+	if c.config.OnUsage != nil {
+		c.config.OnUsage(event)
+	}
+}
+
+// recordStreamUsage 在流正常结束后记录用量。服务端没有在流中返回 usage 时
+// (例如 OpenAI 未设置 StreamOptions.IncludeUsage) 只计入请求次数。
+func (c *Client) recordStreamUsage(ctx context.Context, model string, acc *StreamAccumulator) {
+	var usage Usage
+	if u := acc.Usage(); u != nil {
+		usage = *u
+	}
+	c.recordUsage(ctx, "stream", model, usage)
+}
@@ -0,0 +1,127 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPriceTable(t *testing.T) {
+	prices := PriceTable{
+		"gpt-4o":      {Input: 2.5, CachedInput: 1.25, Output: 10},
+		"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+	}
+
+	if price, ok := prices.Lookup("gpt-4o-mini-2024-07-18"); !ok || price.Input != 0.15 {
+		t.Errorf("应使用最长的前缀匹配: %+v", price)
+	}
+	if _, ok := prices.Lookup("claude-3"); ok {
+		t.Error("未配置的模型不应匹配")
+	}
+
+	usage := Usage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500, PromptTokensDetails: &PromptTokensDetails{CachedTokens: 400}}
+	want := (600*2.5 + 400*1.25 + 500*10) / 1e6
+	if got := prices.Cost("gpt-4o-2024-08-06", usage); math.Abs(got-want) > 1e-12 {
+		t.Errorf("费用计算错误: 期望 %v，实际 %v", want, got)
+	}
+	// 未配置缓存价格时按输入价格计费
+	if got := prices.Cost("gpt-4o-mini", usage); math.Abs(got-(1000*0.15+500*0.6)/1e6) > 1e-12 {
+		t.Errorf("未配置缓存价格时的费用错误: %v", got)
+	}
+	if got := prices.Cost("claude-3", usage); got != 0 {
+		t.Errorf("未配置的模型费用应为 0，实际 %v", got)
+	}
+}
+
+func TestUsageAccounting(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == DefaultEmbeddingsEndpoint:
+			fmt.Fprint(w, `{"data":[{"index":0,"embedding":[0.1]}],"usage":{"prompt_tokens":4,"total_tokens":4}}`)
+		case decodeRequest(t, r)["stream"] == true:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":20,\"completion_tokens\":5,\"total_tokens\":25}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		default:
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"}}],`+
+				`"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12,"prompt_tokens_details":{"cached_tokens":8}}}`)
+		}
+	}))
+
+	var mu sync.Mutex
+	var events []UsageEvent
+	client.config.Prices = PriceTable{"gpt": {Input: 1, Output: 2}}
+	client.config.OnUsage = func(event UsageEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	ctx := context.Background()
+	conv := client.Conversation("team-a")
+	request := ChatRequest{Model: "gpt", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}
+	if _, err := conv.CreateChatCompletion(ctx, request); err != nil {
+		t.Fatalf("同步请求失败: %v", err)
+	}
+	request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	stream, err := conv.CreateChatCompletionSSEStream(ctx, request)
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+	if _, err := CollectStream(stream); err != nil {
+		t.Fatalf("流中出现错误: %v", err)
+	}
+	if _, err := client.CreateEmbeddings(ctx, EmbeddingRequest{Model: "embed", Input: []string{"a"}}); err != nil {
+		t.Fatalf("向量嵌入请求失败: %v", err)
+	}
+
+	convStats := conv.Usage()
+	if convStats.Requests != 2 || convStats.PromptTokens != 30 || convStats.CompletionTokens != 7 ||
+		convStats.CachedTokens != 8 || convStats.TotalTokens != 37 {
+		t.Errorf("会话用量错误: %+v", convStats)
+	}
+	if want := (30*1 + 7*2) / 1e6; math.Abs(convStats.Cost-want) > 1e-12 {
+		t.Errorf("会话费用错误: 期望 %v，实际 %v", want, convStats.Cost)
+	}
+
+	clientStats := client.Usage()
+	if clientStats.Requests != 3 || clientStats.TotalTokens != 41 {
+		t.Errorf("客户端用量应包含所有请求: %+v", clientStats)
+	}
+	if byModel := client.UsageByModel(); byModel["gpt"].Requests != 2 || byModel["embed"].PromptTokens != 4 {
+		t.Errorf("按模型统计错误: %+v", byModel)
+	}
+	if other := client.Conversation("team-b").Usage(); other.Requests != 0 {
+		t.Errorf("其他会话不应计入用量: %+v", other)
+	}
+
+	mu.Lock()
+	var ops []string
+	for _, event := range events {
+		ops = append(ops, event.Operation+"/"+event.ConversationID)
+	}
+	mu.Unlock()
+	if got := strings.Join(ops, ","); got != "chat/team-a,stream/team-a,embeddings/" {
+		t.Errorf("OnUsage 事件错误: %s", got)
+	}
+
+	client.ResetUsage()
+	if stats := client.Usage(); stats.Requests != 0 || len(client.UsageByModel()) != 0 {
+		t.Errorf("ResetUsage 后用量应清零: %+v", stats)
+	}
+	if conv.Usage().Requests != 2 {
+		t.Error("ResetUsage 不应影响会话的用量")
+	}
+}
+
+func TestAnthropicUsage_CacheTokens(t *testing.T) {
+	usage := anthropicUsage{InputTokens: 10, OutputTokens: 5, CacheReadInputTokens: 100, CacheCreationInputTokens: 20}.usage()
+	if usage.PromptTokens != 130 || usage.TotalTokens != 135 || usage.CachedTokens() != 100 {
+		t.Errorf("Anthropic 缓存用量转换错误: %+v", usage)
+	}
+}
@@ -0,0 +1,142 @@
+package aiutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// =================================================================================
+// WebSocket 连接
+// =================================================================================
+
+// WebSocketConn 是一个 WebSocket 连接，按消息 (帧) 收发数据。
+// Close 可能与 Send、Receive 并发调用，用于在 ctx 取消时中断阻塞的读取。
+type WebSocketConn interface {
+	// Send 发送一条消息
+	Send(message []byte) error
+	// Receive 阻塞直到收到下一条消息，连接正常关闭时返回 io.EOF
+	Receive() ([]byte, error)
+	Close() error
+}
+
+// WebSocketPinger 由能够发送 ping 控制帧的 WebSocketConn 实现，用于 WebSocketOptions.PingInterval 保活。
+// Ping 会与 Receive 并发调用。
+type WebSocketPinger interface {
+	Ping() error
+}
+
+// WebSocketDialer 建立 WebSocket 连接。header 中包含 Config.DefaultHeaders。
+// 可以通过 Config.WebSocketDialer 替换，例如在测试中录制或回放 WebSocket 消息。
+type WebSocketDialer func(ctx context.Context, url, origin string, header http.Header) (WebSocketConn, error)
+
+// DefaultWebSocketDialer 使用 golang.org/x/net/websocket 建立连接
+func DefaultWebSocketDialer(ctx context.Context, url, origin string, header http.Header) (WebSocketConn, error) {
+	config, err := websocket.NewConfig(url, origin)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range header {
+		for _, v := range values {
+			config.Header.Add(k, v)
+		}
+	}
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &xnetWebSocketConn{conn: conn}, nil
+}
+
+type xnetWebSocketConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // 保护 Ping 对 conn.PayloadType 的修改
+}
+
+func (c *xnetWebSocketConn) Send(message []byte) error {
+	return websocket.Message.Send(c.conn, message)
+}
+
+// Ping 发送一个 ping 控制帧，服务端回复的 pong 由 golang.org/x/net/websocket 在读取时丢弃
+func (c *xnetWebSocketConn) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.PayloadType = websocket.PingFrame
+	_, err := c.conn.Write(nil)
+	c.conn.PayloadType = websocket.TextFrame
+	return err
+}
+
+func (c *xnetWebSocketConn) Receive() ([]byte, error) {
+	var message []byte
+	err := websocket.Message.Receive(c.conn, &message)
+	return message, err
+}
+
+func (c *xnetWebSocketConn) Close() error {
+	return c.conn.Close()
+}
+
+// ---------------------------------------------------------------------------------
+// 流式接收的配置
+// ---------------------------------------------------------------------------------
+
+// ErrStreamTimeout 表示 WebSocket 流在 WebSocketOptions 的 ReadTimeout 或 IdleTimeout 内没有收到消息
+var ErrStreamTimeout = errors.New("aiutil: stream timed out")
+
+// WebSocketFrameDecoder 解码 WebSocket 收到的一条消息。
+// done 为 true 表示流已结束 (chunk 不为 nil 时先处理该数据块)，不再等待服务端关闭连接；
+// chunk 为 nil 且 done 为 false 时忽略该消息 (例如心跳)；返回错误时结束流并将错误交给调用方。
+type WebSocketFrameDecoder func(message []byte) (chunk *ChatStreamResponse, done bool, err error)
+
+// WebSocketOptions 是 WebSocket 流式请求的配置
+type WebSocketOptions struct {
+	// Decoder 解码服务端的消息，为空时使用 DefaultWebSocketFrameDecoder
+	Decoder WebSocketFrameDecoder
+	// ReadTimeout 是发送请求后等待第一条消息的最长时间 (包括模型的首字延迟)，0 表示不限制
+	ReadTimeout time.Duration
+	// IdleTimeout 是收到第一条消息后两条消息之间的最长间隔，0 表示不限制。
+	// 超时后关闭连接并返回 ErrStreamTimeout (配置了 Resume 时先尝试重连)。
+	IdleTimeout time.Duration
+	// PingInterval 是发送 ping 控制帧的间隔，用于防止代理或负载均衡器关闭空闲的连接，0 表示不发送。
+	// 只对实现了 WebSocketPinger 的连接生效 (DefaultWebSocketDialer 建立的连接已实现)。
+	PingInterval time.Duration
+	// RequireDone 为 true 时，没有收到结束消息 (Decoder 返回 done) 就关闭的连接视为中断，
+	// 为 false 时服务端关闭连接即表示流正常结束。
+	RequireDone bool
+	// Resume 在连接中断 (读取失败、超时或 RequireDone 时提前关闭) 后生成重新连接时发送的第一条消息，
+	// request 是发送给该端点的请求，partial 是已经收到的回复。为空时不重连，直接返回错误。
+	// 续传的协议由服务端决定，例如发送带有已接收长度的恢复消息，或者在消息末尾追加 partial 请求模型续写。
+	Resume func(request ChatRequest, partial ChatMessage) ([]byte, error)
+	// MaxResumes 是一次请求中最多重连的次数，<=0 时为 1
+	MaxResumes int
+}
+
+// DefaultWebSocketFrameDecoder 按 OpenAI 的流式数据块格式解码消息:
+// "[DONE]" 表示流结束，空消息被忽略，包含 "error" 字段的消息解码为 *APIError
+func DefaultWebSocketFrameDecoder(message []byte) (*ChatStreamResponse, bool, error) {
+	message = bytes.TrimSpace(message)
+	if len(message) == 0 {
+		return nil, false, nil
+	}
+	if bytes.Equal(message, []byte("[DONE]")) {
+		return nil, true, nil
+	}
+	var frame struct {
+		ChatStreamResponse
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(message, &frame); err != nil {
+		return nil, false, err
+	}
+	if len(frame.Error) > 0 && !bytes.Equal(frame.Error, []byte("null")) {
+		return nil, false, parseAPIErrorBody(message)
+	}
+	return &frame.ChatStreamResponse, false, nil
+}
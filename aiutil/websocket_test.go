@@ -0,0 +1,303 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// sendChunk 通过 WebSocket 发送一个 OpenAI 格式的数据块
+func sendChunk(ws *websocket.Conn, content string) {
+	_ = websocket.Message.Send(ws, fmt.Sprintf(`{"choices":[{"index":0,"delta":{"content":%q}}]}`, content))
+}
+
+// waitClientClose 阻塞直到客户端关闭连接
+func waitClientClose(ws *websocket.Conn) {
+	var message []byte
+	for websocket.Message.Receive(ws, &message) == nil {
+	}
+}
+
+// collectWebSocket 发起 WebSocket 流式请求并收集全部内容和第一个错误
+func collectWebSocket(t *testing.T, client *Client) (string, error) {
+	t.Helper()
+	stream, err := client.CreateChatCompletionWebSocketStream(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("WebSocket 流式请求失败: %v", err)
+	}
+	var sb strings.Builder
+	var firstErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range stream {
+			if event.Error != nil && firstErr == nil {
+				firstErr = event.Error
+			}
+			if len(event.Data.Choices) > 0 {
+				sb.WriteString(event.Data.Choices[0].Delta.Content)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("WebSocket 流没有及时结束")
+	}
+	return sb.String(), firstErr
+}
+
+func TestWebSocketStream_DoneAndErrorFrames(t *testing.T) {
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		var req ChatRequest
+		_ = json.Unmarshal(raw, &req)
+		if req.Messages[0].Content == "fail" {
+			_ = websocket.Message.Send(ws, `{"error":{"message":"overloaded","type":"server_error","code":"overloaded"}}`)
+		} else {
+			sendChunk(ws, "foo")
+			sendChunk(ws, "bar")
+			_ = websocket.Message.Send(ws, "[DONE]")
+		}
+		// 发送结束消息后不关闭连接，客户端应自行结束
+		waitClientClose(ws)
+	}))
+
+	content, err := collectWebSocket(t, client)
+	if err != nil || content != "foobar" {
+		t.Fatalf("收到 [DONE] 后流应正常结束: %q, %v", content, err)
+	}
+	if history := client.GetHistory(); len(history) != 2 || history[1].Content != "foobar" {
+		t.Errorf("流结束后应写入历史记录: %+v", history)
+	}
+
+	client.ClearHistory()
+	stream, _ := client.CreateChatCompletionWebSocketStream(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "fail"}},
+	})
+	_, err = CollectStream(stream)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "overloaded" || apiErr.Message != "overloaded" {
+		t.Errorf("错误消息应解码为 APIError，实际 %v", err)
+	}
+}
+
+func TestWebSocketStream_CustomDecoder(t *testing.T) {
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		for _, frame := range []string{`{"type":"text","text":"你"}`, `{"type":"heartbeat"}`, `{"type":"text","text":"好"}`, `{"type":"end"}`} {
+			_ = websocket.Message.Send(ws, frame)
+		}
+		waitClientClose(ws)
+	}))
+	client.config.WebSocket.Decoder = func(message []byte) (*ChatStreamResponse, bool, error) {
+		var frame struct{ Type, Text string }
+		if err := json.Unmarshal(message, &frame); err != nil {
+			return nil, false, err
+		}
+		switch frame.Type {
+		case "text":
+			return &ChatStreamResponse{Choices: []ChatStreamChoice{{Delta: ChatDelta{Content: frame.Text}}}}, false, nil
+		case "end":
+			return nil, true, nil
+		}
+		return nil, false, nil
+	}
+
+	if content, err := collectWebSocket(t, client); err != nil || content != "你好" {
+		t.Errorf("自定义解码结果错误: %q, %v", content, err)
+	}
+}
+
+func TestWebSocketStream_Timeouts(t *testing.T) {
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		var req ChatRequest
+		_ = json.Unmarshal(raw, &req)
+		if req.Messages[0].Content != "silent" {
+			sendChunk(ws, "partial")
+		}
+		waitClientClose(ws)
+	}))
+	client.config.WebSocket = WebSocketOptions{ReadTimeout: 200 * time.Millisecond, IdleTimeout: 50 * time.Millisecond}
+
+	// 1. 收到第一条消息后超过 IdleTimeout 没有新消息
+	content, err := collectWebSocket(t, client)
+	if !errors.Is(err, ErrStreamTimeout) || content != "partial" {
+		t.Errorf("应返回 ErrStreamTimeout: %q, %v", content, err)
+	}
+	if history := client.GetHistory(); len(history) != 0 {
+		t.Errorf("超时的流不应写入历史记录: %+v", history)
+	}
+
+	// 2. 发送请求后超过 ReadTimeout 没有收到任何消息
+	start := time.Now()
+	stream, _ := client.CreateChatCompletionWebSocketStream(context.Background(), ChatRequest{
+		Model:    "test-model",
+		Messages: []ChatMessage{{Role: RoleUser, Content: "silent"}},
+	})
+	if _, err := CollectStream(stream); !errors.Is(err, ErrStreamTimeout) {
+		t.Errorf("应返回 ErrStreamTimeout，实际 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("第一条消息之前应使用 ReadTimeout，实际 %s 后超时", elapsed)
+	}
+}
+
+func TestWebSocketStream_SlowReader(t *testing.T) {
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		for _, part := range []string{"a", "b", "c"} {
+			sendChunk(ws, part)
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = websocket.Message.Send(ws, "[DONE]")
+		waitClientClose(ws)
+	}))
+	client.config.WebSocket = WebSocketOptions{IdleTimeout: 30 * time.Millisecond}
+
+	stream, err := client.CreateChatCompletionWebSocketStream(context.Background(), ChatRequest{Model: "test-model"})
+	if err != nil {
+		t.Fatalf("WebSocket 流式请求失败: %v", err)
+	}
+	// 调用方读取比 IdleTimeout 慢时，服务端并没有空闲，不应超时
+	var sb strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			t.Fatalf("读取较慢时不应超时: %v", event.Error)
+		}
+		sb.WriteString(event.Data.Choices[0].Delta.Content)
+		time.Sleep(60 * time.Millisecond)
+	}
+	if sb.String() != "abc" {
+		t.Errorf("流式内容错误: %q", sb.String())
+	}
+}
+
+// countingPingConn 统计 Ping 的次数
+type countingPingConn struct {
+	WebSocketConn
+	pings *atomic.Int32
+}
+
+func (c *countingPingConn) Ping() error {
+	c.pings.Add(1)
+	return c.WebSocketConn.(WebSocketPinger).Ping()
+}
+
+func TestWebSocketStream_Ping(t *testing.T) {
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		for _, part := range []string{"a", "b", "c"} {
+			time.Sleep(30 * time.Millisecond)
+			sendChunk(ws, part)
+		}
+		_ = websocket.Message.Send(ws, "[DONE]")
+		waitClientClose(ws)
+	}))
+	var pings atomic.Int32
+	client.config.WebSocket = WebSocketOptions{PingInterval: 10 * time.Millisecond, IdleTimeout: time.Second}
+	client.config.WebSocketDialer = func(ctx context.Context, url, origin string, header http.Header) (WebSocketConn, error) {
+		conn, err := DefaultWebSocketDialer(ctx, url, origin, header)
+		if err != nil {
+			return nil, err
+		}
+		return &countingPingConn{WebSocketConn: conn, pings: &pings}, nil
+	}
+
+	// 服务端自动回复的 pong 不应被当作数据
+	if content, err := collectWebSocket(t, client); err != nil || content != "abc" {
+		t.Errorf("发送 ping 时流式内容错误: %q, %v", content, err)
+	}
+	if pings.Load() < 3 {
+		t.Errorf("应按 PingInterval 发送 ping，实际 %d 次", pings.Load())
+	}
+}
+
+// failingSendConn 发送一段时间后失败，并统计 Ping 的次数
+type failingSendConn struct {
+	pings atomic.Int32
+}
+
+func (c *failingSendConn) Send([]byte) error {
+	time.Sleep(20 * time.Millisecond)
+	return errors.New("broken pipe")
+}
+func (c *failingSendConn) Receive() ([]byte, error) { return nil, io.EOF }
+func (c *failingSendConn) Close() error             { return nil }
+func (c *failingSendConn) Ping() error              { c.pings.Add(1); return nil }
+
+func TestWebSocketStream_NoPingAfterSendError(t *testing.T) {
+	client := newTestClient(t, http.NotFoundHandler())
+	client.config.WebSocket = WebSocketOptions{PingInterval: time.Millisecond}
+
+	conn := &failingSendConn{}
+	var acc StreamAccumulator
+	if _, err := client.receiveWebSocket(context.Background(), conn, []byte("{}"), &acc, make(chan StreamEvent, 1)); err == nil {
+		t.Fatal("发送失败时应返回错误")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := conn.pings.Load(); n != 0 {
+		t.Errorf("请求发送成功之前不应发送 ping，实际 %d 次", n)
+	}
+}
+
+func TestWebSocketStream_Resume(t *testing.T) {
+	var connections atomic.Int32
+	var resumeMessage string
+	client := newTestClient(t, websocket.Handler(func(ws *websocket.Conn) {
+		var raw []byte
+		_ = websocket.Message.Receive(ws, &raw)
+		if connections.Add(1) == 1 {
+			// 第一次连接发送部分回复后断开，没有结束消息
+			sendChunk(ws, "foo")
+			return
+		}
+		resumeMessage = string(raw)
+		sendChunk(ws, "bar")
+		_ = websocket.Message.Send(ws, "[DONE]")
+	}))
+	client.config.WebSocket = WebSocketOptions{
+		RequireDone: true,
+		Resume: func(request ChatRequest, partial ChatMessage) ([]byte, error) {
+			return json.Marshal(map[string]any{"model": request.Model, "resume_from": len(partial.Content)})
+		},
+	}
+
+	content, err := collectWebSocket(t, client)
+	if err != nil || content != "foobar" {
+		t.Fatalf("重连后应继续接收: %q, %v", content, err)
+	}
+	if connections.Load() != 2 || resumeMessage != `{"model":"test-model","resume_from":3}` {
+		t.Errorf("续传消息错误: 连接 %d 次, %s", connections.Load(), resumeMessage)
+	}
+	if history := client.GetHistory(); len(history) != 2 || history[1].Content != "foobar" {
+		t.Errorf("历史记录应包含完整回复: %+v", history)
+	}
+
+	// 没有配置 Resume 时，提前关闭的连接返回错误
+	client.ClearHistory()
+	connections.Store(0)
+	client.config.WebSocket.Resume = nil
+	if content, err := collectWebSocket(t, client); err == nil || content != "foo" {
+		t.Errorf("没有收到结束消息时应返回错误: %q, %v", content, err)
+	}
+}
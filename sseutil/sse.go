@@ -0,0 +1,228 @@
+package sseutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxEventSize 是单个事件 (所有字段行之和) 默认允许的最大字节数
+const DefaultMaxEventSize = 1 << 20
+
+// ErrEventTooLarge 表示单个事件超过了 Decoder.MaxEventSize
+var ErrEventTooLarge = errors.New("sseutil: event too large")
+
+// Event 是一个 Server-Sent Events 事件
+type Event struct {
+	ID    string        // 最近一次 id 字段的值，按规范在后续事件中保持不变
+	Event string        // 事件类型，为空时按规范视为 "message"
+	Data  string        // 多个 data 行以 "\n" 连接
+	Retry time.Duration // retry 字段指定的重连间隔，0 表示未设置
+}
+
+// Decoder 按照 WHATWG HTML 规范解析 SSE 流:
+//   - 支持 CRLF、LF、CR 三种换行，忽略开头的 UTF-8 BOM
+//   - 多个 data 行合并为一个事件，支持 event、id、retry 字段，忽略以 ":" 开头的注释行
+//   - 空行触发事件分发；流在事件中途结束时，不完整的事件被丢弃
+//
+// 与 bufio.Scanner 不同，Decoder 没有 64KB 的单行限制，单个事件的大小由 MaxEventSize 控制。
+//
+// 使用示例
+//
+//	decoder := sseutil.NewDecoder(resp.Body)
+//	for {
+//		event, err := decoder.Decode()
+//		if err == io.EOF {
+//			break
+//		}
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(event.Event, event.Data)
+//	}
+type Decoder struct {
+	// MaxEventSize 是单个事件允许的最大字节数，<=0 时使用 DefaultMaxEventSize
+	MaxEventSize int
+
+	r       *bufio.Reader
+	line    []byte
+	lastID  string
+	started bool // 是否已经处理过开头的 BOM
+	skipLF  bool // 上一行以 CR 结束，需要跳过紧随其后的 LF
+	err     error
+}
+
+// NewDecoder 创建一个从 r 读取事件的 Decoder
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// LastEventID 返回最近一次 id 字段的值，可用于断线重连时设置 Last-Event-ID 请求头
+func (d *Decoder) LastEventID() string {
+	return d.lastID
+}
+
+// Decode 读取下一个事件。流正常结束时返回 io.EOF，读取出错或事件过大时返回对应的错误，
+// 之后的调用总是返回同一个错误。
+func (d *Decoder) Decode() (*Event, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	event, err := d.decode()
+	if err != nil {
+		d.err = err
+	}
+	return event, err
+}
+
+func (d *Decoder) decode() (*Event, error) {
+	maxSize := d.MaxEventSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxEventSize
+	}
+
+	var data strings.Builder
+	var eventType string
+	var retry time.Duration
+	hasData := false
+	size := 0
+
+	for {
+		line, err := d.readLine(maxSize - size)
+		if err != nil {
+			// 流在事件中途结束时，不完整的事件按规范被丢弃
+			return nil, err
+		}
+		size += len(line) + 1
+
+		// 空行: 分发事件
+		if len(line) == 0 {
+			if !hasData {
+				eventType, retry, size = "", 0, 0
+				continue
+			}
+			return &Event{ID: d.lastID, Event: eventType, Data: data.String(), Retry: retry}, nil
+		}
+		// 注释行
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+		switch string(field) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				d.lastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine 读取一行 (不含换行符)，行长度超过 limit 时返回 ErrEventTooLarge
+func (d *Decoder) readLine(limit int) ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		if _, err := d.r.Peek(1); err != nil {
+			// 最后一行没有换行符时同样丢弃，它不可能完成一个事件
+			return nil, err
+		}
+		buf, _ := d.r.Peek(d.r.Buffered())
+
+		if d.skipLF {
+			d.skipLF = false
+			if buf[0] == '\n' {
+				_, _ = d.r.Discard(1)
+				continue
+			}
+		}
+		if !d.started {
+			d.started = true
+			if bytes.HasPrefix(buf, []byte("\xEF\xBB\xBF")) {
+				_, _ = d.r.Discard(3)
+				continue
+			}
+		}
+
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			d.line = append(d.line, buf...)
+			_, _ = d.r.Discard(len(buf))
+		} else {
+			d.line = append(d.line, buf[:i]...)
+			d.skipLF = buf[i] == '\r'
+			_, _ = d.r.Discard(i + 1)
+		}
+		if len(d.line) > limit {
+			return nil, ErrEventTooLarge
+		}
+		if i >= 0 {
+			return d.line, nil
+		}
+	}
+}
+
+// Encoder 将事件写为 SSE 格式，用于实现 SSE 服务端
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder 创建一个写入 w 的 Encoder。w 实现了 http.Flusher 时，每个事件写出后立即刷新。
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode 写出一个事件，Data 中的换行会被拆分为多个 data 行
+func (e *Encoder) Encode(event Event) error {
+	var buf bytes.Buffer
+	if event.ID != "" {
+		buf.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		buf.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteByte('\n')
+	return e.write(buf.Bytes())
+}
+
+// Comment 写出一行注释，常用于保持连接活跃
+func (e *Encoder) Comment(text string) error {
+	return e.write([]byte(": " + text + "\n\n"))
+}
+
+func (e *Encoder) write(b []byte) error {
+	if _, err := e.w.Write(b); err != nil {
+		return err
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package sseutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeAll 读取流中的全部事件
+func decodeAll(t *testing.T, d *Decoder) ([]*Event, error) {
+	t.Helper()
+	var events []*Event
+	for {
+		event, err := d.Decode()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	stream := "\xEF\xBB\xBF: 注释行\n" +
+		"event: add\n" +
+		"id: 1\n" +
+		"retry: 3000\n" +
+		"data: 第一行\n" +
+		"data:第二行\n" +
+		"data\n" +
+		"\n" +
+		"data: 无事件类型\r\n\r\n" +
+		"event: ignored\n\n" + // 没有 data 的事件不分发
+		"id: 2\rdata: CR 换行\r\r" +
+		"data: 未结束的事件"
+
+	events, err := decodeAll(t, NewDecoder(strings.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("期望 3 个事件，实际 %d: %+v", len(events), events)
+	}
+
+	want := Event{ID: "1", Event: "add", Data: "第一行\n第二行\n", Retry: 3 * time.Second}
+	if *events[0] != want {
+		t.Errorf("第一个事件错误: %+v", events[0])
+	}
+	if events[1].Event != "" || events[1].Data != "无事件类型" || events[1].ID != "1" {
+		t.Errorf("第二个事件错误: %+v", events[1])
+	}
+	if events[2].ID != "2" || events[2].Data != "CR 换行" {
+		t.Errorf("第三个事件错误: %+v", events[2])
+	}
+}
+
+func TestDecoder_IDAndRetry(t *testing.T) {
+	stream := "id: a\x00b\nretry: 1s\ndata: x\n\nid\ndata: y\n\n"
+	d := NewDecoder(strings.NewReader(stream))
+	events, err := decodeAll(t, d)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("解析失败: %v, %+v", err, events)
+	}
+	if events[0].ID != "" || events[0].Retry != 0 {
+		t.Errorf("包含 NUL 的 id 和非数字的 retry 应被忽略: %+v", events[0])
+	}
+	if events[1].ID != "" || d.LastEventID() != "" {
+		t.Errorf("空 id 应重置 LastEventID: %+v", events[1])
+	}
+}
+
+func TestDecoder_MaxEventSize(t *testing.T) {
+	big := strings.Repeat("x", 200*1024)
+	stream := "data: " + big + "\n\n"
+
+	events, err := decodeAll(t, NewDecoder(strings.NewReader(stream)))
+	if err != nil || len(events) != 1 || events[0].Data != big {
+		t.Fatalf("默认配置应能读取超过 64KB 的事件: %v", err)
+	}
+
+	d := NewDecoder(strings.NewReader(stream + "data: next\n\n"))
+	d.MaxEventSize = 1024
+	if _, err := d.Decode(); !errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("期望 ErrEventTooLarge，实际 %v", err)
+	}
+	if _, err := d.Decode(); !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("错误之后应继续返回同一个错误，实际 %v", err)
+	}
+
+	// 多行之和同样受限制
+	d = NewDecoder(strings.NewReader(strings.Repeat("data: 0123456789\n", 100) + "\n"))
+	d.MaxEventSize = 512
+	if _, err := d.Decode(); !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("多行事件超出限制时期望 ErrEventTooLarge，实际 %v", err)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	_ = enc.Comment("keep-alive")
+	_ = enc.Encode(Event{ID: "7", Event: "delta", Data: "a\nb", Retry: 500 * time.Millisecond})
+
+	want := ": keep-alive\n\nid: 7\nevent: delta\nretry: 500\ndata: a\ndata: b\n\n"
+	if buf.String() != want {
+		t.Fatalf("编码结果错误: %q", buf.String())
+	}
+
+	event, err := NewDecoder(&buf).Decode()
+	if err != nil || event.Data != "a\nb" || event.Event != "delta" || event.ID != "7" {
+		t.Errorf("编码后应能被解码: %+v, %v", event, err)
+	}
+}